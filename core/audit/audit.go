@@ -0,0 +1,169 @@
+// Package audit records every mutating API call to a rotating JSON-lines file
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+const (
+	// Name of the audit log currently being written to
+	currentFile = "audit.jsonl"
+
+	defaultMaxSize  = 10 * 1024 * 1024
+	defaultMaxFiles = 10
+)
+
+type Entry struct {
+	Time       time.Time `json:"time"`
+	UserID     string    `json:"user_id"`
+	Plugin     string    `json:"plugin"`
+	Route      string    `json:"route"`
+	Method     string    `json:"method"`
+	Query      string    `json:"query"`       // Query string with secrets redacted
+	BodyDigest string    `json:"body_digest"` // Hex encoded SHA-256 of the request body, empty if there was no body or it was larger than 32 MB
+	Status     int       `json:"status"`
+	RemoteAddr string    `json:"remote_addr"`
+}
+
+var (
+	cfg types.AuditConfig
+	mu  sync.Mutex
+)
+
+// Sets up the audit log. Auditing is disabled if c.Dir is empty
+func Init(c types.AuditConfig) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultMaxSize
+	}
+
+	if c.MaxFiles <= 0 {
+		c.MaxFiles = defaultMaxFiles
+	}
+
+	if c.Dir != "" {
+		err := os.MkdirAll(c.Dir, 0700)
+
+		if err != nil {
+			return errors.New("Failed to create audit directory: " + err.Error())
+		}
+	}
+
+	cfg = c
+
+	return nil
+}
+
+// Returns whether auditing is enabled
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return cfg.Dir != ""
+}
+
+// Appends a entry to the audit log, rotating it if needed
+func Record(e Entry) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if cfg.Dir == "" {
+		return nil
+	}
+
+	path := filepath.Join(cfg.Dir, currentFile)
+
+	if st, err := os.Stat(path); err == nil && st.Size() >= cfg.MaxSize {
+		err = rotate()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	bytes, err := json.Marshal(e)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+
+	if err != nil {
+		return errors.New("Failed to open audit log: " + err.Error())
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(append(bytes, '\n'))
+
+	return err
+}
+
+// Moves the current audit log aside and removes rotated logs past MaxFiles. Callers must hold mu
+func rotate() error {
+	rotated := "audit-" + time.Now().UTC().Format("20060102T150405.000000000") + ".jsonl"
+
+	err := os.Rename(filepath.Join(cfg.Dir, currentFile), filepath.Join(cfg.Dir, rotated))
+
+	if err != nil {
+		return errors.New("Failed to rotate audit log: " + err.Error())
+	}
+
+	files, err := rotatedFiles(cfg.Dir)
+
+	if err != nil {
+		return err
+	}
+
+	for len(files) > cfg.MaxFiles {
+		err = os.Remove(filepath.Join(cfg.Dir, files[0]))
+
+		if err != nil {
+			return errors.New("Failed to remove old audit log: " + err.Error())
+		}
+
+		files = files[1:]
+	}
+
+	return nil
+}
+
+// Returns the names of all rotated audit logs in dir, oldest first
+func rotatedFiles(dir string) ([]string, error) {
+	fsd, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, errors.New("Failed to read audit directory: " + err.Error())
+	}
+
+	var files []string
+
+	for _, file := range fsd {
+		if file.IsDir() || file.Name() == currentFile {
+			continue
+		}
+
+		if !strings.HasPrefix(file.Name(), "audit-") || !strings.HasSuffix(file.Name(), ".jsonl") {
+			continue
+		}
+
+		files = append(files, file.Name())
+	}
+
+	// The timestamp in the name sorts chronologically
+	sort.Strings(files)
+
+	return files, nil
+}
@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+)
+
+// Query parameters containing any of these are redacted in the audit log
+var secretParams = []string{"token", "secret", "password"}
+
+// Request bodies are hashed up to this size, so that large uploads are not kept in memory
+const maxDigestBody = 32 << 20
+
+// Returns the query string of a url with secrets redacted
+func redactQuery(q url.Values) string {
+	for k := range q {
+		lower := strings.ToLower(k)
+
+		for _, s := range secretParams {
+			if strings.Contains(lower, s) {
+				q[k] = []string{"REDACTED"}
+				break
+			}
+		}
+	}
+
+	return q.Encode()
+}
+
+// A request body reading from Reader and closed with c
+type readCloser struct {
+	io.Reader
+	c io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.c.Close()
+}
+
+// Records mutating API calls. Must be loaded after plugins.AuthMiddleware has authenticated the request
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || plugins.IsReadOnly(r) || !Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var digest string
+
+		if r.Body != nil {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxDigestBody+1))
+
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Failed to read request body: " + err.Error()))
+				return
+			}
+
+			switch {
+			case len(body) > maxDigestBody:
+				// Not hashed, the handler reads what was buffered followed by the rest of the body
+				r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			case len(body) > 0:
+				sum := sha256.Sum256(body)
+				digest = hex.EncodeToString(sum[:])
+				r.Body.Close()
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+		}
+
+		entry := Entry{
+			Time:       time.Now(),
+			UserID:     plugins.UserID(r),
+			Route:      r.URL.Path,
+			Method:     r.Method,
+			Query:      redactQuery(r.URL.Query()),
+			BodyDigest: digest,
+			RemoteAddr: r.RemoteAddr,
+		}
+
+		// Path is /api/<plugin>/<route>
+		if parts := strings.SplitN(r.URL.Path, "/", 4); len(parts) > 2 {
+			entry.Plugin = parts[2]
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			entry.Status = ww.Status()
+
+			rec := recover()
+
+			if rec != nil {
+				// Recorded as the 500 middleware.Recoverer will send
+				entry.Status = http.StatusInternalServerError
+			} else if entry.Status == 0 {
+				// Nothing was written, net/http sends a 200
+				entry.Status = http.StatusOK
+			}
+
+			err := Record(entry)
+
+			if err != nil {
+				fmt.Println("audit: failed to record entry: " + err.Error())
+			}
+
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
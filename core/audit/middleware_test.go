@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+func TestMiddleware(t *testing.T) {
+	err := Init(types.AuditConfig{Dir: t.TempDir()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		Init(types.AuditConfig{})
+	})
+
+	plugins.AddReadOnlyRoutes("test", http.MethodPost, "/getThings")
+
+	small := []byte(`{"name":"site"}`)
+	smallSum := sha256.Sum256(small)
+	large := bytes.Repeat([]byte("a"), maxDigestBody+10)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     []byte
+		handler  http.HandlerFunc
+		panics   bool
+		recorded bool
+		status   int
+		digest   string
+	}{
+		{
+			name:     "mutating route",
+			method:   http.MethodPost,
+			path:     "/api/test/createThing",
+			body:     small,
+			recorded: true,
+			status:   http.StatusOK,
+			digest:   hex.EncodeToString(smallSum[:]),
+		},
+		{
+			name:   "declared read-only route",
+			method: http.MethodPost,
+			path:   "/api/test/getThings",
+		},
+		{
+			name:     "undeclared route named get",
+			method:   http.MethodPost,
+			path:     "/api/test/getAndDeleteThings",
+			recorded: true,
+			status:   http.StatusOK,
+		},
+		{
+			name:   "GET request",
+			method: http.MethodGet,
+			path:   "/api/test/createThing",
+		},
+		{
+			name:   "error status",
+			method: http.MethodPost,
+			path:   "/api/test/createThing",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+			},
+			recorded: true,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:   "panic",
+			method: http.MethodPost,
+			path:   "/api/test/createThing",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			panics:   true,
+			recorded: true,
+			status:   http.StatusInternalServerError,
+		},
+		{
+			name:   "body larger than the digest limit",
+			method: http.MethodPost,
+			path:   "/api/test/upload",
+			body:   large,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+
+				if !bytes.Equal(b, large) {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			},
+			recorded: true,
+			status:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := Query(Filter{})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handler := tt.handler
+
+			if handler == nil {
+				// Echoes the body back so the test can check it was passed through
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					io.Copy(w, r.Body)
+				}
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			func() {
+				defer func() {
+					if rec := recover(); (rec != nil) != tt.panics {
+						t.Fatalf("got panic %v, want panic: %v", rec, tt.panics)
+					}
+				}()
+
+				Middleware(handler).ServeHTTP(w, req)
+			}()
+
+			if tt.handler == nil && !bytes.Equal(w.Body.Bytes(), tt.body) {
+				t.Fatal("handler did not get the request body")
+			}
+
+			after, err := Query(Filter{})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.recorded {
+				if len(after) != len(before) {
+					t.Fatalf("request was audited: %+v", after[len(after)-1])
+				}
+
+				return
+			}
+
+			if len(after) != len(before)+1 {
+				t.Fatalf("got %d new entries, want 1", len(after)-len(before))
+			}
+
+			// Query returns the newest entry first
+			e := after[0]
+
+			if e.Route != tt.path || e.Plugin != "test" || e.Status != tt.status || e.BodyDigest != tt.digest {
+				t.Fatalf("got entry %+v, want route %s, status %d and digest %q", e, tt.path, tt.status, tt.digest)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Filters for Query. Zero values match everything
+type Filter struct {
+	UserID string    `json:"user_id"`
+	Plugin string    `json:"plugin"`
+	Route  string    `json:"route"` // Matches entries whose route contains this
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Limit  int       `json:"limit"` // Maximum number of entries to return, 0 for no limit
+}
+
+func (f Filter) matches(e Entry) bool {
+	if f.UserID != "" && e.UserID != f.UserID {
+		return false
+	}
+
+	if f.Plugin != "" && e.Plugin != f.Plugin {
+		return false
+	}
+
+	if f.Route != "" && !strings.Contains(e.Route, f.Route) {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// Reads all entries in a audit log matching f, oldest first
+func readFile(path string, f Filter) ([]Entry, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, errors.New("Failed to open audit log: " + err.Error())
+	}
+
+	defer file.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var e Entry
+
+		err = json.Unmarshal(scanner.Bytes(), &e)
+
+		if err != nil {
+			continue // Skip partially written lines
+		}
+
+		if f.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// Searches the audit log in dir (including rotated logs), returning matching entries newest first
+func QueryDir(dir string, f Filter) ([]Entry, error) {
+	files, err := rotatedFiles(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest file first
+	files = append(files, currentFile)
+
+	res := []Entry{}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		entries, err := readFile(filepath.Join(dir, files[i]), f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for j := len(entries) - 1; j >= 0; j-- {
+			res = append(res, entries[j])
+
+			if f.Limit > 0 && len(res) >= f.Limit {
+				return res, nil
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// Searches the audit log set up by Init
+func Query(f Filter) ([]Entry, error) {
+	mu.Lock()
+	dir := cfg.Dir
+	mu.Unlock()
+
+	if dir == "" {
+		return nil, errors.New("auditing is not enabled")
+	}
+
+	return QueryDir(dir, f)
+}
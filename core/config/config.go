@@ -0,0 +1,47 @@
+// Package config loads config.yaml
+//
+// String values may reference environment variables and files using ${ENV:NAME}
+// and ${FILE:/path/to/file}, these are resolved when the config is loaded
+package config
+
+import (
+	"errors"
+	"os"
+
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+// The path to the config file, relative to the working directory
+const Path = "config.yaml"
+
+// Loads the config file at path
+func Load(path string) (*types.Config, error) {
+	config, _, err := LoadWithSources(path)
+	return config, err
+}
+
+// Loads the config file at path, also returning the values that were resolved
+// from environment variables or files
+func LoadWithSources(path string) (*types.Config, []Resolved, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, nil, errors.New("Failed to open config: " + err.Error())
+	}
+
+	defer file.Close()
+
+	var config *types.Config
+
+	resolved, err := Decode(file, &config)
+
+	if err != nil {
+		return nil, nil, errors.New("Failed to decode config: " + err.Error())
+	}
+
+	if config == nil {
+		return nil, nil, errors.New("config is empty")
+	}
+
+	return config, resolved, nil
+}
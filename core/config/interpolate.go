@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matches ${ENV:NAME} and ${FILE:/path/to/file}
+var refRegex = regexp.MustCompile(`\$\{(ENV|FILE):([^}]+)\}`)
+
+// A config value that was resolved from a environment variable or file
+type Resolved struct {
+	Path   string // Path of the value in the config, e.g. plugins.authdp.dp_secret
+	Source string // Where the value came from, e.g. ENV:DP_SECRET
+}
+
+// Returns the value of a single reference
+func resolve(kind, name string) (string, error) {
+	switch kind {
+	case "ENV":
+		v, ok := os.LookupEnv(name)
+
+		if !ok {
+			return "", errors.New("environment variable " + name + " is not set")
+		}
+
+		return v, nil
+	case "FILE":
+		bytes, err := os.ReadFile(name)
+
+		if err != nil {
+			return "", errors.New("failed to read " + name + ": " + err.Error())
+		}
+
+		// Secret files almost always end with a newline
+		return strings.TrimRight(string(bytes), "\r\n"), nil
+	}
+
+	return "", errors.New("unknown reference type " + kind)
+}
+
+// Resolves all ${ENV:NAME} and ${FILE:/path} references in the scalars of node.
+//
+// Resolved values are typed like any other unquoted scalar, quote the reference to always
+// get a string. All errors are returned at once
+func Interpolate(node *yaml.Node) ([]Resolved, error) {
+	var resolved []Resolved
+	var errs []string
+
+	var walk func(n *yaml.Node, path string)
+
+	walk = func(n *yaml.Node, path string) {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(c, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key := n.Content[i].Value
+
+				if path != "" {
+					key = path + "." + key
+				}
+
+				walk(n.Content[i+1], key)
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				walk(c, path+"["+strconv.Itoa(i)+"]")
+			}
+		case yaml.ScalarNode:
+			if !strings.Contains(n.Value, "${") {
+				return
+			}
+
+			var sources []string
+
+			value := refRegex.ReplaceAllStringFunc(n.Value, func(ref string) string {
+				m := refRegex.FindStringSubmatch(ref)
+
+				v, err := resolve(m[1], m[2])
+
+				if err != nil {
+					errs = append(errs, path+": "+err.Error())
+					return ref
+				}
+
+				sources = append(sources, m[1]+":"+m[2])
+
+				return v
+			})
+
+			if len(sources) == 0 {
+				return
+			}
+
+			n.Value = value
+
+			// Let yaml resolve the tag again so ${ENV:PORT} can be decoded into an int. Explicitly
+			// tagged and quoted scalars keep their tag, and values resolving to null stay strings
+			// so a secret such as "null" is not decoded as empty
+			if n.Style&(yaml.TaggedStyle|yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) == 0 {
+				n.Tag = ""
+
+				if n.ShortTag() == "!!null" {
+					n.Tag = "!!str"
+				}
+			}
+
+			for _, src := range sources {
+				resolved = append(resolved, Resolved{Path: path, Source: src})
+			}
+		}
+	}
+
+	walk(node, "")
+
+	if len(errs) > 0 {
+		return nil, errors.New("failed to resolve config references:\n  " + strings.Join(errs, "\n  "))
+	}
+
+	return resolved, nil
+}
+
+// Decodes yaml from r into v, resolving ${ENV:NAME} and ${FILE:/path} references
+func Decode(r io.Reader, v any) ([]Resolved, error) {
+	var node yaml.Node
+
+	err := yaml.NewDecoder(r).Decode(&node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := Interpolate(&node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.Decode(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
+
+// Decodes yaml from r into v without resolving references, for showing a config as it was written.
+// References in non-string fields (such as timeout: ${ENV:TIMEOUT}) cannot be decoded and are
+// left at their zero value
+func DecodeRaw(r io.Reader, v any) error {
+	var node yaml.Node
+
+	err := yaml.NewDecoder(r).Decode(&node)
+
+	if err != nil {
+		return err
+	}
+
+	// Lines with a reference on them
+	refLines := map[string]bool{}
+
+	var walk func(n *yaml.Node)
+
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode && refRegex.MatchString(n.Value) {
+			refLines["line "+strconv.Itoa(n.Line)+":"] = true
+		}
+
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+
+	walk(&node)
+
+	err = node.Decode(v)
+
+	var typeErr *yaml.TypeError
+
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+
+	// The decoder carries on after type errors, so only errors not caused by a reference matter
+	var errs []string
+
+	for _, e := range typeErr.Errors {
+		line, _, _ := strings.Cut(e, " cannot")
+
+		if !refLines[line] {
+			errs = append(errs, e)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &yaml.TypeError{Errors: errs}
+	}
+
+	return nil
+}
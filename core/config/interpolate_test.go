@@ -0,0 +1,180 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+
+	err := os.WriteFile(secret, []byte("s3cret\n"), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_PORT", "8080")
+	t.Setenv("TEST_ENABLED", "true")
+	t.Setenv("TEST_NULL", "null")
+	t.Setenv("TEST_HOST", "example.com")
+
+	type config struct {
+		Port    int      `yaml:"port"`
+		Enabled bool     `yaml:"enabled"`
+		Name    string   `yaml:"name"`
+		Secret  string   `yaml:"secret"`
+		Quoted  string   `yaml:"quoted"`
+		Tagged  string   `yaml:"tagged"`
+		Nothing string   `yaml:"nothing"`
+		Hosts   []string `yaml:"hosts"`
+	}
+
+	tests := []struct {
+		name     string
+		yaml     string
+		want     config
+		resolved []Resolved
+		err      string
+	}{
+		{
+			name:     "int",
+			yaml:     "port: ${ENV:TEST_PORT}",
+			want:     config{Port: 8080},
+			resolved: []Resolved{{Path: "port", Source: "ENV:TEST_PORT"}},
+		},
+		{
+			name: "bool",
+			yaml: "enabled: ${ENV:TEST_ENABLED}",
+			want: config{Enabled: true},
+		},
+		{
+			name: "number into string",
+			yaml: "name: ${ENV:TEST_PORT}",
+			want: config{Name: "8080"},
+		},
+		{
+			name: "quoted",
+			yaml: `quoted: "${ENV:TEST_PORT}"`,
+			want: config{Quoted: "8080"},
+		},
+		{
+			name: "tagged",
+			yaml: "tagged: !!str ${ENV:TEST_PORT}",
+			want: config{Tagged: "8080"},
+		},
+		{
+			name: "null stays a string",
+			yaml: "nothing: ${ENV:TEST_NULL}",
+			want: config{Nothing: "null"},
+		},
+		{
+			name:     "file",
+			yaml:     "secret: ${FILE:" + secret + "}",
+			want:     config{Secret: "s3cret"},
+			resolved: []Resolved{{Path: "secret", Source: "FILE:" + secret}},
+		},
+		{
+			name:     "part of a value",
+			yaml:     "hosts:\n  - https://${ENV:TEST_HOST}:${ENV:TEST_PORT}",
+			want:     config{Hosts: []string{"https://example.com:8080"}},
+			resolved: []Resolved{{Path: "hosts[0]", Source: "ENV:TEST_HOST"}, {Path: "hosts[0]", Source: "ENV:TEST_PORT"}},
+		},
+		{
+			name: "string into int",
+			yaml: "port: ${ENV:TEST_HOST}",
+			err:  "cannot unmarshal",
+		},
+		{
+			name: "missing",
+			yaml: "port: ${ENV:TEST_MISSING}\nsecret: ${FILE:/nonexistent}",
+			err:  "port: environment variable TEST_MISSING is not set\n  secret: failed to read /nonexistent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got config
+
+			resolved, err := Decode(strings.NewReader(tt.yaml), &got)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+
+			if tt.resolved != nil && !reflect.DeepEqual(resolved, tt.resolved) {
+				t.Fatalf("got resolved %+v, want %+v", resolved, tt.resolved)
+			}
+		})
+	}
+}
+
+func TestDecodeRaw(t *testing.T) {
+	type config struct {
+		Port   int    `yaml:"port"`
+		Secret string `yaml:"secret"`
+		Other  int    `yaml:"other"`
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		want config
+		err  string
+	}{
+		{
+			name: "references are kept",
+			yaml: "secret: ${ENV:TEST_SECRET}\nother: 1",
+			want: config{Secret: "${ENV:TEST_SECRET}", Other: 1},
+		},
+		{
+			name: "references in other types are skipped",
+			yaml: "port: ${ENV:TEST_PORT}\nother: 1",
+			want: config{Other: 1},
+		},
+		{
+			name: "other type errors",
+			yaml: "port: ${ENV:TEST_PORT}\nother: abc",
+			err:  "line 2: cannot unmarshal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got config
+
+			err := DecodeRaw(strings.NewReader(tt.yaml), &got)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) || strings.Contains(err.Error(), "line 1") {
+					t.Fatalf("got error %v, want only one containing %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
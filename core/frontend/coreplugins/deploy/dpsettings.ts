@@ -17,6 +17,7 @@ interface DeployWebhook {
     id: string,
     token: string,
     type: string,
+    ref?: string,
 }
 
 export {
@@ -4,8 +4,15 @@ const timeout = 500;
 
 export const newTask = (logId: string, callback: (outp: string[]) => void) => {
     let hasStarted = false;
-    let c = setInterval(async () => {
-        let res = await fetch(`/api/logger/getLogEntry?id=${logId}`, {
+    let output: string[] = [];
+    let since = 0;
+    let fetching = false;
+
+    const poll = async () => {
+        let offset = since;
+
+        // Only fetch the lines we haven't seen yet
+        let res = await fetch(`/api/logger/getLogEntry?id=${logId}&since=${offset}`, {
             method: "POST",
         });
 
@@ -17,9 +24,11 @@ export const newTask = (logId: string, callback: (outp: string[]) => void) => {
         let xIsDone = res.headers.get("X-Is-Done");
         console.log(xIsDone)
 
-        let out = await res.json();
+        let logLength = parseInt(res.headers.get("X-Log-Length") || "0");
+
+        let out: string[] = await res.json();
 
-        if(out?.length == 0) {
+        if(logLength < since || (logLength == 0 && out?.length == 0)) {
             if(!hasStarted) {
                 console.log("No output yet...")
                 return
@@ -32,15 +41,39 @@ export const newTask = (logId: string, callback: (outp: string[]) => void) => {
         }
 
         hasStarted = true;
+        since = logLength;
 
-        callback(out)
+        if(out?.length > 0) {
+            // The lines start at the offset that was asked for, so place them there instead of appending
+            output = [...output.slice(0, offset), ...out]
+            callback(output)
+        }
 
         if(res.headers.get("X-Is-Done")) {
+            if(res.headers.get("X-Task-Result") == "failure") {
+                error("Task failed, check the task output for more information")
+            }
+
             console.log("Cancelling polling...")
             clearInterval(c);
             return
         }
 
         console.log("Polling...")
+    }
+
+    let c = setInterval(async () => {
+        // A slow fetch must finish before the next one starts, otherwise both append the same lines
+        if(fetching) {
+            return
+        }
+
+        fetching = true;
+
+        try {
+            await poll();
+        } finally {
+            fetching = false;
+        }
     }, timeout);
-}
\ No newline at end of file
+}
@@ -0,0 +1,320 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A single line in a on-disk log file
+type fileRecord struct {
+	Time   time.Time `json:"time"`
+	Data   string    `json:"data,omitempty"`
+	Step   string    `json:"step,omitempty"`
+	Done   bool      `json:"done,omitempty"`
+	Result *Result   `json:"result,omitempty"`
+}
+
+// A log store that keeps recent entries in memory and appends every update to a
+// JSON-lines file (<dir>/<id>.jsonl) so task output survives restarts
+type FileStore struct {
+	dir string
+	mem *MemoryStore
+
+	// Guards logs
+	mu   sync.Mutex
+	logs map[string]*fileLog
+}
+
+// The log file of a id, kept open until the task is done
+type fileLog struct {
+	// Guards file and updates of the entry, so the file and memory stay in the same order
+	mu   sync.Mutex
+	file *os.File
+
+	// Set once the task is done, later writes (if any) open and close the file themselves
+	closed bool
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0700)
+
+	if err != nil {
+		return nil, errors.New("Failed to create log directory: " + err.Error())
+	}
+
+	mem := NewMemoryStore()
+
+	// The full log is on disk, so only the tail of large logs needs to stay in memory
+	mem.keepTail = true
+
+	return &FileStore{
+		dir:  dir,
+		mem:  mem,
+		logs: map[string]*fileLog{},
+	}, nil
+}
+
+// Returns the path of the log file for a id. Ids that are not plain names (such as ones
+// containing dots or slashes) are base64 encoded behind a ~, which plain names cannot contain
+func (f *FileStore) path(id string) string {
+	name := id
+
+	if id == "" || strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) != -1 {
+		name = "~" + base64.RawURLEncoding.EncodeToString([]byte(id))
+	}
+
+	return filepath.Join(f.dir, name+".jsonl")
+}
+
+// Returns the log file of id, locked. The caller must call unlock when done with it
+func (f *FileStore) lock(id string) (l *fileLog, unlock func()) {
+	f.mu.Lock()
+
+	l, ok := f.logs[id]
+
+	if !ok {
+		l = &fileLog{}
+		f.logs[id] = l
+	}
+
+	f.mu.Unlock()
+
+	l.mu.Lock()
+
+	return l, l.mu.Unlock
+}
+
+// Closes the log file of a finished task. Callers must hold l.mu
+func (f *FileStore) release(id string, l *fileLog) {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	l.closed = true
+
+	f.mu.Lock()
+
+	if f.logs[id] == l {
+		delete(f.logs, id)
+	}
+
+	f.mu.Unlock()
+}
+
+// Appends records to the log file of id, replacing its contents if truncate is set. Callers must hold l.mu
+func (f *FileStore) write(id string, l *fileLog, truncate bool, records ...fileRecord) {
+	if l.file == nil {
+		file, err := os.OpenFile(f.path(id), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+
+		if err != nil {
+			fmt.Println("logger: failed to open log file for " + id + ": " + err.Error())
+			return
+		}
+
+		l.file = file
+	}
+
+	if truncate {
+		err := l.file.Truncate(0)
+
+		if err != nil {
+			fmt.Println("logger: failed to truncate log file for " + id + ": " + err.Error())
+			return
+		}
+	}
+
+	// Encode everything first so a record is never half written by a failed encode
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+
+	for _, rec := range records {
+		err := enc.Encode(rec)
+
+		if err != nil {
+			fmt.Println("logger: failed to encode log record for " + id + ": " + err.Error())
+			return
+		}
+	}
+
+	_, err := l.file.Write(buf.Bytes())
+
+	if err != nil {
+		fmt.Println("logger: failed to write log file for " + id + ": " + err.Error())
+	}
+
+	if l.closed {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// Loads a entry from disk
+func (f *FileStore) load(id string) (LogEntry, bool) {
+	entry := LogEntry{
+		LastLog: []string{},
+	}
+
+	file, err := os.Open(f.path(id))
+
+	if err != nil {
+		return entry, false
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var rec fileRecord
+
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+
+		if err != nil {
+			continue // Skip partially written lines
+		}
+
+		entry.LastUpdate = rec.Time
+
+		if rec.Done {
+			entry.endStep(rec.Time)
+			entry.IsDone = true
+			entry.Result = rec.Result
+			continue
+		}
+
+		if rec.Step != "" {
+			entry.endStep(rec.Time)
+			entry.Steps = append(entry.Steps, Step{Name: rec.Step, StartedAt: rec.Time, Line: len(entry.LastLog)})
+			continue
+		}
+
+		entry.LastLog = append(entry.LastLog, rec.Data)
+	}
+
+	// Only keep the tail of very large logs in memory
+	entry.keepTail(logTreshold)
+
+	return entry, true
+}
+
+// Ensures the in-memory cache has the on-disk state of id before it is modified. Callers must hold the log of id
+func (f *FileStore) warm(id string) {
+	if _, ok := f.mem.Lookup(id); ok {
+		return
+	}
+
+	if entry, ok := f.load(id); ok {
+		f.mem.Set(id, entry)
+	}
+}
+
+func (f *FileStore) Get(id string) LogEntry {
+	if entry, ok := f.mem.Lookup(id); ok {
+		return entry
+	}
+
+	entry, ok := f.load(id)
+
+	if !ok {
+		return entry
+	}
+
+	// Otherwise every poll of a old log would read and parse the whole file again
+	return f.mem.cache(id, entry)
+}
+
+func (f *FileStore) Set(id string, entry LogEntry) {
+	l, unlock := f.lock(id)
+	defer unlock()
+
+	// Steps and lines are written in the order they were logged in, so reloading gives the same entry
+	records := make([]fileRecord, 0, len(entry.Steps)+len(entry.LastLog)+1)
+
+	steps := entry.Steps
+
+	for i, data := range entry.LastLog {
+		for len(steps) > 0 && steps[0].Line <= i {
+			records = append(records, fileRecord{Time: steps[0].StartedAt, Step: steps[0].Name})
+			steps = steps[1:]
+		}
+
+		records = append(records, fileRecord{Time: entry.LastUpdate, Data: data})
+	}
+
+	for _, step := range steps {
+		records = append(records, fileRecord{Time: step.StartedAt, Step: step.Name})
+	}
+
+	if entry.IsDone {
+		records = append(records, fileRecord{Time: entry.LastUpdate, Done: true, Result: entry.Result})
+	}
+
+	entry.keepTail(logTreshold)
+	f.mem.Set(id, entry)
+
+	f.write(id, l, true, records...)
+
+	if entry.IsDone {
+		f.release(id, l)
+	}
+}
+
+func (f *FileStore) Add(id string, data string, newline bool) {
+	l, unlock := f.lock(id)
+	defer unlock()
+
+	f.warm(id)
+	f.mem.Add(id, data, newline)
+
+	if newline {
+		data += "\n"
+	}
+
+	f.write(id, l, false, fileRecord{Time: time.Now(), Data: data})
+
+	// Written after the task finished, nothing else will close the file
+	if f.mem.Get(id).IsDone {
+		f.release(id, l)
+	}
+}
+
+func (f *FileStore) Step(id string, name string) {
+	l, unlock := f.lock(id)
+	defer unlock()
+
+	f.warm(id)
+	f.mem.Step(id, name)
+
+	f.write(id, l, false, fileRecord{Time: time.Now(), Step: name})
+
+	// Written after the task finished, nothing else will close the file
+	if f.mem.Get(id).IsDone {
+		f.release(id, l)
+	}
+}
+
+func (f *FileStore) MarkDone(id string, result Result) {
+	l, unlock := f.lock(id)
+	defer unlock()
+
+	f.warm(id)
+	f.mem.MarkDone(id, result)
+
+	f.write(id, l, false, fileRecord{Time: time.Now(), Done: true, Result: &result})
+
+	f.release(id, l)
+}
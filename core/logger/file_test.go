@@ -0,0 +1,199 @@
+package logger
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStoreIds(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{"plain", "deploy.site", "a/b", "..", "~plain", "deploy_site"}
+
+	for _, id := range ids {
+		f.Add(id, "from "+id, false)
+		f.MarkDone(id, Result{Status: ResultSuccess})
+	}
+
+	// A new store has nothing in memory, so everything comes from disk
+	f, err = NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		entry := f.Get(id)
+
+		if !reflect.DeepEqual(entry.LastLog, []string{"from " + id}) || !entry.IsDone {
+			t.Errorf("%q was not persisted, got %+v", id, entry)
+		}
+	}
+}
+
+func TestFileStoreSetKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+
+	f.Set("task", LogEntry{
+		LastUpdate: now,
+		LastLog:    []string{"a", "b", "c"},
+		Steps: []Step{
+			{Name: "first", StartedAt: now, Line: 0},
+			{Name: "second", StartedAt: now, Line: 2},
+			{Name: "empty", StartedAt: now, Line: 3},
+		},
+	})
+
+	f, err = NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := f.Get("task")
+
+	var got []string
+
+	for _, step := range entry.Steps {
+		got = append(got, step.Name+"@"+strconv.Itoa(step.Line))
+	}
+
+	if want := []string{"first@0", "second@2", "empty@3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got steps %v, want %v", got, want)
+	}
+
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(entry.LastLog, want) {
+		t.Fatalf("got lines %v, want %v", entry.LastLog, want)
+	}
+}
+
+func TestFileStoreKeepsLargeLogsInMemory(t *testing.T) {
+	f, err := NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Step("task", "build")
+
+	for i := 0; i < logTreshold+10; i++ {
+		f.Add("task", strconv.Itoa(i), false)
+	}
+
+	entry, ok := f.mem.Lookup("task")
+
+	if !ok {
+		t.Fatal("large log was evicted from memory")
+	}
+
+	if len(entry.LastLog) != logTreshold || entry.LastLog[len(entry.LastLog)-1] != strconv.Itoa(logTreshold+9) {
+		t.Fatalf("got %d lines ending in %q, want the last %d lines", len(entry.LastLog), entry.LastLog[len(entry.LastLog)-1], logTreshold)
+	}
+
+	if entry.Steps[0].Line != 0 {
+		t.Fatalf("got step line %d, want 0", entry.Steps[0].Line)
+	}
+
+	// Line numbers must not depend on whether the entry came from memory or disk
+	loaded, _ := f.load("task")
+
+	for _, e := range []LogEntry{entry, loaded} {
+		if e.Offset != 10 || e.LastLog[0] != "10" {
+			t.Fatalf("got offset %d and first line %q, want 10", e.Offset, e.LastLog[0])
+		}
+	}
+}
+
+func TestFileStoreClosesFinishedLogs(t *testing.T) {
+	f, err := NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(id string) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				f.Add(id, strconv.Itoa(j), true)
+			}
+
+			f.MarkDone(id, Result{Status: ResultSuccess})
+
+			// Written after the task is done, must not keep the file open
+			f.Add(id, "late", true)
+		}("task-" + strconv.Itoa(i))
+	}
+
+	wg.Wait()
+
+	if len(f.logs) != 0 {
+		t.Fatalf("%d log files are still open", len(f.logs))
+	}
+
+	if got := f.Get("task-0").LastLog; len(got) != 101 || got[100] != "late\n" {
+		t.Fatalf("got %d lines, want 101 ending in the late line", len(got))
+	}
+}
+
+func TestFileStoreCachesLoadedLogs(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Finished well before logTime, so it would expire right away if cached by its last update
+	f.Set("old", LogEntry{LastUpdate: time.Now().Add(-2 * logTime), LastLog: []string{"a"}, IsDone: true})
+
+	f, err = NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := f.Get("old"); !reflect.DeepEqual(entry.LastLog, []string{"a"}) {
+		t.Fatalf("got %+v from disk, want the old log", entry)
+	}
+
+	// Later reads must not touch the file
+	err = os.Remove(f.path("old"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := f.Get("old"); !reflect.DeepEqual(entry.LastLog, []string{"a"}) {
+		t.Fatalf("got %+v, want the cached log", entry)
+	}
+
+	// Missing logs are not cached
+	if f.Get("missing"); len(f.mem.entries) != 1 {
+		t.Fatalf("got %d cached entries, want 1", len(f.mem.entries))
+	}
+}
@@ -7,62 +7,108 @@ import (
 const logTime = 8 * time.Hour
 const logTreshold = 100000
 
+type ResultStatus string
+
+const (
+	ResultSuccess   ResultStatus = "success"
+	ResultFailure   ResultStatus = "failure"
+	ResultCancelled ResultStatus = "cancelled"
+)
+
+// The outcome of a task
+type Result struct {
+	Status ResultStatus
+	Error  string // Set if Status is ResultFailure
+}
+
+// Returns a successful result if err is nil, otherwise a failed result with the error message
+func ResultFromError(err error) Result {
+	if err != nil {
+		return Result{Status: ResultFailure, Error: err.Error()}
+	}
+
+	return Result{Status: ResultSuccess}
+}
+
+// A named step of a task. A step ends when the next one starts or the task is marked as done
+type Step struct {
+	Name      string
+	StartedAt time.Time
+	EndedAt   *time.Time
+	Line      int // Index in LastLog of the first line logged during the step
+}
+
 type LogEntry struct {
 	LastUpdate time.Time
 	LastLog    []string
+	Offset     int // Lines dropped from the start of very large logs, LastLog[i] is line Offset+i of the task
 	IsDone     bool
+	Result     *Result // Set once IsDone is true
+	Steps      []Step
 }
 
-type LogEntryMap map[string]LogEntry
+// Drops all but the last n lines, keeping the steps pointing at the same lines
+func (l *LogEntry) keepTail(n int) {
+	dropped := len(l.LastLog) - n
 
-func (l LogEntryMap) Get(id string) LogEntry {
-	entry, ok := l[id]
-
-	if !ok {
-		return LogEntry{
-			LastLog: []string{},
-		}
+	if dropped <= 0 {
+		return
 	}
 
-	if time.Since(entry.LastUpdate) > logTime {
-		delete(l, id)
-		return LogEntry{}
-	}
+	l.LastLog = l.LastLog[dropped:]
+	l.Offset += dropped
 
-	if len(entry.LastLog) > logTreshold {
-		delete(l, id) // Prevent memory leaks
-		return LogEntry{}
+	if len(l.Steps) == 0 {
+		return
 	}
 
-	return entry
-}
+	l.Steps = append([]Step(nil), l.Steps...)
 
-func (l LogEntryMap) Set(id string, entry LogEntry) {
-	l[id] = entry
-}
+	for i := range l.Steps {
+		l.Steps[i].Line -= dropped
 
-func (l LogEntryMap) Add(id string, data string, newline bool) {
-	if newline {
-		data += "\n"
+		if l.Steps[i].Line < 0 {
+			l.Steps[i].Line = 0
+		}
 	}
+}
 
-	currLog := l.Get(id)
+// Ends the currently running step (if any) at t
+func (l *LogEntry) endStep(t time.Time) {
+	if len(l.Steps) == 0 {
+		return
+	}
 
-	currLog.LastUpdate = time.Now()
-	currLog.LastLog = append(currLog.LastLog, data)
+	if l.Steps[len(l.Steps)-1].EndedAt != nil {
+		return
+	}
 
-	l.Set(id, currLog)
+	// Copy the steps as older snapshots of the entry may share the same array
+	l.Steps = append([]Step(nil), l.Steps...)
+	l.Steps[len(l.Steps)-1].EndedAt = &t
 }
 
-func (l LogEntryMap) MarkDone(id string) {
-	entry := l.Get(id)
+// A LogStore stores the output of tasks. All implementations must be safe for concurrent use
+type LogStore interface {
+	// Returns the log entry with the given id, an empty entry is returned if it does not exist
+	Get(id string) LogEntry
+
+	// Replaces the log entry with the given id
+	Set(id string, entry LogEntry)
+
+	// Appends data to the log entry with the given id
+	Add(id string, data string, newline bool)
 
-	entry.IsDone = true
+	// Starts a new step in the log entry with the given id, ending the previous one
+	Step(id string, name string)
 
-	l.Set(id, entry)
+	// Marks the log entry with the given id as done with the given result
+	MarkDone(id string, result Result)
 }
 
-var LogMap = LogEntryMap{}
+// The log store used by sysmanage. Defaults to a in-memory store, server.Init will
+// replace this with a on-disk store if log_dir is set in config.yaml
+var LogMap LogStore = NewMemoryStore()
 
 type AutoLogger struct {
 	ID      string
@@ -0,0 +1,149 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// A mutex-guarded in-memory log store. Entries expire after logTime or once they grow past logTreshold
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]LogEntry
+
+	// Keep the last logTreshold lines of large entries instead of dropping them, set when
+	// the full log is stored elsewhere
+	keepTail bool
+
+	// When entries loaded from elsewhere were cached, these expire logTime after being
+	// cached rather than after their last update
+	cachedAt map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:  map[string]LogEntry{},
+		cachedAt: map[string]time.Time{},
+	}
+}
+
+// Returns the entry and whether it exists. Callers must hold m.mu
+func (m *MemoryStore) get(id string) (LogEntry, bool) {
+	entry, ok := m.entries[id]
+
+	if !ok {
+		return LogEntry{
+			LastLog: []string{},
+		}, false
+	}
+
+	updated := entry.LastUpdate
+
+	if cached, ok := m.cachedAt[id]; ok && cached.After(updated) {
+		updated = cached
+	}
+
+	if time.Since(updated) > logTime {
+		delete(m.entries, id)
+		delete(m.cachedAt, id)
+		return LogEntry{}, false
+	}
+
+	if len(entry.LastLog) > logTreshold && !m.keepTail {
+		delete(m.entries, id) // Prevent memory leaks
+		delete(m.cachedAt, id)
+		return LogEntry{}, false
+	}
+
+	return entry, true
+}
+
+// Same as Get, but also returns whether the entry exists
+func (m *MemoryStore) Lookup(id string) (LogEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(id)
+}
+
+func (m *MemoryStore) Get(id string) LogEntry {
+	entry, _ := m.Lookup(id)
+	return entry
+}
+
+func (m *MemoryStore) Set(id string, entry LogEntry) {
+	m.mu.Lock()
+	m.entries[id] = entry
+	delete(m.cachedAt, id)
+	m.mu.Unlock()
+
+	Notify(id)
+}
+
+// Caches a entry loaded from elsewhere unless id already has one, which is newer. Returns the stored entry
+func (m *MemoryStore) cache(id string, entry LogEntry) LogEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.get(id); ok {
+		return existing
+	}
+
+	m.entries[id] = entry
+	m.cachedAt[id] = time.Now()
+
+	return entry
+}
+
+func (m *MemoryStore) Add(id string, data string, newline bool) {
+	if newline {
+		data += "\n"
+	}
+
+	m.mu.Lock()
+
+	currLog, _ := m.get(id)
+
+	currLog.LastUpdate = time.Now()
+	currLog.LastLog = append(currLog.LastLog, data)
+
+	if m.keepTail {
+		currLog.keepTail(logTreshold)
+	}
+
+	m.entries[id] = currLog
+	m.mu.Unlock()
+
+	Notify(id)
+}
+
+func (m *MemoryStore) Step(id string, name string) {
+	now := time.Now()
+
+	m.mu.Lock()
+
+	entry, _ := m.get(id)
+
+	entry.endStep(now)
+	entry.LastUpdate = now
+	entry.Steps = append(entry.Steps, Step{Name: name, StartedAt: now, Line: len(entry.LastLog)})
+
+	m.entries[id] = entry
+	m.mu.Unlock()
+
+	Notify(id)
+}
+
+func (m *MemoryStore) MarkDone(id string, result Result) {
+	m.mu.Lock()
+
+	entry, _ := m.get(id)
+
+	entry.endStep(time.Now())
+	entry.IsDone = true
+	entry.Result = &result
+
+	m.entries[id] = entry
+	m.mu.Unlock()
+
+	Notify(id)
+}
@@ -0,0 +1,50 @@
+package logger
+
+import "sync"
+
+var subscribers = struct {
+	sync.Mutex
+	m map[string]map[chan struct{}]struct{}
+}{
+	m: map[string]map[chan struct{}]struct{}{},
+}
+
+// Returns a channel that receives a value whenever the log entry with the given id is updated
+// along with a function to unsubscribe. Multiple updates may be coalesced into a single value
+func Subscribe(id string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	subscribers.Lock()
+	defer subscribers.Unlock()
+
+	if subscribers.m[id] == nil {
+		subscribers.m[id] = map[chan struct{}]struct{}{}
+	}
+
+	subscribers.m[id][ch] = struct{}{}
+
+	return ch, func() {
+		subscribers.Lock()
+		defer subscribers.Unlock()
+
+		delete(subscribers.m[id], ch)
+
+		if len(subscribers.m[id]) == 0 {
+			delete(subscribers.m, id)
+		}
+	}
+}
+
+// Wakes up all subscribers of a log entry. Custom LogStore implementations must call this on every update
+func Notify(id string) {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+
+	for ch := range subscribers.m[id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A update is already pending
+		}
+	}
+}
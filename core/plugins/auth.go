@@ -0,0 +1,258 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins/constants"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/types"
+	"golang.org/x/exp/slices"
+)
+
+// The user a request is authenticated as
+type Principal struct {
+	ID          string   `json:"id"`
+	DisplayName string   `json:"display_name"` // Defaults to the id if the auth plugin does not know the name of the user
+	Groups      []string `json:"groups"`       // Empty if the auth plugin does not support groups
+	Method      string   `json:"method"`       // The auth plugin that authenticated the request
+}
+
+// Authenticates requests on behalf of a auth plugin
+type Authenticator interface {
+	// Returns the principal of the request, or nil if the request has no credentials for this
+	// authenticator so that the next one can be tried. Invalid credentials should return a error,
+	// use *AuthError to reject the request with a status other than 401
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Optionally implemented by authenticators to handle requests no authenticator accepted,
+// e.g. by redirecting to a login page. Returns whether a response was written
+type Challenger interface {
+	Challenge(w http.ResponseWriter, r *http.Request) bool
+}
+
+// Rejects a request with a specific status code
+type AuthError struct {
+	Status  int
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+type authenticator struct {
+	plugin string
+	a      Authenticator
+}
+
+// Authenticators in the order they were registered
+var authenticators []authenticator
+
+// Middleware that needs the principal of the request, in the order it was added
+var authedMiddleware []func(http.Handler) http.Handler
+
+type principalKey struct{}
+
+// Adds a authenticator to the auth chain, must be called in the Preload function of the plugin.
+//
+// Authenticators are tried in the order set by auth.order in config.yaml, followed by the others
+// in the order they were registered
+func RegisterAuthenticator(plugin string, a Authenticator) {
+	authenticators = append(authenticators, authenticator{plugin: plugin, a: a})
+}
+
+// Adds a middleware that runs after the auth chain, so GetPrincipal and UserID are set when it is called.
+// Must be called in the Preload function of the plugin, RawMux.Use runs before the auth chain
+func UseAuthed(mw func(http.Handler) http.Handler) {
+	authedMiddleware = append(authedMiddleware, mw)
+}
+
+// Returns the middleware added with UseAuthed
+func AuthedMiddleware() []func(http.Handler) http.Handler {
+	return authedMiddleware
+}
+
+// Checks the auth section of config.yaml against the registered authenticators
+func ValidateAuthConfig(c types.AuthConfig) error {
+	for _, id := range c.Order {
+		if !slices.ContainsFunc(authenticators, func(a authenticator) bool { return a.plugin == id }) {
+			return errors.New("auth.order: " + id + " is not a loaded auth plugin")
+		}
+	}
+
+	return nil
+}
+
+// Returns the authenticators in order of precedence
+func chain() []authenticator {
+	order := state.Config().Auth.Order
+
+	if len(order) == 0 {
+		return authenticators
+	}
+
+	list := make([]authenticator, 0, len(authenticators))
+
+	for _, id := range order {
+		for _, a := range authenticators {
+			if a.plugin == id {
+				list = append(list, a)
+			}
+		}
+	}
+
+	for _, a := range authenticators {
+		if !slices.Contains(order, a.plugin) {
+			list = append(list, a)
+		}
+	}
+
+	return list
+}
+
+// Returns the principal of the request, nil if it is not authenticated (e.g. on auth exempt routes)
+func GetPrincipal(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Returns the id of the user the request is authenticated as, empty if it is not authenticated
+func UserID(r *http.Request) string {
+	if p := GetPrincipal(r); p != nil {
+		return p.ID
+	}
+
+	return ""
+}
+
+// Returns a copy of the request authenticated as the principal. constants.UserIdHeader and
+// constants.UserGroupsHeader are also set for plugins that do not use GetPrincipal yet
+func WithPrincipal(r *http.Request, p *Principal) *http.Request {
+	if p.DisplayName == "" {
+		p.DisplayName = p.ID
+	}
+
+	r.Header.Set(constants.UserIdHeader, p.ID)
+	r.Header.Del(constants.UserGroupsHeader)
+
+	for _, g := range p.Groups {
+		r.Header.Add(constants.UserGroupsHeader, g)
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// Runs the auth chain. With auth.mode any (the default), the first authenticator to accept a
+// request wins. With auth.mode all, every authenticator must accept it as the same user
+func authenticate(r *http.Request) (*Principal, error) {
+	var (
+		principal *Principal
+		firstErr  error
+	)
+
+	all := state.Config().Auth.Mode == "all"
+
+	for _, a := range chain() {
+		p, err := a.a.Authenticate(r)
+
+		if err == nil && p == nil && all {
+			err = errors.New("no credentials for " + a.plugin + " found")
+		}
+
+		if err != nil {
+			if all {
+				return nil, err
+			}
+
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		if p == nil {
+			continue
+		}
+
+		p.Method = a.plugin
+
+		if !all {
+			return p, nil
+		}
+
+		if principal == nil {
+			principal = p
+			continue
+		}
+
+		if p.ID != principal.ID {
+			return nil, errors.New(a.plugin + " authenticated a different user than " + principal.Method)
+		}
+
+		for _, g := range p.Groups {
+			if !slices.Contains(principal.Groups, g) {
+				principal.Groups = append(principal.Groups, g)
+			}
+		}
+	}
+
+	if principal != nil {
+		return principal, nil
+	}
+
+	return nil, firstErr
+}
+
+// Authenticates all requests except auth exempt routes using the registered authenticators.
+// Identity headers sent by the client are always removed
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(constants.UserIdHeader)
+		r.Header.Del(constants.UserGroupsHeader)
+
+		// Auth-exempt routes should be excluded, as should everything if only authdummy is loaded
+		if len(authenticators) == 0 || IsExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := authenticate(r)
+
+		if p != nil {
+			next.ServeHTTP(w, WithPrincipal(r, p))
+			return
+		}
+
+		if err == nil {
+			for _, a := range chain() {
+				if c, ok := a.a.(Challenger); ok && c.Challenge(w, r) {
+					return
+				}
+			}
+
+			names := make([]string, 0, len(authenticators))
+
+			for _, a := range chain() {
+				names = append(names, a.plugin)
+			}
+
+			err = errors.New("No credentials found, please login using one of " + strings.Join(names, ", "))
+		}
+
+		status := http.StatusUnauthorized
+
+		var authErr *AuthError
+
+		if errors.As(err, &authErr) && authErr.Status != 0 {
+			status = authErr.Status
+		}
+
+		w.WriteHeader(status)
+		w.Write([]byte(http.StatusText(status) + ". " + err.Error()))
+	})
+}
@@ -0,0 +1,223 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matches the line prefix of yaml decode errors, these are meaningless as each key is decoded on its own
+var yamlLinePrefix = regexp.MustCompile(`^line \d+: `)
+
+// Returns the yaml key of a struct field, "-" if the field is skipped
+func yamlKey(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+
+	if name == "" {
+		// Same as yaml.v3
+		return strings.ToLower(f.Name)
+	}
+
+	return name
+}
+
+// Returns the error messages of a yaml decode error without line numbers
+func yamlErrors(err error) []string {
+	var typeErr *yaml.TypeError
+
+	if !errors.As(err, &typeErr) {
+		return []string{err.Error()}
+	}
+
+	msgs := make([]string, len(typeErr.Errors))
+
+	for i, msg := range typeErr.Errors {
+		msgs[i] = yamlLinePrefix.ReplaceAllString(msg, "")
+	}
+
+	return msgs
+}
+
+// Converts a validator struct namespace (e.g. Config.Sources[0].Token) to a yaml path (e.g. sources[0].token)
+func yamlPath(t reflect.Type, namespace string) string {
+	parts := strings.Split(namespace, ".")[1:]
+
+	path := make([]string, 0, len(parts))
+
+	for _, part := range parts {
+		name, index, _ := strings.Cut(part, "[")
+
+		if index != "" {
+			index = "[" + index
+		}
+
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		if t.Kind() != reflect.Struct {
+			path = append(path, part)
+			continue
+		}
+
+		f, ok := t.FieldByName(name)
+
+		if !ok {
+			path = append(path, part)
+			continue
+		}
+
+		path = append(path, yamlKey(f)+index)
+
+		t = f.Type
+
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		if index != "" && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+	}
+
+	return strings.Join(path, ".")
+}
+
+// Decodes the config of a plugin into a struct of type T.
+//
+// Keys are mapped to fields using their yaml tags. Fields missing from config.yaml
+// are set from their default tag (parsed as yaml, e.g. `default:"1"`) and the result
+// is then validated using the validate tags through state.Validator.
+//
+// All errors are returned at once, each prefixed with its path in config.yaml
+func DecodeConfig[T any](plugin string) (*T, error) {
+	section, ok := state.Config().Plugins[plugin]
+
+	if !ok {
+		return nil, errors.New("plugin not enabled")
+	}
+
+	var cfg T
+
+	v := reflect.ValueOf(&cfg).Elem()
+
+	if v.Kind() != reflect.Struct {
+		return nil, errors.New("plugin config must be a struct, got " + v.Kind().String())
+	}
+
+	t := v.Type()
+	prefix := "plugins." + plugin + "."
+
+	var errs []string
+	known := map[string]bool{}
+	failed := map[string]bool{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if !f.IsExported() {
+			continue
+		}
+
+		key := yamlKey(f)
+
+		if key == "-" {
+			continue
+		}
+
+		known[key] = true
+
+		raw := section[key]
+
+		if raw == nil {
+			def, ok := f.Tag.Lookup("default")
+
+			if !ok {
+				continue
+			}
+
+			err := yaml.Unmarshal([]byte(def), v.Field(i).Addr().Interface())
+
+			if err != nil {
+				panic("invalid default for " + t.Name() + "." + f.Name + ": " + err.Error())
+			}
+
+			continue
+		}
+
+		bytes, err := yaml.Marshal(raw)
+
+		if err != nil {
+			errs = append(errs, prefix+key+": "+err.Error())
+			failed[key] = true
+			continue
+		}
+
+		err = yaml.Unmarshal(bytes, v.Field(i).Addr().Interface())
+
+		if err != nil {
+			for _, msg := range yamlErrors(err) {
+				errs = append(errs, prefix+key+": "+msg)
+			}
+
+			failed[key] = true
+		}
+	}
+
+	var unknown []string
+
+	for key := range section {
+		if !known[key] {
+			unknown = append(unknown, prefix+key)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		fmt.Println("WARNING: ignoring unknown config keys: " + strings.Join(unknown, ", "))
+	}
+
+	err := state.Validator.Struct(cfg)
+
+	if err != nil {
+		var validationErrs validator.ValidationErrors
+
+		if !errors.As(err, &validationErrs) {
+			return nil, err
+		}
+
+		for _, fe := range validationErrs {
+			path := yamlPath(t, fe.StructNamespace())
+
+			// Fields that failed to decode have already been reported
+			key, _, _ := strings.Cut(path, ".")
+			key, _, _ = strings.Cut(key, "[")
+
+			if failed[key] {
+				continue
+			}
+
+			msg := prefix + path + ": failed " + fe.Tag() + " validation"
+
+			if fe.Param() != "" {
+				msg += " (" + fe.Param() + ")"
+			}
+
+			errs = append(errs, msg)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.New("invalid config:\n  " + strings.Join(errs, "\n  "))
+	}
+
+	return &cfg, nil
+}
@@ -2,4 +2,7 @@ package constants
 
 const (
 	UserIdHeader = "X-User-ID"
+
+	// Groups of the user as reported by the auth plugin (if it supports groups), one header value per group
+	UserGroupsHeader = "X-User-Groups"
 )
@@ -0,0 +1,37 @@
+package plugins
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"golang.org/x/exp/slices"
+)
+
+// Routes that do not require authentication. Only used for matching, the handlers are never called
+var exemptMux = chi.NewMux()
+
+// Exempts a route of a plugin from authentication, must be called in the Init function of the plugin.
+//
+// The pattern is a chi style pattern relative to the plugin (e.g. /createDeploy or /hooks/{id}), it is
+// prefixed with /api/<plugin>. An empty method exempts the route for all methods
+func AddExemptRoute(plugin, method, pattern string) {
+	pattern = "/api/" + plugin + pattern
+
+	if method == "" {
+		exemptMux.Handle(pattern, http.NotFoundHandler())
+		return
+	}
+
+	exemptMux.Method(method, pattern, http.NotFoundHandler())
+}
+
+// Returns whether a request is to a auth exempt route. Auth plugins and plugins
+// checking the user of a request must let these through
+func IsExempt(r *http.Request) bool {
+	if slices.Contains(state.AuthExemptRoutes, r.URL.Path) {
+		return true
+	}
+
+	return exemptMux.Match(chi.NewRouteContext(), r.Method, r.URL.Path)
+}
@@ -0,0 +1,71 @@
+package plugins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+// Accepts requests with a X-Test-User header
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if user := r.Header.Get("X-Test-User"); user != "" {
+		return &Principal{ID: user}, nil
+	}
+
+	return nil, nil
+}
+
+func TestExemptRoutes(t *testing.T) {
+	state.SetConfig(&types.Config{})
+	authenticators = []authenticator{{plugin: "test", a: testAuthenticator{}}}
+	exemptMux = chi.NewMux()
+
+	t.Cleanup(func() {
+		authenticators = nil
+	})
+
+	AddExemptRoute("deploy", http.MethodPost, "/createDeploy")
+	AddExemptRoute("hooks", "", "/hooks/{id}")
+
+	h := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user:" + UserID(r)))
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   string
+		status int
+	}{
+		{name: "exempt route", method: http.MethodPost, path: "/api/deploy/createDeploy", status: http.StatusOK},
+		{name: "exempt route with other method", method: http.MethodGet, path: "/api/deploy/createDeploy", status: http.StatusUnauthorized},
+		{name: "exempt pattern", method: http.MethodPut, path: "/api/hooks/hooks/abc", status: http.StatusOK},
+		{name: "pattern of another plugin", method: http.MethodPost, path: "/api/deploy/hooks/abc", status: http.StatusUnauthorized},
+		{name: "non-exempt route", method: http.MethodPost, path: "/api/deploy/getDeployList", status: http.StatusUnauthorized},
+		{name: "non-exempt route authenticated", method: http.MethodPost, path: "/api/deploy/getDeployList", user: "alice", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+
+			if tt.user != "" {
+				req.Header.Set("X-Test-User", tt.user)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d (%s), want %d", w.Code, w.Body.String(), tt.status)
+			}
+		})
+	}
+}
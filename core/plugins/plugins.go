@@ -15,12 +15,16 @@ import (
 var officialPlugins = []string{
 	"acl",
 	"actions",
+	"audit",
 	"authdp",
+	"authoidc",
+	"authtoken",
 	"deploy",
 	"frontend",
 	"logger",
 	"nginx",
 	"persist",
+	"scheduler",
 	"systemd",
 }
 
@@ -75,8 +79,26 @@ func (i OpaqueConfig) GetInt(key string) (int, error) {
 	}
 
 	switch v := v.(type) {
-	case uint8, uint16, uint32, uint64, uint, int8, int16, int32, int64, int:
-		return int(v.(int)), nil
+	case int:
+		return v, nil
+	case int8:
+		return int(v), nil
+	case int16:
+		return int(v), nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case uint:
+		return int(v), nil
+	case uint8:
+		return int(v), nil
+	case uint16:
+		return int(v), nil
+	case uint32:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
 	case nil:
 		return 0, nil
 	}
@@ -124,7 +146,7 @@ func Enabled(plugin string) bool {
 }
 
 func GetConfig(plugin string) (*OpaqueConfig, error) {
-	cfg, ok := state.Config.Plugins[plugin]
+	cfg, ok := state.Config().Plugins[plugin]
 
 	if !ok {
 		return nil, errors.New("plugin not enabled")
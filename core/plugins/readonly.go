@@ -0,0 +1,30 @@
+package plugins
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Routes that do not change anything. Only used for matching, the handlers are never called
+var readOnlyMux = chi.NewMux()
+
+// Marks routes of a plugin as read-only so they are not audited, must be called in the Init function
+// of the plugin. GET, HEAD and OPTIONS requests are always read-only.
+//
+// The patterns are chi style patterns relative to the plugin (e.g. /getDeployList), they are prefixed with /api/<plugin>
+func AddReadOnlyRoutes(plugin, method string, patterns ...string) {
+	for _, pattern := range patterns {
+		readOnlyMux.Method(method, "/api/"+plugin+pattern, http.NotFoundHandler())
+	}
+}
+
+// Returns whether a request is read-only, either by its method or because its route was marked with AddReadOnlyRoutes
+func IsReadOnly(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	return readOnlyMux.Match(chi.NewRouteContext(), r.Method, r.URL.Path)
+}
@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/infinitybotlist/sysmanage-web/core/audit"
+	coreconfig "github.com/infinitybotlist/sysmanage-web/core/config"
 	"github.com/infinitybotlist/sysmanage-web/core/plugins"
 	"github.com/infinitybotlist/sysmanage-web/core/server/cmd/builder"
 	"github.com/infinitybotlist/sysmanage-web/core/state"
@@ -130,6 +134,16 @@ func init() {
 				fmt.Println(strings.Join(plList, "\n"))
 			},
 		},
+		{
+			Name:        "checkconfig",
+			Description: "Check config.yaml and show which values were resolved from environment variables or files",
+			Run:         CheckConfigCommand,
+		},
+		{
+			Name:        "audit",
+			Description: "Search the audit log (run with -h for filters)",
+			Run:         AuditCommand,
+		},
 		{
 			Name:        "help",
 			Description: "Show this help message",
@@ -153,6 +167,124 @@ func HelpCommand() {
 	}
 }
 
+// Check config command
+func CheckConfigCommand() {
+	config, resolved, err := coreconfig.LoadWithSources(coreconfig.Path)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var failed bool
+
+	for _, pl := range state.ServerMeta.Plugins {
+		if _, ok := config.Plugins[pl.ID]; !ok {
+			fmt.Println("Plugin " + pl.ID + " not found in config.yaml")
+			failed = true
+		}
+	}
+
+	if len(resolved) == 0 {
+		fmt.Println("No values were resolved from environment variables or files")
+	} else {
+		fmt.Println("Resolved values")
+		fmt.Println()
+
+		// Values are never printed as they are almost always secrets
+		for _, r := range resolved {
+			fmt.Printf("%s <- %s\n", bold(r.Path), r.Source)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+
+	fmt.Println()
+	fmt.Println("config.yaml is OK")
+}
+
+// Audit command
+func AuditCommand() {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+
+	var filter audit.Filter
+	var since, until string
+
+	flags.StringVar(&filter.UserID, "user", "", "Only show calls made by this user id")
+	flags.StringVar(&filter.Plugin, "plugin", "", "Only show calls to this plugin")
+	flags.StringVar(&filter.Route, "route", "", "Only show calls to routes containing this")
+	flags.StringVar(&since, "since", "", "Only show calls made after this time (RFC3339) or duration ago (e.g. 24h)")
+	flags.StringVar(&until, "until", "", "Only show calls made before this time (RFC3339) or duration ago (e.g. 1h)")
+	flags.IntVar(&filter.Limit, "limit", 50, "Maximum number of calls to show, 0 for no limit")
+	flags.Parse(os.Args[2:])
+
+	var err error
+
+	filter.Since, err = parseTime(since)
+
+	if err != nil {
+		fmt.Println("Invalid since: " + err.Error())
+		os.Exit(1)
+	}
+
+	filter.Until, err = parseTime(until)
+
+	if err != nil {
+		fmt.Println("Invalid until: " + err.Error())
+		os.Exit(1)
+	}
+
+	config, err := coreconfig.Load(coreconfig.Path)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if config.Audit.Dir == "" {
+		fmt.Println("Auditing is not enabled, set audit.dir in config.yaml to enable it")
+		os.Exit(1)
+	}
+
+	entries, err := audit.QueryDir(config.Audit.Dir, filter)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for _, e := range entries {
+		user := e.UserID
+
+		if user == "" {
+			user = "-"
+		}
+
+		route := e.Route
+
+		if e.Query != "" {
+			route += "?" + e.Query
+		}
+
+		fmt.Printf("%s %s %s %s %d %s\n", e.Time.Format(time.RFC3339), bold(user), e.Method, route, e.Status, e.RemoteAddr)
+	}
+}
+
+// Parses a RFC3339 timestamp or a duration before now. Empty strings return the zero time
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
 func RunCommand() {
 	arg := os.Args[1]
 
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+// How often certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// Reloads a certificate when its files are rotated on disk
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// Returns the latest modification time of the certificate and key files
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range []string{c.certFile, c.keyFile} {
+		st, err := os.Stat(file)
+
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// Loads the certificate if its files have changed since it was last loaded. Callers must hold c.mu
+func (c *certReloader) reload() error {
+	modTime, err := c.latestModTime()
+
+	if err != nil {
+		return errors.New("Failed to stat certificate: " + err.Error())
+	}
+
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+
+	if err != nil {
+		return errors.New("Failed to load certificate: " + err.Error())
+	}
+
+	if c.cert != nil {
+		fmt.Println("Reloaded rotated certificate " + c.certFile)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) > certCheckInterval {
+		c.lastCheck = time.Now()
+
+		err := c.reload()
+
+		if err != nil {
+			// Keep serving the old certificate while the new one is being written
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}
+
+	return c.cert, nil
+}
+
+// Returns the uid or gid of a user or group name, numeric ids are returned as-is
+func lookupId(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(id)
+}
+
+// Applies the mode and owner of a unix socket
+func setupSocket(l types.Listener) error {
+	if l.Mode != "" {
+		mode, err := strconv.ParseUint(l.Mode, 8, 32)
+
+		if err != nil {
+			return errors.New("invalid mode " + l.Mode + ": " + err.Error())
+		}
+
+		err = os.Chmod(l.Addr, fs.FileMode(mode))
+
+		if err != nil {
+			return errors.New("Failed to set mode of socket: " + err.Error())
+		}
+	}
+
+	if l.Owner == "" && l.Group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+
+	if l.Owner != "" {
+		var err error
+		uid, err = lookupId(l.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+
+			if err != nil {
+				return "", err
+			}
+
+			return u.Uid, nil
+		})
+
+		if err != nil {
+			return errors.New("invalid owner " + l.Owner + ": " + err.Error())
+		}
+	}
+
+	if l.Group != "" {
+		var err error
+		gid, err = lookupId(l.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+
+			if err != nil {
+				return "", err
+			}
+
+			return g.Gid, nil
+		})
+
+		if err != nil {
+			return errors.New("invalid group " + l.Group + ": " + err.Error())
+		}
+	}
+
+	err := os.Chown(l.Addr, uid, gid)
+
+	if err != nil {
+		return errors.New("Failed to set owner of socket: " + err.Error())
+	}
+
+	return nil
+}
+
+// Opens a listener
+func listen(l types.Listener) (net.Listener, error) {
+	switch l.Type {
+	case "", "tcp":
+		ln, err := net.Listen("tcp", l.Addr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if l.TLS == nil {
+			return ln, nil
+		}
+
+		reloader := &certReloader{
+			certFile:  l.TLS.CertFile,
+			keyFile:   l.TLS.KeyFile,
+			lastCheck: time.Now(),
+		}
+
+		err = reloader.reload()
+
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+
+		return tls.NewListener(ln, &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}), nil
+	case "unix":
+		if l.TLS != nil {
+			return nil, errors.New("tls is not supported on unix listeners")
+		}
+
+		// Remove stale sockets left behind by a previous crash
+		if st, err := os.Stat(l.Addr); err == nil && st.Mode()&fs.ModeSocket != 0 {
+			err = os.Remove(l.Addr)
+
+			if err != nil {
+				return nil, errors.New("Failed to remove stale socket: " + err.Error())
+			}
+		}
+
+		ln, err := net.Listen("unix", l.Addr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = setupSocket(l)
+
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+
+		return ln, nil
+	}
+
+	return nil, errors.New("unknown listener type " + l.Type)
+}
+
+// Returns a human readable description of a listener
+func describeListener(l types.Listener) string {
+	switch {
+	case l.Type == "unix":
+		return "unix:" + l.Addr
+	case l.TLS != nil:
+		return "https://" + l.Addr
+	default:
+		return "http://" + l.Addr
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/infinitybotlist/sysmanage-web/core/audit"
+	coreconfig "github.com/infinitybotlist/sysmanage-web/core/config"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+// Ensures only one reload runs at a time
+var reloadLock sync.Mutex
+
+// Checks the auth section of config.yaml
+func validateAuthConfig(c types.AuthConfig) error {
+	err := state.Validator.Struct(c)
+
+	if err != nil {
+		return errors.New("invalid auth config: " + err.Error())
+	}
+
+	return plugins.ValidateAuthConfig(c)
+}
+
+// Returns the plugin in meta with the given id
+func findPlugin(meta types.ServerMeta, id string) (types.Plugin, bool) {
+	for _, plugin := range meta.Plugins {
+		if plugin.ID == id {
+			return plugin, true
+		}
+	}
+
+	return types.Plugin{}, false
+}
+
+// Calls the reload hook of every loaded plugin, stopping at the first error.
+// Returns the plugins that were reloaded successfully
+func reloadPlugins() ([]string, error) {
+	var reloaded []string
+
+	for _, id := range state.LoadedPlugins {
+		plugin, ok := findPlugin(state.ServerMeta, id)
+
+		if !ok || plugin.Reload == nil {
+			continue
+		}
+
+		err := plugin.Reload(&types.PluginConfig{
+			Name: plugin.ID,
+		})
+
+		if err != nil {
+			return reloaded, errors.New("Failed to reload plugin " + plugin.ID + ": " + err.Error())
+		}
+
+		reloaded = append(reloaded, plugin.ID)
+	}
+
+	return reloaded, nil
+}
+
+// Re-reads config.yaml and notifies plugins through their Reload hook. Running
+// tasks (deploys, log tailers etc.) are not affected.
+//
+// If the new config is invalid or a plugin rejects it, the old config is restored
+func Reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	newConfig, err := coreconfig.Load(coreconfig.Path)
+
+	if err != nil {
+		return err
+	}
+
+	for _, id := range state.LoadedPlugins {
+		if _, ok := newConfig.Plugins[id]; !ok {
+			return errors.New("Plugin " + id + " not found in config.yaml")
+		}
+	}
+
+	err = validateAuthConfig(newConfig.Auth)
+
+	if err != nil {
+		return err
+	}
+
+	oldConfig := state.Config()
+
+	if newConfig.Port != oldConfig.Port {
+		fmt.Println("WARNING: port changed in config.yaml, restart sysmanage to apply it")
+	}
+
+	if !reflect.DeepEqual(newConfig.Listeners, oldConfig.Listeners) {
+		fmt.Println("WARNING: listeners changed in config.yaml, restart sysmanage to apply them")
+	}
+
+	if newConfig.LogDir != oldConfig.LogDir {
+		fmt.Println("WARNING: log_dir changed in config.yaml, restart sysmanage to apply it")
+	}
+
+	if (newConfig.Audit.Dir == "") != (oldConfig.Audit.Dir == "") {
+		fmt.Println("WARNING: auditing was enabled or disabled in config.yaml, restart sysmanage to apply it")
+		newConfig.Audit = oldConfig.Audit
+	}
+
+	// Handlers and plugins read the config concurrently, so it is only ever replaced as a whole
+	state.SetConfig(newConfig)
+
+	reloaded, err := reloadPlugins()
+
+	if err != nil {
+		state.SetConfig(oldConfig)
+
+		// Give the plugins that already applied the new config the old one back
+		for _, id := range reloaded {
+			plugin, _ := findPlugin(state.ServerMeta, id)
+
+			rerr := plugin.Reload(&types.PluginConfig{
+				Name: plugin.ID,
+			})
+
+			if rerr != nil {
+				fmt.Println("WARNING: failed to restore config of plugin " + plugin.ID + ": " + rerr.Error())
+			}
+		}
+
+		return err
+	}
+
+	if newConfig.Audit.Dir != "" {
+		err = audit.Init(newConfig.Audit)
+
+		if err != nil {
+			fmt.Println("WARNING: failed to apply new audit config: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Reloads the config whenever sysmanage receives SIGHUP
+func reloadOnSighup() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+
+	go func() {
+		for range sig {
+			fmt.Println("Received SIGHUP, reloading config.yaml")
+
+			err := Reload()
+
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to reload config.yaml: "+err.Error())
+				continue
+			}
+
+			fmt.Println("Reloaded config.yaml")
+		}
+	}()
+}
+
+// Reloads the config on request
+func reloadConfigRoute(w http.ResponseWriter, r *http.Request) {
+	err := Reload()
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Failed to reload config.yaml: " + err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	coreconfig "github.com/infinitybotlist/sysmanage-web/core/config"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+// Runs the test in a directory with the given config.yaml
+func withConfigFile(t *testing.T, contents string) {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, coreconfig.Path), []byte(contents), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldConfig, oldPlugins := state.Config(), state.LoadedPlugins
+
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		state.SetConfig(oldConfig)
+		state.LoadedPlugins = oldPlugins
+	})
+}
+
+// Run with -race, config.yaml used to be swapped without synchronisation
+func TestReloadWhileReading(t *testing.T) {
+	withConfigFile(t, "shutdown_timeout: 7\nplugins:\n  test:\n    value: 1\n")
+
+	state.SetConfig(&types.Config{Plugins: map[string]map[string]any{"test": {"value": 0}}})
+	state.LoadedPlugins = []string{"test"}
+
+	type testConfig struct {
+		Value int `yaml:"value"`
+	}
+
+	var wg sync.WaitGroup
+
+	stop := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				_, err := plugins.DecodeConfig[testConfig]("test")
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		err := Reload()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	cfg, err := plugins.DecodeConfig[testConfig]("test")
+
+	if err != nil || cfg.Value != 1 || state.Config().ShutdownTimeout != 7 {
+		t.Fatalf("got %+v, %v and shutdown timeout %d after reloading, want value 1 and 7", cfg, err, state.Config().ShutdownTimeout)
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	withConfigFile(t, "shutdown_timeout: 7\nplugins: {}\n")
+
+	old := &types.Config{ShutdownTimeout: 3, Plugins: map[string]map[string]any{"test": {}}}
+
+	state.SetConfig(old)
+	state.LoadedPlugins = []string{"test"}
+
+	err := Reload()
+
+	if err == nil || !strings.Contains(err.Error(), "Plugin test not found") {
+		t.Fatalf("got error %v, want the missing plugin to be reported", err)
+	}
+
+	if state.Config() != old {
+		t.Fatalf("config was replaced, got shutdown timeout %d, want 3", state.Config().ShutdownTimeout)
+	}
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -11,20 +14,24 @@ import (
 	"time"
 
 	"github.com/infinitybotlist/sysmanage-web/core"
+	"github.com/infinitybotlist/sysmanage-web/core/audit"
+	coreconfig "github.com/infinitybotlist/sysmanage-web/core/config"
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
 	"github.com/infinitybotlist/sysmanage-web/core/server/cmd"
 	"github.com/infinitybotlist/sysmanage-web/core/state"
 	"github.com/infinitybotlist/sysmanage-web/types"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"gopkg.in/yaml.v3"
 )
 
 var frontend fs.FS
 
-var (
-	config *types.Config
+// Requests to plugin routes are cancelled after this, except for routes on the StreamMux
+var requestTimeout = 30 * time.Second
 
+var (
 	// Subbed frontend embed
 	serverRootSubbed fs.FS
 )
@@ -75,23 +82,39 @@ Sysmanage has undergone some big changes between v0 and v1
 	}
 
 	// Load config.yaml into Config struct
-	file, err := os.Open("config.yaml")
+	config, err := coreconfig.Load(coreconfig.Path)
 
 	if err != nil {
 		panic(err)
 	}
 
-	defer file.Close()
+	state.SetConfig(config)
 
-	decoder := yaml.NewDecoder(file)
+	// Cancelled when the drain timeout is reached on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	state.Context = ctx
 
-	err = decoder.Decode(&config)
+	if config.LogDir != "" {
+		fmt.Println("Persisting task logs to " + config.LogDir)
 
-	if err != nil {
-		panic(err)
+		store, err := logger.NewFileStore(config.LogDir)
+
+		if err != nil {
+			panic(err)
+		}
+
+		logger.LogMap = store
 	}
 
-	state.Config = config
+	if config.Audit.Dir != "" {
+		fmt.Println("Auditing API calls to " + config.Audit.Dir)
+
+		err = audit.Init(config.Audit)
+
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	if meta.FrontendServer != nil {
 		fmt.Println("Starting up external frontend server")
@@ -105,6 +128,88 @@ Sysmanage has undergone some big changes between v0 and v1
 		}
 	}
 
+	r := newRouter(meta)
+
+	if len(state.AuthPlugins) == 0 {
+		fmt.Fprintln(os.Stderr, "No auth plugins loaded. For security purposes, please load at least one auth plugin. You can use `authdp` for a reasonably secure auth plugin")
+		os.Exit(1)
+	}
+
+	reloadOnSighup()
+
+	var port int
+	if meta.Port == 0 && config.Port == 0 {
+		port = 30010
+	}
+
+	if meta.Port != 0 {
+		port = meta.Port
+	}
+
+	if config.Port != 0 {
+		port = config.Port
+	}
+
+	// Listeners in config.yaml take precedence over the ones in meta, if neither are set, listen on the port
+	listeners := config.Listeners
+
+	if len(listeners) == 0 {
+		listeners = meta.Listeners
+	}
+
+	if len(listeners) == 0 {
+		listeners = []types.Listener{
+			{
+				Type: "tcp",
+				Addr: ":" + strconv.Itoa(port),
+			},
+		}
+	}
+
+	for i := range listeners {
+		err = state.Validator.Struct(listeners[i])
+
+		if err != nil {
+			panic("Invalid listener " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+
+	// Create server
+	s := &http.Server{
+		Handler: r,
+	}
+
+	// Open all listeners before serving so a bad listener does not leave the others running
+	lns := make([]net.Listener, 0, len(listeners))
+
+	for _, l := range listeners {
+		ln, err := listen(l)
+
+		if err != nil {
+			panic("Failed to listen on " + describeListener(l) + ": " + err.Error())
+		}
+
+		lns = append(lns, ln)
+	}
+
+	// Start server
+	for i, ln := range lns {
+		fmt.Println("Starting server on " + describeListener(listeners[i]))
+
+		go func(ln net.Listener) {
+			err := s.Serve(ln)
+
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				panic(err)
+			}
+		}(ln)
+	}
+
+	waitForShutdown(s, cancel)
+}
+
+// Creates the router, running the preload scripts and loading the plugins
+func newRouter(meta types.ServerMeta) *chi.Mux {
 	// Create wildcard route
 	r := chi.NewRouter()
 
@@ -137,24 +242,40 @@ Sysmanage has undergone some big changes between v0 and v1
 		}
 	}
 
+	err := validateAuthConfig(state.Config().Auth)
+
+	if err != nil {
+		panic(err)
+	}
+
 	// Load the other middleware post preload
+	//
+	// The auth middleware must come after the preload scripts as auth plugins register their authenticators there
 	r.Use(
-		routeStatic,
-		middleware.Timeout(30*time.Second),
+		plugins.AuthMiddleware,
+		audit.Middleware,
 	)
 
+	// Plugin middleware needing the user of the request, such as the ACL
+	r.Use(plugins.AuthedMiddleware()...)
+
+	r.Use(routeStatic)
+
+	timeout := middleware.Timeout(requestTimeout)
+
 	for _, plugin := range meta.Plugins {
 		fmt.Println("Loading plugin " + plugin.ID)
 
-		if _, ok := state.Config.Plugins[plugin.ID]; !ok {
+		if _, ok := state.Config().Plugins[plugin.ID]; !ok {
 			panic("Plugin " + plugin.ID + " not found in config.yaml")
 		}
 
 		r.Route("/api/"+plugin.ID, func(mr chi.Router) {
 			err := plugin.Init(&types.PluginConfig{
-				Name:   plugin.ID,
-				Mux:    mr,
-				RawMux: r,
+				Name:      plugin.ID,
+				Mux:       mr.With(timeout),
+				StreamMux: mr,
+				RawMux:    r,
 			})
 
 			if err != nil {
@@ -165,40 +286,13 @@ Sysmanage has undergone some big changes between v0 and v1
 		state.LoadedPlugins = append(state.LoadedPlugins, plugin.ID)
 	}
 
-	if len(state.AuthPlugins) == 0 {
-		fmt.Fprintln(os.Stderr, "No auth plugins loaded. For security purposes, please load at least one auth plugin. You can use `authdp` for a reasonably secure auth plugin")
-		os.Exit(1)
-	}
+	// Core API routes
+	r.With(timeout).Post("/api/core/reloadConfig", reloadConfigRoute)
 
 	r.HandleFunc("/*", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnavailableForLegalReasons)
 		w.Write([]byte("API endpoint not found..."))
 	})
 
-	var port int
-	if meta.Port == 0 && config.Port == 0 {
-		port = 30010
-	}
-
-	if meta.Port != 0 {
-		port = meta.Port
-	}
-
-	if config.Port != 0 {
-		port = config.Port
-	}
-
-	// Create server
-	s := &http.Server{
-		Addr:    ":" + strconv.Itoa(port),
-		Handler: r,
-	}
-
-	// Start server
-	fmt.Println("Starting server on port " + strconv.Itoa(port))
-	err = s.ListenAndServe()
-
-	if err != nil {
-		panic(err)
-	}
+	return r
 }
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/plugins/acl"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+// Authenticates requests by the X-Test-User header
+type headerAuthenticator struct{}
+
+func (headerAuthenticator) Authenticate(r *http.Request) (*plugins.Principal, error) {
+	user := r.Header.Get("X-Test-User")
+
+	if user == "" {
+		return nil, nil
+	}
+
+	return &plugins.Principal{ID: user}, nil
+}
+
+// Runs before TestACLRunsAfterAuth registers a authenticator and the ACL, so no auth is needed
+func TestStreamMuxHasNoTimeout(t *testing.T) {
+	old := requestTimeout
+	requestTimeout = 50 * time.Millisecond
+
+	t.Cleanup(func() {
+		requestTimeout = old
+	})
+
+	state.SetConfig(&types.Config{
+		Plugins: map[string]map[string]any{
+			"slow": {},
+		},
+	})
+
+	// Waits for longer than the request timeout unless the request is cancelled
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte("done"))
+		}
+	}
+
+	r := newRouter(types.ServerMeta{
+		Plugins: []types.Plugin{
+			{
+				ID: "slow",
+				Init: func(c *types.PluginConfig) error {
+					c.Mux.Get("/request", slow)
+					c.StreamMux.Get("/stream", slow)
+					return nil
+				},
+			},
+		},
+	})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/api/slow/request", status: http.StatusGatewayTimeout},
+		{path: "/api/slow/stream", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d (%s), want %d", w.Code, w.Body.String(), tt.status)
+			}
+		})
+	}
+}
+
+func TestACLRunsAfterAuth(t *testing.T) {
+	state.SetConfig(&types.Config{
+		Plugins: map[string]map[string]any{
+			"testauth": {},
+			acl.ID: {
+				"roles": map[string]any{
+					"pinger": map[string]any{
+						"permissions": []any{
+							map[string]any{"plugin": "testauth", "routes": []any{"ping"}},
+						},
+					},
+				},
+				"bindings": map[string]any{
+					"alice": []any{"pinger"},
+				},
+			},
+		},
+	})
+
+	r := newRouter(types.ServerMeta{
+		Plugins: []types.Plugin{
+			{
+				ID: "testauth",
+				Preload: func(c *types.PluginConfig) error {
+					plugins.RegisterAuthenticator(c.Name, headerAuthenticator{})
+					return nil
+				},
+				Init: func(c *types.PluginConfig) error {
+					c.Mux.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+						w.Write([]byte(plugins.UserID(r)))
+					})
+					return nil
+				},
+			},
+			{
+				ID:      acl.ID,
+				Preload: acl.Preload,
+				Init:    acl.InitPlugin,
+			},
+		},
+	})
+
+	tests := []struct {
+		name   string
+		user   string
+		status int
+		body   string
+	}{
+		{name: "granted by role", user: "alice", status: http.StatusOK, body: "alice"},
+		{name: "no role", user: "bob", status: http.StatusForbidden},
+		{name: "unauthenticated", status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/testauth/ping", nil)
+
+			if tt.user != "" {
+				req.Header.Set("X-Test-User", tt.user)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d (%s), want %d", w.Code, w.Body.String(), tt.status)
+			}
+
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Fatalf("got body %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
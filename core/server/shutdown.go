@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+)
+
+const (
+	// Default time to wait for running tasks on shutdown
+	defaultShutdownTimeout = 5 * time.Minute
+
+	// Time given to tasks to clean up after being cancelled
+	cancelGracePeriod = 10 * time.Second
+)
+
+// Blocks until sysmanage receives SIGINT or SIGTERM and then shuts down gracefully:
+//
+// - new tasks are rejected, tasks that skip draining (log tailers) are cancelled, log streams are told
+// to end through tasks.Shutdown and the http server stops accepting connections
+//
+// - running tasks (deploys, nginx/systemd builds etc.) are waited on until the drain timeout
+//
+// - tasks still running after the drain timeout are cancelled through state.Context
+func waitForShutdown(s *http.Server, cancel context.CancelFunc) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sig
+
+	// Stop listening for signals so that a second signal exits immediately
+	signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+
+	timeout := defaultShutdownTimeout
+
+	if secs := state.Config().ShutdownTimeout; secs > 0 {
+		timeout = time.Duration(secs) * time.Second
+	}
+
+	fmt.Println("Shutting down, waiting up to " + timeout.String() + " for " + strconv.Itoa(tasks.Running()) + " running tasks to finish (send the signal again to exit immediately)")
+
+	// Before s.Shutdown, which waits for open log streams to return
+	tasks.BeginShutdown()
+
+	ctx, cancelDrain := context.WithTimeout(context.Background(), timeout)
+	defer cancelDrain()
+
+	err := s.Shutdown(ctx)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to shut down http server gracefully: "+err.Error())
+	}
+
+	err = tasks.Drain(ctx)
+
+	if err == nil {
+		// Wait for large scale operations that are not tracked as tasks
+		locked := make(chan struct{})
+
+		go func() {
+			state.LsOp.Lock()
+			close(locked)
+		}()
+
+		select {
+		case <-locked:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Drain timeout reached, cancelling "+strconv.Itoa(tasks.Running())+" running tasks")
+
+		cancel()
+
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), cancelGracePeriod)
+		defer cancelGrace()
+
+		tasks.Drain(graceCtx)
+	}
+
+	fmt.Println("Shutdown complete")
+}
@@ -4,15 +4,27 @@ import (
 	"context"
 	"embed"
 	"sync"
+	"sync/atomic"
 
 	"github.com/infinitybotlist/sysmanage-web/types"
 
 	"github.com/go-playground/validator/v10"
 )
 
-var (
-	Config *types.Config
+// The loaded config.yaml, replaced as a whole on reload
+var config atomic.Pointer[types.Config]
+
+// Returns the current config.yaml. It is replaced on reload, so callers should not hold on to it
+func Config() *types.Config {
+	return config.Load()
+}
 
+// Publishes a new config.yaml. Its contents must not be modified afterwards
+func SetConfig(c *types.Config) {
+	config.Store(c)
+}
+
+var (
 	// Plugins
 	ServerMeta types.ServerMeta
 
@@ -30,6 +42,8 @@ var (
 	// Public API. All plugins handling page authentication should add to this array
 	AuthPlugins = []string{}
 
-	// Public API. List of routes that should be exempted during authentication. Plugins should add to this array if required
+	// Public API. List of full paths that should be exempted during authentication
+	//
+	// Deprecated: use plugins.AddExemptRoute, which supports patterns and methods
 	AuthExemptRoutes = []string{}
 )
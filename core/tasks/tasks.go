@@ -0,0 +1,323 @@
+// Package tasks keeps track of long-running operations (deploys, builds, log tailers etc.)
+//
+// The ID of a task is also the ID of its log entry in logger.LogMap
+package tasks
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/infinitybotlist/eureka/crypto"
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+)
+
+// How long finished tasks are kept in the task list
+const taskRetention = 8 * time.Hour
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+type Task struct {
+	ID        string
+	Kind      string // What the task does, e.g. buildNginx
+	Plugin    string // The plugin that started the task
+	UserID    string // The user that started the task, empty if started by sysmanage itself
+	StartedAt time.Time
+	EndedAt   *time.Time
+	Status    Status
+	Error     string // Set if Status is StatusFailed
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{} // Closed once the task has finished
+	skipDrain bool
+}
+
+// Returned by New once sysmanage has started shutting down
+var ErrShuttingDown = errors.New("sysmanage is shutting down, try again later")
+
+var registry = struct {
+	sync.Mutex
+	tasks        map[string]*Task
+	shuttingDown bool
+	shutdown     chan struct{} // Closed by BeginShutdown
+}{
+	tasks:    map[string]*Task{},
+	shutdown: make(chan struct{}),
+}
+
+// Removes finished tasks past taskRetention. Callers must hold registry
+func prune() {
+	for id, t := range registry.tasks {
+		if t.EndedAt != nil && time.Since(*t.EndedAt) > taskRetention {
+			delete(registry.tasks, id)
+		}
+	}
+}
+
+// Creates and registers a new running task. Returns ErrShuttingDown once sysmanage has started shutting down
+func New(plugin, kind, userId string) (*Task, error) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registry.shuttingDown {
+		return nil, ErrShuttingDown
+	}
+
+	ctx, cancel := context.WithCancel(state.Context)
+
+	t := &Task{
+		ID:        crypto.RandString(64),
+		Kind:      kind,
+		Plugin:    plugin,
+		UserID:    userId,
+		StartedAt: time.Now(),
+		Status:    StatusRunning,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	prune()
+
+	registry.tasks[t.ID] = t
+
+	return t, nil
+}
+
+// Marks the task as not needing to finish before sysmanage exits, it is cancelled on shutdown
+// instead. This should be used for tasks that never end on their own such as log tailers
+func (t *Task) SkipDrain() {
+	registry.Lock()
+	defer registry.Unlock()
+
+	t.skipDrain = true
+}
+
+// Returns the context of the task, this is cancelled when the task is cancelled
+func (t *Task) Context() context.Context {
+	return t.ctx
+}
+
+// Returns the context of the task with the given id or context.Background if it is not a registered task
+func Context(id string) context.Context {
+	registry.Lock()
+	defer registry.Unlock()
+
+	t, ok := registry.tasks[id]
+
+	if !ok {
+		return context.Background()
+	}
+
+	return t.ctx
+}
+
+// Returns a snapshot of the task with the given id
+func Get(id string) (Task, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	t, ok := registry.tasks[id]
+
+	if !ok {
+		return Task{}, false
+	}
+
+	return *t, true
+}
+
+// Returns a snapshot of all tasks, newest first
+func List() []Task {
+	registry.Lock()
+	defer registry.Unlock()
+
+	prune()
+
+	list := make([]Task, 0, len(registry.tasks))
+
+	for _, t := range registry.tasks {
+		list = append(list, *t)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.After(list[j].StartedAt)
+	})
+
+	return list
+}
+
+// Returned by Cancel if the user may not cancel the task
+var ErrNotOwner = errors.New("only the user that started the task or an admin can cancel it")
+
+// Cancels a running task. userId is the user requesting the cancellation, who must have started
+// the task unless admin is set. Tasks started by sysmanage itself can only be cancelled by admins
+func Cancel(id, userId string, admin bool) error {
+	registry.Lock()
+
+	t, ok := registry.tasks[id]
+
+	if !ok {
+		registry.Unlock()
+		return errors.New("task not found")
+	}
+
+	if !admin && (t.UserID == "" || t.UserID != userId) {
+		registry.Unlock()
+		return ErrNotOwner
+	}
+
+	if t.Status != StatusRunning {
+		registry.Unlock()
+		return errors.New("task is not running")
+	}
+
+	t.cancel()
+	registry.Unlock()
+
+	logger.LogMap.Add(id, "Task cancelled by "+userId, true)
+
+	return nil
+}
+
+// Records the exit status of a task and marks its log entry as done with the matching
+// result. Tasks that are not registered only have their log entry marked as done
+func Finish(id string, err error) {
+	result := logger.ResultFromError(err)
+
+	registry.Lock()
+
+	if t, ok := registry.tasks[id]; ok && t.Status == StatusRunning {
+		now := time.Now()
+		t.EndedAt = &now
+
+		switch {
+		case errors.Is(t.ctx.Err(), context.Canceled):
+			t.Status = StatusCancelled
+			result = logger.Result{Status: logger.ResultCancelled}
+		case err != nil:
+			t.Status = StatusFailed
+			t.Error = err.Error()
+		default:
+			t.Status = StatusSucceeded
+		}
+
+		t.cancel()
+		close(t.done)
+	}
+
+	registry.Unlock()
+
+	logger.LogMap.MarkDone(id, result)
+}
+
+// Stops new tasks from being created (see ErrShuttingDown), cancels running tasks that skip
+// draining and closes the channel returned by Shutdown
+func BeginShutdown() {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registry.shuttingDown {
+		return
+	}
+
+	registry.shuttingDown = true
+	close(registry.shutdown)
+
+	for _, t := range registry.tasks {
+		if t.Status == StatusRunning && t.skipDrain {
+			t.cancel()
+		}
+	}
+}
+
+// Returns a channel that is closed once sysmanage starts shutting down. Handlers that only
+// return when their client goes away, such as log streams, should return when it is closed
+func Shutdown() <-chan struct{} {
+	return registry.shutdown
+}
+
+// Returns whether sysmanage is shutting down
+func ShuttingDown() bool {
+	registry.Lock()
+	defer registry.Unlock()
+
+	return registry.shuttingDown
+}
+
+// Cancels running tasks that skip draining and waits for all other running tasks to finish.
+// Returns ctx.Err() if ctx is done before then
+func Drain(ctx context.Context) error {
+	var running []*Task
+
+	registry.Lock()
+
+	for _, t := range registry.tasks {
+		if t.Status != StatusRunning {
+			continue
+		}
+
+		if t.skipDrain {
+			t.cancel()
+			continue
+		}
+
+		running = append(running, t)
+	}
+
+	registry.Unlock()
+
+	for _, t := range running {
+		// A finished task must not be reported as timed out just because ctx is done as well
+		select {
+		case <-t.done:
+			continue
+		default:
+		}
+
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Returns the number of running tasks
+func Running() int {
+	registry.Lock()
+	defer registry.Unlock()
+
+	var n int
+
+	for _, t := range registry.tasks {
+		if t.Status == StatusRunning {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Runs fn as the task with the given id, logging the returned error (if any) and finishing the task with it
+func Run(id string, fn func(id string) error) {
+	err := fn(id)
+
+	if err != nil {
+		logger.LogMap.Add(id, err.Error(), true)
+	}
+
+	Finish(id, err)
+}
@@ -0,0 +1,119 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+)
+
+func TestCancel(t *testing.T) {
+	state.Context = context.Background()
+
+	tests := []struct {
+		name   string
+		owner  string
+		user   string
+		admin  bool
+		err    error
+		cancel bool
+	}{
+		{name: "owner", owner: "alice", user: "alice", cancel: true},
+		{name: "other user", owner: "alice", user: "bob", err: ErrNotOwner},
+		{name: "admin", owner: "alice", user: "bob", admin: true, cancel: true},
+		{name: "started by sysmanage", user: "bob", err: ErrNotOwner},
+		{name: "started by sysmanage as admin", user: "bob", admin: true, cancel: true},
+		{name: "unauthenticated", owner: "alice", err: ErrNotOwner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task, err := New("test", "test", tt.owner)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Cancel(task.ID, tt.user, tt.admin)
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if cancelled := task.Context().Err() != nil; cancelled != tt.cancel {
+				t.Fatalf("task cancelled: %v, want %v", cancelled, tt.cancel)
+			}
+
+			task.cancel()
+		})
+	}
+
+	if err := Cancel("missing", "alice", true); err == nil {
+		t.Fatal("cancelled a task that does not exist")
+	}
+}
+
+// Gives a test a registry of its own, so that shutting down does not leak into other tests
+func resetRegistry(t *testing.T) {
+	reset := func() {
+		registry.Lock()
+		registry.tasks = map[string]*Task{}
+		registry.shuttingDown = false
+		registry.shutdown = make(chan struct{})
+		registry.Unlock()
+	}
+
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestShutdown(t *testing.T) {
+	state.Context = context.Background()
+	resetRegistry(t)
+
+	deploy, err := New("test", "deploy", "alice")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tailer, err := New("test", "tailer", "alice")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tailer.SkipDrain()
+
+	BeginShutdown()
+	BeginShutdown()
+
+	select {
+	case <-Shutdown():
+	default:
+		t.Fatal("shutdown channel is not closed")
+	}
+
+	if tailer.Context().Err() == nil {
+		t.Fatal("task that skips draining was not cancelled")
+	}
+
+	if deploy.Context().Err() != nil {
+		t.Fatal("task that drains was cancelled")
+	}
+
+	if _, err := New("test", "deploy", "alice"); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("got error %v, want %v", err, ErrShuttingDown)
+	}
+
+	Finish(deploy.ID, nil)
+
+	// The deploy finished before the drain timeout, so that is not a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := Drain(ctx); err != nil {
+		t.Fatalf("got error %v draining finished tasks, want nil", err)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"sysmanage/plugins/foo"
 
 	"github.com/infinitybotlist/sysmanage-web/plugins/actions"
+	"github.com/infinitybotlist/sysmanage-web/plugins/audit"
 	"github.com/infinitybotlist/sysmanage-web/plugins/authdp"
+	"github.com/infinitybotlist/sysmanage-web/plugins/authtoken"
 	"github.com/infinitybotlist/sysmanage-web/plugins/deploy"
 	"github.com/infinitybotlist/sysmanage-web/plugins/frontend"
 	"github.com/infinitybotlist/sysmanage-web/plugins/logger"
 	"github.com/infinitybotlist/sysmanage-web/plugins/nginx"
+	"github.com/infinitybotlist/sysmanage-web/plugins/scheduler"
 	"github.com/infinitybotlist/sysmanage-web/plugins/systemd"
 	"github.com/infinitybotlist/sysmanage-web/types"
 )
@@ -17,10 +20,18 @@ var meta = types.ServerMeta{
 	Port:          29393,
 	ConfigVersion: 1,
 	Plugins: []types.Plugin{
+		// Must come before the other auth plugins so that API tokens are checked first
+		{
+			ID:      authtoken.ID,
+			Init:    authtoken.InitPlugin,
+			Preload: authtoken.Preload,
+			Reload:  authtoken.Reload,
+		},
 		{
 			ID:      authdp.ID,
 			Init:    authdp.InitPlugin,
 			Preload: authdp.Preload,
+			Reload:  authdp.Reload,
 		},
 		{
 			ID:   nginx.ID,
@@ -37,15 +48,25 @@ var meta = types.ServerMeta{
 			},
 		},
 		{
-			ID:   actions.ID,
-			Init: actions.InitPlugin,
+			ID:     actions.ID,
+			Init:   actions.InitPlugin,
+			Reload: actions.Reload,
 			Frontend: types.Provider{
 				Provider: "@core",
 			},
 		},
 		{
-			ID:   deploy.ID,
-			Init: deploy.InitPlugin,
+			ID:     deploy.ID,
+			Init:   deploy.InitPlugin,
+			Reload: deploy.Reload,
+			Frontend: types.Provider{
+				Provider: "@core",
+			},
+		},
+		{
+			ID:     scheduler.ID,
+			Init:   scheduler.InitPlugin,
+			Reload: scheduler.Reload,
 			Frontend: types.Provider{
 				Provider: "@core",
 			},
@@ -64,8 +85,13 @@ var meta = types.ServerMeta{
 			},
 		},
 		{
-			ID:   logger.ID,
-			Init: logger.InitPlugin,
+			ID:     logger.ID,
+			Init:   logger.InitPlugin,
+			Reload: logger.Reload,
+		},
+		{
+			ID:   audit.ID,
+			Init: audit.InitPlugin,
 		},
 	},
 	Frontend: types.FrontendConfig{
@@ -51,5 +51,4 @@ require (
 	github.com/go-git/go-git/v5 v5.9.0
 	github.com/go-playground/validator/v10 v10.15.5
 	github.com/infinitybotlist/eureka v0.0.0-20231014041954-1221f31fd729
-	gopkg.in/yaml.v2 v2.4.0
 )
@@ -1,116 +1,220 @@
 package acl
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/infinitybotlist/sysmanage-web/core/plugins/constants"
+	coreplugins "github.com/infinitybotlist/sysmanage-web/core/plugins"
 )
 
+// How long a single ACL entry may take to decide. Entries that take longer deny the request
+var checkTimeout = 5 * time.Second
+
 var (
+	// Guards routes and plugins
+	entriesLock sync.RWMutex
+
 	routes  []ACLRouteEntry
 	plugins []ACLPluginEntry
 )
 
 // Adds an ACL entry for a route to the list of ACL entries
 func AddRoute(e ACLRouteEntry) {
+	if e.Decide == nil && e.CheckFunc == nil {
+		panic("acl route entry " + e.Name + " has no Decide or CheckFunc")
+	}
+
+	entriesLock.Lock()
+	defer entriesLock.Unlock()
+
 	routes = append(routes, e)
 }
 
 // Adds an ACL entry for a plugin to the list of ACL entries
 func AddPlugin(e ACLPluginEntry) {
+	if e.Decide == nil && e.CheckFunc == nil {
+		panic("acl plugin entry " + e.Name + " has no Decide or CheckFunc")
+	}
+
+	entriesLock.Lock()
+	defer entriesLock.Unlock()
+
 	plugins = append(plugins, e)
 }
 
-func CheckACL(userId string, r *http.Request) *ACLCheck {
-	// Call every acl route function in parallel and get return values
-	// If any of them return false, the user is forbidden
-	var wg sync.WaitGroup
-	wg.Add(len(routes))
+// Converts the result of a deprecated CheckFunc to a decision
+func fromBool(allowed bool) Decision {
+	if allowed {
+		return Allow("")
+	}
+
+	return Deny("")
+}
 
-	var flaggedRoutes struct {
-		sync.Mutex
-		value   bool
-		entries []ACLRouteEntry
+func (e ACLRouteEntry) decide(d *ACLRouteData) Decision {
+	if e.Decide != nil {
+		return e.Decide(d)
 	}
 
-	for _, route := range routes {
-		go func(route ACLRouteEntry) {
-			defer wg.Done()
-			if !route.CheckFunc(&ACLRouteData{
-				Request: r,
-				UserID:  userId,
-			}) {
-				defer flaggedRoutes.Unlock()
-				flaggedRoutes.Lock()
-				flaggedRoutes.value = true
-				flaggedRoutes.entries = append(flaggedRoutes.entries, route)
-			}
-		}(route)
+	return fromBool(e.CheckFunc(d))
+}
+
+func (e ACLPluginEntry) decide(d *ACLPluginData) Decision {
+	if e.Decide != nil {
+		return e.Decide(d)
 	}
 
-	wg.Wait()
+	return fromBool(e.CheckFunc(d))
+}
+
+// A pending check
+type check struct {
+	entry  string
+	kind   string
+	decide func(ctx context.Context) Decision
+}
 
-	if !flaggedRoutes.value {
-		return &ACLCheck{
-			PerRoute: flaggedRoutes.entries,
+// Runs a check with a timeout. Checks that panic or time out deny the request, the context
+// given to the check is cancelled once this returns so that it can stop
+func (c check) run(ctx context.Context) (d Decision) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	res := make(chan Decision, 1)
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				res <- Deny(fmt.Sprint("check panicked: ", err))
+			}
+		}()
+
+		res <- c.decide(ctx)
+	}()
+
+	select {
+	case d = <-res:
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return Deny("check timed out after " + checkTimeout.String())
 		}
-	}
 
-	// Next check per-plugin
-	if !strings.HasPrefix(r.URL.Path, "/api/") {
-		// Not a plugin
-		return nil
+		return Deny("request cancelled")
 	}
 
-	// Get plugin name
-	if len(strings.Split(r.URL.Path, "/")) < 3 {
-		// Not a plugin
-		return nil
+	switch d.Effect {
+	case EffectAllow, EffectDeny, EffectAbstain:
+		return d
+	default:
+		return Deny("check returned invalid effect " + string(d.Effect))
 	}
+}
 
-	pluginName := strings.Split(r.URL.Path, "/")[2]
+// Built-in route entry for the roles in config.yaml
+var rbacEntry = ACLRouteEntry{
+	Name:        "rbac",
+	Description: "Role based access control from config.yaml",
+	Decide: func(d *ACLRouteData) Decision {
+		return rbacDecide(d.UserID, d.Request)
+	},
+}
 
-	// Call every acl plugin function in parallel and get return values
-	// If any of them return false, the user is forbidden
+// Checks whether a user may access a request. All entries are run concurrently and any entry
+// denying the request denies it. If no entry allows it either, the default effect applies.
+// The verdicts are always in the same order
+func CheckACL(userId string, r *http.Request) *ACLCheck {
+	entriesLock.RLock()
+	routeEntries := append([]ACLRouteEntry{rbacEntry}, routes...)
+	pluginEntries := append([]ACLPluginEntry(nil), plugins...)
+	entriesLock.RUnlock()
+
+	var checks []check
+
+	for _, e := range routeEntries {
+		e := e
+		checks = append(checks, check{
+			entry: e.Name,
+			kind:  "route",
+			decide: func(ctx context.Context) Decision {
+				return e.decide(&ACLRouteData{
+					Context: ctx,
+					Request: r.WithContext(ctx),
+					UserID:  userId,
+				})
+			},
+		})
+	}
 
-	var flaggedPlugins struct {
-		sync.Mutex
-		value   bool
-		entries []ACLPluginEntry
+	// Plugin entries only apply to plugin routes (/api/<plugin>/...)
+	if pluginName, _, ok := splitApiPath(r.URL.Path); ok {
+		for _, e := range pluginEntries {
+			e := e
+			checks = append(checks, check{
+				entry: e.Name,
+				kind:  "plugin",
+				decide: func(ctx context.Context) Decision {
+					return e.decide(&ACLPluginData{
+						Context: ctx,
+						Plugin:  pluginName,
+						Request: r.WithContext(ctx),
+						UserID:  userId,
+					})
+				},
+			})
+		}
 	}
 
-	for _, plugin := range plugins {
-		go func(plugin ACLPluginEntry) {
+	verdicts := make([]Verdict, len(checks))
+
+	var wg sync.WaitGroup
+	wg.Add(len(checks))
+
+	for i, c := range checks {
+		go func(i int, c check) {
 			defer wg.Done()
-			if !plugin.CheckFunc(&ACLPluginData{
-				Plugin:  pluginName,
-				Request: r,
-				UserID:  userId,
-			}) {
-				defer flaggedPlugins.Unlock()
-				flaggedPlugins.Lock()
-				flaggedPlugins.value = true
-				flaggedPlugins.entries = append(flaggedPlugins.entries, plugin)
+
+			verdicts[i] = Verdict{
+				Entry:    c.entry,
+				Kind:     c.kind,
+				Decision: c.run(r.Context()),
 			}
-		}(plugin)
+		}(i, c)
 	}
 
 	wg.Wait()
 
-	if !flaggedPlugins.value {
-		return &ACLCheck{
-			PerPlugin: flaggedPlugins.entries,
+	res := &ACLCheck{
+		Effect:   defaultEffect(),
+		Verdicts: verdicts,
+	}
+
+	for _, v := range verdicts {
+		switch v.Decision.Effect {
+		case EffectDeny:
+			res.Effect = EffectDeny
+			return res
+		case EffectAllow:
+			res.Effect = EffectAllow
 		}
 	}
 
-	return nil
+	return res
 }
 
 func MuxMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userId := r.Header.Get(constants.UserIdHeader)
+		// Auth-exempt routes (such as webhooks) have no user to check
+		if coreplugins.IsExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userId := coreplugins.UserID(r)
 
 		if userId == "" {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -120,32 +224,26 @@ func MuxMiddleware(next http.Handler) http.Handler {
 
 		chk := CheckACL(userId, r)
 
-		if chk != nil {
-			w.WriteHeader(http.StatusForbidden)
+		if !chk.Allowed() {
+			var reasons []string
 
-			if len(chk.PerRoute) > 0 {
-				var aclentries []string
+			for _, v := range chk.Denials() {
+				reason := v.Kind + " " + v.Entry
 
-				for _, entry := range chk.PerRoute {
-					aclentries = append(aclentries, entry.Name)
+				if v.Decision.Reason != "" {
+					reason += " (" + v.Decision.Reason + ")"
 				}
 
-				w.Write([]byte("Forbidden by per-route ACL:" + strings.Join(aclentries, ", ")))
-				return
+				reasons = append(reasons, reason)
 			}
 
-			if len(chk.PerPlugin) > 0 {
-				var aclentries []string
-
-				for _, entry := range chk.PerPlugin {
-					aclentries = append(aclentries, entry.Name)
-				}
-
-				w.Write([]byte("Forbidden by per-plugin ACL:" + strings.Join(aclentries, ", ")))
-				return
+			if len(reasons) == 0 {
+				reasons = append(reasons, "no entry allowed the request")
 			}
 
-			panic("SAFETY VIOLATION: acl check failed with no entries returned")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden by ACL: " + strings.Join(reasons, ", ")))
+			return
 		}
 
 		next.ServeHTTP(w, r)
@@ -0,0 +1,33 @@
+package acl
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	coreplugins "github.com/infinitybotlist/sysmanage-web/core/plugins"
+)
+
+func loadAclApi(r chi.Router) {
+	r.Get("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		userId := coreplugins.UserID(r)
+
+		who := WhoAmI{
+			UserID:      userId,
+			RBAC:        rbacEnabled(),
+			Roles:       RolesOf(userId),
+			Permissions: permissionsOf(userId),
+		}
+
+		bytes, err := json.Marshal(who)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to marshal permissions."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+}
@@ -0,0 +1,259 @@
+package acl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func setEntries(t *testing.T, cfg Config, r []ACLRouteEntry, p []ACLPluginEntry) {
+	entriesLock.Lock()
+	routes, plugins = r, p
+	entriesLock.Unlock()
+
+	rbacLock.Lock()
+	rbac = cfg
+	rbacLock.Unlock()
+
+	t.Cleanup(func() {
+		entriesLock.Lock()
+		routes, plugins = nil, nil
+		entriesLock.Unlock()
+
+		rbacLock.Lock()
+		rbac = Config{}
+		rbacLock.Unlock()
+	})
+}
+
+func routeEntry(name string, d Decision) ACLRouteEntry {
+	return ACLRouteEntry{
+		Name: name,
+		Decide: func(*ACLRouteData) Decision {
+			return d
+		},
+	}
+}
+
+func TestCheckACL(t *testing.T) {
+	roles := Config{
+		Roles: map[string]Role{
+			"viewer": {Permissions: []Permission{{Plugin: "systemd", Routes: []string{"get*"}}}},
+		},
+		Bindings: map[string][]string{"alice": {"viewer"}},
+		Default:  EffectDeny,
+	}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		routes   []ACLRouteEntry
+		plugins  []ACLPluginEntry
+		user     string
+		path     string
+		want     Effect
+		verdicts []Effect
+	}{
+		{
+			name:     "all abstain defaults to deny",
+			path:     "/api/systemd/getServiceList",
+			want:     EffectDeny,
+			verdicts: []Effect{EffectAbstain},
+		},
+		{
+			name:     "all abstain with default allow",
+			cfg:      Config{Default: EffectAllow},
+			path:     "/api/systemd/getServiceList",
+			want:     EffectAllow,
+			verdicts: []Effect{EffectAbstain},
+		},
+		{
+			name:     "frontend is allowed",
+			path:     "/systemd",
+			want:     EffectAllow,
+			verdicts: []Effect{EffectAllow},
+		},
+		{
+			name:     "public route is allowed",
+			cfg:      roles,
+			user:     "bob",
+			path:     "/api/acl/whoami",
+			want:     EffectAllow,
+			verdicts: []Effect{EffectAllow},
+		},
+		{
+			name:     "role grants route",
+			cfg:      roles,
+			user:     "alice",
+			path:     "/api/systemd/getServiceList",
+			want:     EffectAllow,
+			verdicts: []Effect{EffectAllow},
+		},
+		{
+			name:     "role does not grant route",
+			cfg:      roles,
+			user:     "alice",
+			path:     "/api/systemd/restartServer",
+			want:     EffectDeny,
+			verdicts: []Effect{EffectDeny},
+		},
+		{
+			name:     "user without roles",
+			cfg:      roles,
+			user:     "bob",
+			path:     "/api/systemd/getServiceList",
+			want:     EffectDeny,
+			verdicts: []Effect{EffectDeny},
+		},
+		{
+			name:     "deny overrides allow",
+			cfg:      roles,
+			routes:   []ACLRouteEntry{routeEntry("freeze", Deny("change freeze"))},
+			user:     "alice",
+			path:     "/api/systemd/getServiceList",
+			want:     EffectDeny,
+			verdicts: []Effect{EffectAllow, EffectDeny},
+		},
+		{
+			name:     "entry allows over default deny",
+			routes:   []ACLRouteEntry{routeEntry("open", Allow(""))},
+			path:     "/api/systemd/getServiceList",
+			want:     EffectAllow,
+			verdicts: []Effect{EffectAbstain, EffectAllow},
+		},
+		{
+			name: "deprecated check func",
+			cfg:  Config{Default: EffectAllow},
+			routes: []ACLRouteEntry{{
+				Name:      "legacy",
+				CheckFunc: func(*ACLRouteData) bool { return false },
+			}},
+			path:     "/api/systemd/getServiceList",
+			want:     EffectDeny,
+			verdicts: []Effect{EffectAbstain, EffectDeny},
+		},
+		{
+			name: "panicking entry denies",
+			cfg:  Config{Default: EffectAllow},
+			routes: []ACLRouteEntry{{
+				Name:   "panics",
+				Decide: func(*ACLRouteData) Decision { panic("boom") },
+			}},
+			path:     "/api/systemd/getServiceList",
+			want:     EffectDeny,
+			verdicts: []Effect{EffectAbstain, EffectDeny},
+		},
+		{
+			name:     "invalid effect denies",
+			cfg:      Config{Default: EffectAllow},
+			routes:   []ACLRouteEntry{routeEntry("invalid", Decision{Effect: "maybe"})},
+			path:     "/api/systemd/getServiceList",
+			want:     EffectDeny,
+			verdicts: []Effect{EffectAbstain, EffectDeny},
+		},
+		{
+			name: "plugin entry gets plugin name",
+			plugins: []ACLPluginEntry{{
+				Name: "systemd only",
+				Decide: func(d *ACLPluginData) Decision {
+					if d.Plugin == "systemd" {
+						return Allow("")
+					}
+
+					return Deny("")
+				},
+			}},
+			path:     "/api/systemd/getServiceList",
+			want:     EffectAllow,
+			verdicts: []Effect{EffectAbstain, EffectAllow},
+		},
+		{
+			name: "plugin entries skip non plugin routes",
+			plugins: []ACLPluginEntry{{
+				Name:   "deny all",
+				Decide: func(*ACLPluginData) Decision { return Deny("") },
+			}},
+			path:     "/systemd",
+			want:     EffectAllow,
+			verdicts: []Effect{EffectAllow},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEntries(t, tt.cfg, tt.routes, tt.plugins)
+
+			chk := CheckACL(tt.user, httptest.NewRequest(http.MethodPost, tt.path, nil))
+
+			if chk.Effect != tt.want {
+				t.Errorf("got effect %s, want %s (verdicts %+v)", chk.Effect, tt.want, chk.Verdicts)
+			}
+
+			if len(chk.Verdicts) != len(tt.verdicts) {
+				t.Fatalf("got %d verdicts (%+v), want %d", len(chk.Verdicts), chk.Verdicts, len(tt.verdicts))
+			}
+
+			for i, v := range chk.Verdicts {
+				if v.Decision.Effect != tt.verdicts[i] {
+					t.Errorf("verdict %d of %s: got %s, want %s", i, v.Entry, v.Decision.Effect, tt.verdicts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckACLCancelsTimedOutChecks(t *testing.T) {
+	old := checkTimeout
+	checkTimeout = 50 * time.Millisecond
+
+	t.Cleanup(func() {
+		checkTimeout = old
+	})
+
+	stopped := make(chan error, 1)
+
+	setEntries(t, Config{Default: EffectAllow}, []ACLRouteEntry{{
+		Name: "slow",
+		Decide: func(d *ACLRouteData) Decision {
+			<-d.Context.Done()
+			stopped <- d.Request.Context().Err()
+			return Allow("")
+		},
+	}}, nil)
+
+	chk := CheckACL("alice", httptest.NewRequest(http.MethodPost, "/api/systemd/getServiceList", nil))
+
+	if chk.Allowed() {
+		t.Fatal("timed out check did not deny the request")
+	}
+
+	select {
+	case err := <-stopped:
+		if err == nil {
+			t.Fatal("request context of the check was not cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("check was not cancelled after timing out")
+	}
+}
+
+func TestCheckACLStopsOnCancelledRequest(t *testing.T) {
+	setEntries(t, Config{Default: EffectAllow}, []ACLRouteEntry{{
+		Name: "slow",
+		Decide: func(d *ACLRouteData) Decision {
+			<-d.Context.Done()
+			return Allow("")
+		},
+	}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/systemd/getServiceList", nil).WithContext(ctx)
+
+	if CheckACL("alice", req).Allowed() {
+		t.Fatal("check of a cancelled request did not deny it")
+	}
+}
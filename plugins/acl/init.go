@@ -2,6 +2,7 @@
 package acl
 
 import (
+	coreplugins "github.com/infinitybotlist/sysmanage-web/core/plugins"
 	"github.com/infinitybotlist/sysmanage-web/types"
 )
 
@@ -13,11 +14,25 @@ func InitPlugin(c *types.PluginConfig) error {
 	if !preloaded {
 		panic("acl plugin must be preloaded")
 	}
+
+	err := loadConfig(c.Name)
+
+	if err != nil {
+		return err
+	}
+
+	loadAclApi(c.Mux)
+
 	return nil
 }
 
+func Reload(c *types.PluginConfig) error {
+	return loadConfig(c.Name)
+}
+
 func Preload(c *types.PluginConfig) error {
-	c.RawMux.Use(MuxMiddleware)
+	// The ACL needs the user of the request, so it must run after the auth chain
+	coreplugins.UseAuthed(MuxMiddleware)
 	preloaded = true
 	return nil
 }
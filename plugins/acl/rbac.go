@@ -0,0 +1,213 @@
+package acl
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	coreplugins "github.com/infinitybotlist/sysmanage-web/core/plugins"
+)
+
+// Routes every authenticated user may access regardless of their roles
+var rbacPublicRoutes = []string{
+	"/api/acl/whoami",
+	"/api/frontend/getRegisteredLinks", // Only returns the links the user may access
+}
+
+// Binding that applies to all users
+const anyUser = "*"
+
+var (
+	// Guards rbac as it can change on reload
+	rbacLock sync.RWMutex
+	rbac     Config
+)
+
+// Loads the acl section of config.yaml
+func loadConfig(name string) error {
+	cfg, err := coreplugins.DecodeConfig[Config](name)
+
+	if err != nil {
+		return errors.New("Failed to get acl config: " + err.Error())
+	}
+
+	var errs []string
+
+	for user, roles := range cfg.Bindings {
+		for _, role := range roles {
+			if _, ok := cfg.Roles[role]; !ok {
+				errs = append(errs, "plugins."+name+".bindings."+user+": unknown role "+role)
+			}
+		}
+	}
+
+	for role, r := range cfg.Roles {
+		for i, perm := range r.Permissions {
+			for _, glob := range append([]string{perm.Plugin}, perm.Routes...) {
+				if _, err := path.Match(glob, ""); err != nil {
+					errs = append(errs, "plugins."+name+".roles."+role+".permissions["+strconv.Itoa(i)+"]: invalid glob "+glob)
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.New("invalid config:\n  " + strings.Join(errs, "\n  "))
+	}
+
+	rbacLock.Lock()
+	defer rbacLock.Unlock()
+
+	rbac = *cfg
+
+	return nil
+}
+
+// Returns whether RBAC is enabled, i.e. roles have been configured
+func rbacEnabled() bool {
+	rbacLock.RLock()
+	defer rbacLock.RUnlock()
+
+	return len(rbac.Roles) > 0
+}
+
+// Splits a API path into the plugin and the route within the plugin, e.g.
+// /api/systemd/getServiceList becomes systemd and getServiceList
+func splitApiPath(p string) (plugin, route string, ok bool) {
+	rest, ok := strings.CutPrefix(p, "/api/")
+
+	if !ok {
+		return "", "", false
+	}
+
+	plugin, route, _ = strings.Cut(rest, "/")
+
+	return plugin, route, plugin != ""
+}
+
+// Returns whether a permission grants access to a method on a route of a plugin
+func (p Permission) matches(plugin, route, method string) bool {
+	if ok, _ := path.Match(p.Plugin, plugin); !ok {
+		return false
+	}
+
+	// Frontend link checks only ask whether the plugin is accessible at all
+	if route == "@frontend" {
+		return true
+	}
+
+	if len(p.Methods) > 0 {
+		var found bool
+
+		for _, m := range p.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(p.Routes) == 0 {
+		return true
+	}
+
+	for _, glob := range p.Routes {
+		if ok, _ := path.Match(glob, route); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the roles bound to a user in config.yaml, sorted by name
+func RolesOf(userId string) []string {
+	rbacLock.RLock()
+	defer rbacLock.RUnlock()
+
+	seen := map[string]bool{}
+	var roles []string
+
+	for _, binding := range []string{userId, anyUser} {
+		for _, role := range rbac.Bindings[binding] {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	sort.Strings(roles)
+
+	return roles
+}
+
+// Returns the permissions granted to a user through their roles
+func permissionsOf(userId string) []RolePermission {
+	roles := RolesOf(userId)
+
+	rbacLock.RLock()
+	defer rbacLock.RUnlock()
+
+	var perms []RolePermission
+
+	for _, role := range roles {
+		for _, perm := range rbac.Roles[role].Permissions {
+			perms = append(perms, RolePermission{
+				Role:       role,
+				Permission: perm,
+			})
+		}
+	}
+
+	return perms
+}
+
+// Returns the effect of requests no entry decided on
+func defaultEffect() Effect {
+	rbacLock.RLock()
+	defer rbacLock.RUnlock()
+
+	if rbac.Default == "" {
+		return EffectDeny
+	}
+
+	return rbac.Default
+}
+
+// Checks a request against the configured roles. The frontend and public routes are always
+// allowed as they expose no data, other requests are abstained from if no roles are configured
+func rbacDecide(userId string, r *http.Request) Decision {
+	for _, p := range rbacPublicRoutes {
+		if r.URL.Path == p {
+			return Allow("public route")
+		}
+	}
+
+	plugin, route, ok := splitApiPath(r.URL.Path)
+
+	if !ok {
+		return Allow("frontend")
+	}
+
+	if !rbacEnabled() {
+		return Abstain()
+	}
+
+	for _, perm := range permissionsOf(userId) {
+		if perm.Permission.matches(plugin, route, r.Method) {
+			return Allow("granted by role " + perm.Role)
+		}
+	}
+
+	return Deny("no role grants " + r.Method + " on " + plugin + "/" + route)
+}
@@ -1,41 +1,155 @@
 package acl
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
+
+type Effect string
+
+const (
+	// The entry grants access
+	EffectAllow Effect = "allow"
+	// The entry forbids access, this overrides any other entry
+	EffectDeny Effect = "deny"
+	// The entry does not apply to the request
+	EffectAbstain Effect = "abstain"
+)
+
+// The decision of a single ACL entry
+type Decision struct {
+	Effect Effect `json:"effect"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func Allow(reason string) Decision {
+	return Decision{Effect: EffectAllow, Reason: reason}
+}
+
+func Deny(reason string) Decision {
+	return Decision{Effect: EffectDeny, Reason: reason}
+}
+
+func Abstain() Decision {
+	return Decision{Effect: EffectAbstain}
+}
 
 // Per-route ACLs
 
 // A function that checks if a user is allowed to access a route
+//
+// Deprecated: use ACLRouteDecideFunc which can explain its decision
 type ACLRouteFunc func(d *ACLRouteData) bool
 
-// Defines a ACL entry on a route
+// A function that decides if a user is allowed to access a route
+type ACLRouteDecideFunc func(d *ACLRouteData) Decision
+
+// Defines a ACL entry on a route. One of Decide or CheckFunc must be set, Decide takes precedence
 type ACLRouteEntry struct {
 	Name        string
 	Description string
+	Decide      ACLRouteDecideFunc
 	CheckFunc   ACLRouteFunc
 }
 
+// Entries must return once Context is done, it is also the context of Request
 type ACLRouteData struct {
+	Context context.Context // Cancelled once the check times out or the request is cancelled
 	Request *http.Request
 	UserID  string
 }
 
 // Per-plugin ACLs
+
+// Deprecated: use ACLPluginDecideFunc which can explain its decision
 type ACLPluginFun func(d *ACLPluginData) bool
 
+type ACLPluginDecideFunc func(d *ACLPluginData) Decision
+
+// Defines a ACL entry on a plugin. One of Decide or CheckFunc must be set, Decide takes precedence
 type ACLPluginEntry struct {
 	Name        string
 	Description string
+	Decide      ACLPluginDecideFunc
 	CheckFunc   ACLPluginFun
 }
 
+// Entries must return once Context is done, it is also the context of Request
 type ACLPluginData struct {
+	Context context.Context // Cancelled once the check times out or the request is cancelled
 	Plugin  string
 	Request *http.Request
 	UserID  string
 }
 
+// The decision of a ACL entry on a request
+type Verdict struct {
+	Entry    string   `json:"entry"`
+	Kind     string   `json:"kind"` // route or plugin
+	Decision Decision `json:"decision"`
+}
+
+// The result of CheckACL
 type ACLCheck struct {
-	// Per-route ACLs
-	PerRoute  []ACLRouteEntry
-	PerPlugin []ACLPluginEntry
+	// Deny if any entry denied the request, allow if any entry allowed it and
+	// otherwise the default effect from config.yaml
+	Effect Effect
+
+	// Decisions of all entries in evaluation order: roles, route entries and then
+	// plugin entries, each in the order they were added
+	Verdicts []Verdict
+}
+
+// Returns whether the request is allowed
+func (c *ACLCheck) Allowed() bool {
+	return c.Effect != EffectDeny
+}
+
+// Returns the verdicts of the entries that denied the request
+func (c *ACLCheck) Denials() []Verdict {
+	var denials []Verdict
+
+	for _, v := range c.Verdicts {
+		if v.Decision.Effect == EffectDeny {
+			denials = append(denials, v)
+		}
+	}
+
+	return denials
+}
+
+// Role based access control
+
+// The acl section of config.yaml
+type Config struct {
+	Roles    map[string]Role     `yaml:"roles" validate:"dive"`
+	Bindings map[string][]string `yaml:"bindings"` // User ID to role names, use * to bind roles to all users
+
+	// Effect when every entry abstains, e.g. when no roles are configured. Defaults to deny
+	Default Effect `yaml:"default" default:"deny" validate:"oneof=allow deny"`
+}
+
+type Role struct {
+	Description string       `yaml:"description" json:"description"`
+	Permissions []Permission `yaml:"permissions" json:"permissions" validate:"dive"`
+}
+
+// Grants access to routes of a plugin
+type Permission struct {
+	Plugin  string   `yaml:"plugin" json:"plugin" validate:"required"` // Plugin ID, may be a glob such as *
+	Routes  []string `yaml:"routes" json:"routes"`                     // Route globs within the plugin such as get*, all routes if empty
+	Methods []string `yaml:"methods" json:"methods"`                   // HTTP methods, all methods if empty
+}
+
+// A permission along with the role granting it
+type RolePermission struct {
+	Role       string     `json:"role"`
+	Permission Permission `json:"permission"`
+}
+
+type WhoAmI struct {
+	UserID      string           `json:"user_id"`
+	RBAC        bool             `json:"rbac"` // False if no roles are configured, access then depends on the default effect
+	Roles       []string         `json:"roles"`
+	Permissions []RolePermission `json:"permissions"`
 }
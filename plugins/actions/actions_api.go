@@ -2,14 +2,30 @@ package actions
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
 )
 
 func loadActionsApi(r chi.Router) {
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost, "/getActionList")
+
 	r.Post("/getActionList", func(w http.ResponseWriter, r *http.Request) {
-		bytes, err := json.Marshal(Actions)
+		userId := plugins.UserID(r)
+
+		// Only show the actions the user can execute
+		list := actionList{}
+
+		for _, action := range allActions() {
+			if action.allowed(userId) {
+				list = append(list, action)
+			}
+		}
+
+		bytes, err := json.Marshal(list)
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -29,7 +45,7 @@ func loadActionsApi(r chi.Router) {
 			return
 		}
 
-		action, ok := Actions.Find(actionName)
+		action, ok := allActions().Find(actionName)
 
 		if !ok {
 			w.WriteHeader(http.StatusBadRequest)
@@ -37,7 +53,39 @@ func loadActionsApi(r chi.Router) {
 			return
 		}
 
-		response, err := action.Handler(&ActionContext{Request: r})
+		userId := plugins.UserID(r)
+
+		if !action.allowed(userId) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("You are not allowed to execute this action"))
+			return
+		}
+
+		// Parameters are sent as a JSON object, the body may be empty if the action has none
+		var raw map[string]any
+
+		err := json.NewDecoder(r.Body).Decode(&raw)
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Failed to decode parameters: " + err.Error()))
+			return
+		}
+
+		params, err := action.decodeParams(raw)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		response, err := action.Handler(&ActionContext{
+			Request: r,
+			Action:  action,
+			UserID:  userId,
+			Params:  params,
+		})
 
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -1,12 +1,34 @@
 package actions
 
 import (
+	"errors"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
 	"github.com/infinitybotlist/sysmanage-web/plugins/frontend"
 	"github.com/infinitybotlist/sysmanage-web/types"
 )
 
 const ID = "actions"
 
+// Loads the actions section of config.yaml along with the shell actions
+func loadConfig(name string) error {
+	cfg, err := plugins.DecodeConfig[Config](name)
+
+	if err != nil {
+		return errors.New("Failed to get actions config: " + err.Error())
+	}
+
+	if cfg.DataDir == "" {
+		shellLock.Lock()
+		defer shellLock.Unlock()
+
+		shellActions = actionList{}
+		return nil
+	}
+
+	return loadShellActions(cfg.DataDir)
+}
+
 func InitPlugin(c *types.PluginConfig) error {
 	// Register links
 	frontend.AddLink(c, frontend.Link{
@@ -16,6 +38,17 @@ func InitPlugin(c *types.PluginConfig) error {
 		Href:        "@root",
 	})
 
+	err := loadConfig(c.Name)
+
+	if err != nil {
+		return err
+	}
+
 	loadActionsApi(c.Mux)
 	return nil
 }
+
+// Reloads the shell actions
+func Reload(c *types.PluginConfig) error {
+	return loadConfig(c.Name)
+}
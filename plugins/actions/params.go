@@ -0,0 +1,184 @@
+package actions
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"golang.org/x/exp/slices"
+)
+
+// Checks that a action is well defined, this is called when the action is registered
+func (a *Action) validate() error {
+	if a.Name == "" {
+		return errors.New("action has no name")
+	}
+
+	if a.Handler == nil {
+		return errors.New("action " + a.Name + " has no handler")
+	}
+
+	seen := map[string]bool{}
+
+	for _, p := range a.Params {
+		err := state.Validator.Struct(p)
+
+		if err != nil {
+			return errors.New("invalid parameter " + p.Name + " of action " + a.Name + ": " + err.Error())
+		}
+
+		if seen[p.Name] {
+			return errors.New("duplicate parameter " + p.Name + " in action " + a.Name)
+		}
+
+		seen[p.Name] = true
+
+		if p.Pattern != "" {
+			if _, err := regexp.Compile(p.Pattern); err != nil {
+				return errors.New("invalid pattern for parameter " + p.Name + " of action " + a.Name + ": " + err.Error())
+			}
+		}
+
+		if p.Default != nil {
+			if _, err := p.decode(p.Default); err != nil {
+				return errors.New("invalid default for parameter " + p.Name + " of action " + a.Name + ": " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decodes and validates a single value of a parameter
+func (p Param) decode(v any) (any, error) {
+	switch p.Type {
+	case ParamString, ParamEnum:
+		s, ok := v.(string)
+
+		if !ok {
+			return nil, errors.New("must be a string")
+		}
+
+		if p.Required && s == "" {
+			return nil, errors.New("is required")
+		}
+
+		if p.Type == ParamEnum {
+			if !slices.Contains(p.Options, s) {
+				return nil, errors.New("must be one of " + strings.Join(p.Options, ", "))
+			}
+
+			return s, nil
+		}
+
+		if p.MaxLength > 0 && len(s) > p.MaxLength {
+			return nil, errors.New("must be at most " + strconv.Itoa(p.MaxLength) + " characters long")
+		}
+
+		if p.Pattern != "" && !regexp.MustCompile(p.Pattern).MatchString(s) {
+			return nil, errors.New("must match " + p.Pattern)
+		}
+
+		return s, nil
+	case ParamBool:
+		b, ok := v.(bool)
+
+		if !ok {
+			return nil, errors.New("must be a boolean")
+		}
+
+		return b, nil
+	case ParamNumber:
+		var n float64
+
+		switch v := v.(type) {
+		case float64:
+			n = v
+		case int:
+			n = float64(v)
+		default:
+			return nil, errors.New("must be a number")
+		}
+
+		if p.Integer && n != math.Trunc(n) {
+			return nil, errors.New("must be a whole number")
+		}
+
+		if p.Min != nil && n < *p.Min {
+			return nil, errors.New("must be at least " + strconv.FormatFloat(*p.Min, 'f', -1, 64))
+		}
+
+		if p.Max != nil && n > *p.Max {
+			return nil, errors.New("must be at most " + strconv.FormatFloat(*p.Max, 'f', -1, 64))
+		}
+
+		return n, nil
+	}
+
+	return nil, errors.New("unknown parameter type " + string(p.Type))
+}
+
+// Decodes the parameters sent by a client, returning all errors at once
+func (a *Action) decodeParams(raw map[string]any) (map[string]any, error) {
+	params := map[string]any{}
+
+	var errs []string
+
+	for _, p := range a.Params {
+		v, ok := raw[p.Name]
+
+		if !ok || v == nil {
+			if p.Default != nil {
+				v = p.Default
+			} else if p.Required {
+				errs = append(errs, p.Name+": is required")
+				continue
+			} else {
+				continue
+			}
+		}
+
+		decoded, err := p.decode(v)
+
+		if err != nil {
+			errs = append(errs, p.Name+": "+err.Error())
+			continue
+		}
+
+		params[p.Name] = decoded
+	}
+
+	for name := range raw {
+		if !slices.ContainsFunc(a.Params, func(p Param) bool { return p.Name == name }) {
+			errs = append(errs, name+": unknown parameter")
+		}
+	}
+
+	if len(errs) > 0 {
+		slices.Sort(errs)
+		return nil, errors.New("invalid parameters: " + strings.Join(errs, "; "))
+	}
+
+	return params, nil
+}
+
+// Returns the value of a string or enum parameter, empty if unset
+func (c *ActionContext) String(name string) string {
+	s, _ := c.Params[name].(string)
+	return s
+}
+
+// Returns the value of a bool parameter, false if unset
+func (c *ActionContext) Bool(name string) bool {
+	b, _ := c.Params[name].(bool)
+	return b
+}
+
+// Returns the value of a number parameter, 0 if unset
+func (c *ActionContext) Number(name string) float64 {
+	n, _ := c.Params[name].(float64)
+	return n
+}
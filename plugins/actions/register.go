@@ -1,10 +1,53 @@
 package actions
 
-// Simple helper function to register an action
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/plugins/acl"
+	"golang.org/x/exp/slices"
+)
+
+// Simple helper function to register an action. Panics if a action is not well defined
 func RegisterActions(actions ...*Action) {
+	for _, action := range actions {
+		err := action.validate()
+
+		if err != nil {
+			panic(err)
+		}
+
+		if _, ok := Actions.Find(action.Name); ok {
+			panic("action " + action.Name + " is already registered")
+		}
+	}
+
 	Actions = append(Actions, actions...)
 }
 
+// Returns whether a user may see and execute a action
+func (a *Action) allowed(userId string) bool {
+	if len(a.RequiredRoles) == 0 && len(a.AllowedUsers) == 0 {
+		return true
+	}
+
+	if slices.Contains(a.AllowedUsers, userId) {
+		return true
+	}
+
+	for _, role := range acl.RolesOf(userId) {
+		if slices.Contains(a.RequiredRoles, role) {
+			return true
+		}
+	}
+
+	return false
+}
+
 type actionList []*Action
 
 func (l actionList) Find(name string) (*Action, bool) {
@@ -18,3 +61,52 @@ func (l actionList) Find(name string) (*Action, bool) {
 }
 
 var Actions = actionList{}
+
+var (
+	// Guards shellActions as they are replaced on reload
+	shellLock    sync.RWMutex
+	shellActions = actionList{}
+)
+
+// Returns all actions registered by plugins followed by the shell actions
+func allActions() actionList {
+	shellLock.RLock()
+	defer shellLock.RUnlock()
+
+	list := make(actionList, 0, len(Actions)+len(shellActions))
+	list = append(list, Actions...)
+	list = append(list, shellActions...)
+
+	return list
+}
+
+// Executes a action on behalf of sysmanage itself (such as the scheduler) without checking
+// whether userId may execute it. userId is recorded as the owner of any task the action starts.
+//
+// As there is no client request, the action gets a request with only the user ID header set
+func Execute(name, userId string, raw map[string]any) (*ActionResponse, error) {
+	action, ok := allActions().Find(name)
+
+	if !ok {
+		return nil, errors.New("action " + name + " not found")
+	}
+
+	params, err := action.decodeParams(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(state.Context, http.MethodPost, "/api/"+ID+"/executeAction?actionName="+url.QueryEscape(name), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return action.Handler(&ActionContext{
+		Request: plugins.WithPrincipal(r, &plugins.Principal{ID: userId}),
+		Action:  action,
+		UserID:  userId,
+		Params:  params,
+	})
+}
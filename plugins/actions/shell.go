@@ -0,0 +1,335 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/plugins/deploy"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Loads all shell actions (*.yaml) in dir, replacing the previously loaded ones
+func loadShellActions(dir string) error {
+	fsd, err := os.ReadDir(dir)
+
+	if err != nil {
+		return errors.New("Failed to read actions data directory: " + err.Error())
+	}
+
+	list := actionList{}
+
+	for _, file := range fsd {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".yaml") {
+			continue
+		}
+
+		sa, err := readShellAction(filepath.Join(dir, file.Name()))
+
+		if err != nil {
+			return errors.New("Failed to load action " + file.Name() + ": " + err.Error())
+		}
+
+		action := sa.action()
+
+		err = action.validate()
+
+		if err != nil {
+			return errors.New("Failed to load action " + file.Name() + ": " + err.Error())
+		}
+
+		if _, ok := Actions.Find(action.Name); ok {
+			return errors.New("Failed to load action " + file.Name() + ": action " + action.Name + " is already registered by a plugin")
+		}
+
+		if _, ok := list.Find(action.Name); ok {
+			return errors.New("Failed to load action " + file.Name() + ": duplicate action " + action.Name)
+		}
+
+		list = append(list, action)
+	}
+
+	shellLock.Lock()
+	defer shellLock.Unlock()
+
+	shellActions = list
+
+	return nil
+}
+
+func readShellAction(path string) (*ShellAction, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var sa ShellAction
+
+	err = yaml.NewDecoder(f).Decode(&sa)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sa.Name == "" {
+		sa.Name = strings.TrimSuffix(filepath.Base(path), ".yaml")
+	}
+
+	err = state.Validator.Struct(sa)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sa.RunAs != "" {
+		// Fail early on unknown users instead of on every run
+		if _, err := lookupUser(sa.RunAs); err != nil {
+			return nil, err
+		}
+	}
+
+	return &sa, nil
+}
+
+// Looks up a user by name or uid
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+// Returns the credentials to run commands as a user
+func credentialOf(name string) (*syscall.Credential, error) {
+	u, err := lookupUser(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(gid),
+	}
+
+	groups, err := u.GroupIds()
+
+	if err == nil {
+		for _, g := range groups {
+			if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(gid))
+			}
+		}
+	}
+
+	return cred, nil
+}
+
+// Used when sysmanage itself has no PATH
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Returns the environment the commands start with. The environment of sysmanage may hold secrets (such
+// as ones loaded through ${ENV:...}), so unless inherit_env is set only PATH and the HOME, USER and
+// LOGNAME of the user the commands run as are set
+func (sa *ShellAction) baseEnv() []string {
+	if sa.InheritEnv {
+		return os.Environ()
+	}
+
+	path := os.Getenv("PATH")
+
+	if path == "" {
+		path = defaultPath
+	}
+
+	env := []string{"PATH=" + path}
+
+	var (
+		u   *user.User
+		err error
+	)
+
+	if sa.RunAs != "" {
+		u, err = lookupUser(sa.RunAs)
+	} else {
+		u, err = user.Current()
+	}
+
+	if err == nil {
+		env = append(env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	}
+
+	return env
+}
+
+// Returns the environment variable holding the value of a parameter, e.g. PARAM_DRY_RUN for dry-run
+func paramEnv(name string) string {
+	return "PARAM_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// Converts the shell action into a action
+func (sa *ShellAction) action() *Action {
+	return &Action{
+		Name:          sa.Name,
+		Description:   sa.Description,
+		ConfirmDialog: sa.ConfirmDialog,
+		Params:        sa.Params,
+		RequiredRoles: sa.RequiredRoles,
+		AllowedUsers:  sa.AllowedUsers,
+		Handler: func(c *ActionContext) (*ActionResponse, error) {
+			return c.StartTask(func(l *TaskLogger) error {
+				return sa.run(l, c.Params)
+			})
+		},
+	}
+}
+
+// Runs the commands of the shell action
+func (sa *ShellAction) run(l *TaskLogger, params map[string]any) error {
+	l.Logf("Running action %s", sa.Name)
+
+	var cred *syscall.Credential
+
+	if sa.RunAs != "" {
+		var err error
+		cred, err = credentialOf(sa.RunAs)
+
+		if err != nil {
+			return errors.New("Error looking up user " + sa.RunAs + ": " + err.Error())
+		}
+	}
+
+	// Only the user running the script may read it, /tmp is shared with everyone else
+	tmpDir, err := os.MkdirTemp("", "action-"+l.ID+"-")
+
+	if err != nil {
+		return errors.New("FATAL: could not create action folder: " + err.Error())
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	script := filepath.Join(tmpDir, "script")
+
+	// Create script
+	f, err := os.OpenFile(script, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0700)
+
+	if err != nil {
+		return errors.New("Error creating script: " + err.Error())
+	}
+
+	err = deploy.ScriptTemplate.Execute(f, sa.Commands)
+	f.Close()
+
+	if err != nil {
+		return errors.New("Error writing script: " + err.Error())
+	}
+
+	if cred != nil {
+		for _, p := range []string{tmpDir, script} {
+			err = os.Chown(p, int(cred.Uid), int(cred.Gid))
+
+			if err != nil {
+				return errors.New("Error giving " + sa.RunAs + " the script: " + err.Error())
+			}
+		}
+	}
+
+	l.Step("Running commands")
+
+	ctx := l.Context()
+
+	if sa.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(sa.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "bash", script)
+	cmd.Dir = tmpDir
+
+	if sa.WorkingDir != "" {
+		cmd.Dir = sa.WorkingDir
+	}
+
+	cmd.Env = sa.baseEnv()
+
+	for k, v := range sa.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	// Parameters are passed through the environment so they can never be interpreted as shell code
+	for name, v := range params {
+		var value string
+
+		switch v := v.(type) {
+		case string:
+			value = v
+		case bool:
+			value = strconv.FormatBool(v)
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+
+		cmd.Env = append(cmd.Env, paramEnv(name)+"="+value)
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+
+	cmd.SysProcAttr.Credential = cred
+
+	// Kill the whole process group on cancellation, not just bash
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	cmd.Stdout = l.Writer()
+	cmd.Stderr = l.ErrorWriter()
+
+	err = cmd.Run()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.New("Action timed out after " + strconv.Itoa(sa.Timeout) + " seconds")
+	}
+
+	if err != nil {
+		return errors.New("Error running command: " + err.Error())
+	}
+
+	return nil
+}
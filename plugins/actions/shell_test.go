@@ -0,0 +1,112 @@
+package actions
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"strings"
+	"testing"
+
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+)
+
+func TestShellActionRun(t *testing.T) {
+	old := logger.LogMap
+	logger.LogMap = logger.NewMemoryStore()
+
+	t.Cleanup(func() {
+		logger.LogMap = old
+	})
+
+	// Stands in for a secret loaded through ${ENV:...}
+	t.Setenv("SYSMANAGE_TEST_SECRET", "hunter2")
+
+	home := "unknown"
+
+	if u, err := user.Current(); err == nil {
+		home = u.HomeDir
+	}
+
+	nobodyHome := "unknown"
+
+	if u, err := user.Lookup("nobody"); err == nil {
+		nobodyHome = u.HomeDir
+	}
+
+	tests := []struct {
+		name   string
+		action ShellAction
+		params map[string]any
+		output []string // Lines the output must contain
+		root   bool     // Needs to run as root
+	}{
+		{
+			name: "commands are printed without being run",
+			action: ShellAction{Commands: []string{
+				`echo "$PARAM_TARGET"`,
+				`echo $(echo ran)`,
+			}},
+			params: map[string]any{"target": "$(echo injected)"},
+			output: []string{"> echo \"$PARAM_TARGET\"\n", "$(echo injected)\n", "> echo $(echo ran)\n", "ran\n"},
+		},
+		{
+			name: "private script",
+			action: ShellAction{Commands: []string{
+				`stat -c %a "$PWD" "$PWD/script"`,
+			}},
+			output: []string{"700\n"},
+		},
+		{
+			name: "run_as",
+			action: ShellAction{RunAs: "nobody", Commands: []string{
+				`id -un`,
+				`stat -c %U "$PWD/script"`,
+				`echo "home=$HOME user=$USER secret=${SYSMANAGE_TEST_SECRET:-unset}"`,
+			}},
+			output: []string{"nobody\n", "home=" + nobodyHome + " user=nobody secret=unset\n"},
+			root:   true,
+		},
+		{
+			name: "environment is not inherited",
+			action: ShellAction{Env: map[string]string{"SITE": "docs"}, Commands: []string{
+				`echo "secret=${SYSMANAGE_TEST_SECRET:-unset} site=$SITE home=$HOME param=$PARAM_DRY_RUN"`,
+				`command -v stat`,
+			}},
+			params: map[string]any{"dry-run": true},
+			output: []string{"secret=unset site=docs home=" + home + " param=true\n", "/stat\n"},
+		},
+		{
+			name: "inherit_env",
+			action: ShellAction{InheritEnv: true, Commands: []string{
+				`echo "secret=${SYSMANAGE_TEST_SECRET:-unset}"`,
+			}},
+			output: []string{"secret=hunter2\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.root && os.Geteuid() != 0 {
+				t.Skip("needs root")
+			}
+
+			l := &TaskLogger{ID: "test-" + strings.ReplaceAll(tt.name, " ", "-"), ctx: context.Background()}
+
+			tt.action.Name = tt.name
+
+			err := tt.action.run(l, tt.params)
+
+			out := strings.Join(logger.LogMap.Get(l.ID).LastLog, "")
+
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+
+			for _, line := range tt.output {
+				if !strings.Contains(out, line) {
+					t.Errorf("output does not contain %q:\n%s", line, out)
+				}
+			}
+		})
+	}
+}
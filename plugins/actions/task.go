@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+)
+
+// Writes to the log of the task started by ActionContext.StartTask
+type TaskLogger struct {
+	ID  string
+	ctx context.Context
+}
+
+// Adds a line to the log
+func (l *TaskLogger) Log(a ...any) {
+	logger.LogMap.Add(l.ID, fmt.Sprint(a...), true)
+}
+
+// Adds a formatted line to the log
+func (l *TaskLogger) Logf(format string, a ...any) {
+	logger.LogMap.Add(l.ID, fmt.Sprintf(format, a...), true)
+}
+
+// Starts a new step of the task
+func (l *TaskLogger) Step(name string) {
+	logger.LogMap.Step(l.ID, name)
+}
+
+// Returns a writer for the log, e.g. for the Stdout of a exec.Cmd
+func (l *TaskLogger) Writer() logger.AutoLogger {
+	return logger.AutoLogger{ID: l.ID}
+}
+
+// Same as Writer but marks every write as a error
+func (l *TaskLogger) ErrorWriter() logger.AutoLogger {
+	return logger.AutoLogger{ID: l.ID, Error: true}
+}
+
+// Returns the context of the task, this is cancelled when the task is cancelled
+func (l *TaskLogger) Context() context.Context {
+	return l.ctx
+}
+
+// Runs fn in the background as a task owned by the user executing the action. The returned
+// response has the task ID set so the frontend shows the output of the task
+func (c *ActionContext) StartTask(fn func(l *TaskLogger) error) (*ActionResponse, error) {
+	t, err := tasks.New(ID, "action:"+c.Action.Name, c.UserID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &TaskLogger{ID: t.ID, ctx: t.Context()}
+
+	go tasks.Run(t.ID, func(string) error {
+		return fn(l)
+	})
+
+	return &ActionResponse{
+		StatusCode: http.StatusOK,
+		Resp:       t.ID,
+		TaskID:     t.ID,
+	}, nil
+}
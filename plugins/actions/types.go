@@ -1,16 +1,62 @@
 package actions
 
-import "net/http"
+import (
+	"net/http"
+)
+
+type ParamType string
+
+const (
+	ParamString ParamType = "string"
+	ParamEnum   ParamType = "enum"
+	ParamBool   ParamType = "bool"
+	ParamNumber ParamType = "number"
+)
+
+// A parameter of a action, rendered as a form field by the frontend
+type Param struct {
+	Name        string    `yaml:"name" validate:"required"`
+	Label       string    `yaml:"label"` // Shown instead of the name if set
+	Description string    `yaml:"description"`
+	Type        ParamType `yaml:"type" validate:"required,oneof=string enum bool number"`
+	Required    bool      `yaml:"required"`
+	Default     any       `yaml:"default"` // Used when the parameter is not set, must match the type of the parameter
+
+	// String parameters only
+	Pattern   string `yaml:"pattern"` // Regex the value must match
+	MaxLength int    `yaml:"max_length"`
+
+	// Enum parameters only
+	Options []string `yaml:"options" validate:"required_if=Type enum"`
+
+	// Number parameters only
+	Min     *float64 `yaml:"min"`
+	Max     *float64 `yaml:"max"`
+	Integer bool     `yaml:"integer"`
+}
 
 type Action struct {
 	Name          string
 	Description   string
-	ConfirmDialog string                                        // If unset, no confirm dialog will be shown
-	Handler       func(*ActionContext) (*ActionResponse, error) `json:"-"`
+	ConfirmDialog string // If unset, no confirm dialog will be shown
+	Params        []Param
+
+	// If either of these are set, only the listed users and users with at least one of the
+	// listed roles (from the acl plugin) may see and execute the action
+	RequiredRoles []string
+	AllowedUsers  []string
+
+	Handler func(*ActionContext) (*ActionResponse, error) `json:"-"`
 }
 
 type ActionContext struct {
 	Request *http.Request
+	Action  *Action
+	UserID  string
+
+	// Decoded parameters with defaults applied. Values are string (string/enum),
+	// bool or float64 (number). Optional parameters without a default are not set
+	Params map[string]any
 }
 
 type ActionResponse struct {
@@ -18,3 +64,24 @@ type ActionResponse struct {
 	Resp       string
 	TaskID     string
 }
+
+// The actions section of config.yaml
+type Config struct {
+	DataDir string `yaml:"data_dir"` // Directory to load shell actions from, optional
+}
+
+// A action defined in a yaml file in the data directory that runs a list of shell commands
+type ShellAction struct {
+	Name          string            `yaml:"name"` // Defaults to the file name without the extension
+	Description   string            `yaml:"description"`
+	ConfirmDialog string            `yaml:"confirm_dialog"`
+	Commands      []string          `yaml:"commands" validate:"required,min=1"`
+	Env           map[string]string `yaml:"env"`
+	InheritEnv    bool              `yaml:"inherit_env"` // Pass on the environment of sysmanage, which may hold secrets. Otherwise only PATH, HOME, USER and LOGNAME are set
+	Timeout       int               `yaml:"timeout"`     // Seconds after which the commands are killed, no timeout if unset
+	WorkingDir    string            `yaml:"working_dir"` // Defaults to a temporary directory
+	RunAs         string            `yaml:"run_as"`      // User name or uid to run the commands as, defaults to the user running sysmanage
+	Params        []Param           `yaml:"params"`      // Passed to the commands as PARAM_<NAME> environment variables
+	RequiredRoles []string          `yaml:"required_roles"`
+	AllowedUsers  []string          `yaml:"allowed_users"`
+}
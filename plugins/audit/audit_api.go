@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/audit"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+)
+
+func loadAuditApi(r chi.Router) {
+	// Searching the audit log is not audited itself
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost, "/getAuditLog")
+
+	r.Post("/getAuditLog", func(w http.ResponseWriter, r *http.Request) {
+		var filter audit.Filter
+
+		err := json.NewDecoder(r.Body).Decode(&filter)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Failed to decode filter: " + err.Error()))
+			return
+		}
+
+		entries, err := audit.Query(filter)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to query audit log: " + err.Error()))
+			return
+		}
+
+		bytes, err := json.Marshal(entries)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to marshal audit log."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+}
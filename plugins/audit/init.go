@@ -0,0 +1,19 @@
+package audit
+
+import (
+	"errors"
+
+	"github.com/infinitybotlist/sysmanage-web/core/audit"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+const ID = "audit"
+
+func InitPlugin(c *types.PluginConfig) error {
+	if !audit.Enabled() {
+		return errors.New("audit plugin requires audit.dir to be set in config.yaml")
+	}
+
+	loadAuditApi(c.Mux)
+	return nil
+}
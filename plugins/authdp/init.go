@@ -2,6 +2,8 @@ package authdp
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/infinitybotlist/sysmanage-web/core/plugins"
 	"github.com/infinitybotlist/sysmanage-web/core/state"
@@ -10,38 +12,64 @@ import (
 
 const ID = "authdp"
 
+// The authdp section of config.yaml
+type Config struct {
+	DpSecret     string   `yaml:"dp_secret" validate:"required_unless=DpDisable true"`
+	DpDisable    bool     `yaml:"dp_disable"` // Trust the X-DP-* headers without checking their signature, only for bootstrapping
+	Url          string   `yaml:"url" validate:"required"`
+	AllowedUsers []string `yaml:"allowed_users"`                          // If empty, all users authenticated by deployproxy are allowed
+	MaxSkew      int      `yaml:"max_skew" default:"10" validate:"gte=1"` // Seconds X-DP-Timestamp may differ from the current time in either direction
+	// Require a signed X-DP-Nonce on every request so that replays are rejected. If disabled, the
+	// nonce is neither signed nor checked and captured requests can be replayed within max_skew.
+	// Off by default as deployproxy does not send nonces yet
+	RequireNonce bool `yaml:"require_nonce"`
+}
+
 var (
-	dpSecret     string
-	url          string
-	allowedUsers []string
+	// Guards cfg as it can change on reload
+	cfgLock sync.RWMutex
+
+	cfg *Config
 )
 
 var preloaded bool
 
-func InitPlugin(c *types.PluginConfig) error {
-	if !preloaded {
-		panic("authdp plugin must be preloaded")
-	}
+// Returns the current config
+func current() *Config {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+
+	return cfg
+}
 
-	cfgData, err := plugins.GetConfig(c.Name)
+// Loads the authdp section of config.yaml
+func loadConfig(name string) error {
+	newCfg, err := plugins.DecodeConfig[Config](name)
 
 	if err != nil {
-		return errors.New("Failed to get nginx config: " + err.Error())
+		return errors.New("Failed to get authdp config: " + err.Error())
 	}
 
-	dpSecret, err = cfgData.GetString("dp_secret")
-
-	if err != nil {
-		return err
+	if newCfg.DpDisable {
+		fmt.Println("WARNING: dp_disable is set, X-DP-* headers are trusted without checking their signature. Anyone who can reach sysmanage can log in as any user")
+	} else if !newCfg.RequireNonce {
+		fmt.Println("WARNING: require_nonce is disabled, requests signed by deployproxy can be replayed for up to max_skew seconds")
 	}
 
-	url, err = cfgData.GetString("url")
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
 
-	if err != nil {
-		return err
+	cfg = newCfg
+
+	return nil
+}
+
+func InitPlugin(c *types.PluginConfig) error {
+	if !preloaded {
+		panic("authdp plugin must be preloaded")
 	}
 
-	allowedUsers, err = cfgData.GetStringArray("allowed_users")
+	err := loadConfig(c.Name)
 
 	if err != nil {
 		return err
@@ -52,8 +80,12 @@ func InitPlugin(c *types.PluginConfig) error {
 	return nil
 }
 
+func Reload(c *types.PluginConfig) error {
+	return loadConfig(c.Name)
+}
+
 func Preload(c *types.PluginConfig) error {
-	c.RawMux.Use(DpAuthMiddleware)
+	plugins.RegisterAuthenticator(ID, dpAuthenticator{})
 	preloaded = true
 	return nil
 }
@@ -4,97 +4,157 @@ import (
 	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/infinitybotlist/sysmanage-web/core/plugins/constants"
-	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
 	"golang.org/x/exp/slices"
 )
 
-func DpAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Auth-exempt routes should be excluded
-		if slices.Contains(state.AuthExemptRoutes, r.URL.Path) {
-			next.ServeHTTP(w, r)
-			return
-		}
+// Nonces seen within the skew window, a nonce is rejected if it is seen again
+var nonces = struct {
+	sync.Mutex
+	seen      map[string]time.Time // Nonce to when it can be forgotten
+	lastPrune time.Time
+}{
+	seen: map[string]time.Time{},
+}
 
-		if r.Header.Get("X-DP-Host") == "" {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized. X-DP-Host header not found."))
-			return
-		}
+// Records a nonce, returning false if it has already been used. Nonces are kept until their
+// timestamp falls out of the skew window, after which the timestamp check rejects them anyway
+func useNonce(nonce string, ts time.Time, maxSkew time.Duration) bool {
+	nonces.Lock()
+	defer nonces.Unlock()
 
-		if r.Header.Get("X-DP-Host") != url {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized. Domain rebind detected. Expected " + url + " but got " + r.Header.Get("X-DP-Host")))
-			return
-		}
+	now := time.Now()
 
-		if r.Header.Get("X-DP-UserID") == "" {
-			// User is not authenticated
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized. Not running under deployproxy?"))
-			return
+	if now.Sub(nonces.lastPrune) > maxSkew {
+		for n, exp := range nonces.seen {
+			if now.After(exp) {
+				delete(nonces.seen, n)
+			}
 		}
 
-		// User is allowed, set constants.UserIdHeader to user id for other plugins to use it
-		r.Header.Set(constants.UserIdHeader, r.Header.Get("X-DP-UserID"))
+		nonces.lastPrune = now
+	}
 
-		// Check if user is allowed
-		if len(allowedUsers) > 0 && !slices.Contains(allowedUsers, r.Header.Get(constants.UserIdHeader)) {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized. User not allowed to access this site."))
-			return
-		}
+	if _, ok := nonces.seen[nonce]; ok {
+		return false
+	}
 
-		// User is possibly allowed
-		if r.Header.Get("X-DP-Signature") == "" {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized. X-DP-Signature header not found."))
-			return
-		}
+	nonces.seen[nonce] = ts.Add(maxSkew)
 
-		// Check for X-DP-Timestamp
-		if r.Header.Get("X-DP-Timestamp") == "" {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized. X-DP-Timestamp header not found."))
-			return
-		}
+	return true
+}
 
-		ts := r.Header.Get("X-DP-Timestamp")
+// Returns the expected X-DP-Signature (before hex encoding), the HMAC-SHA512 of the timestamp, user id and nonce.
+//
+// With a nonce every field is prefixed with its length and a colon ("10:1700000000" etc.), as otherwise
+// characters could be moved between the user id and nonce without changing the signature. Without
+// require_nonce the nonce is empty and the timestamp and user id are signed concatenated, which is the
+// signature deployproxy always sent. Moving digits between those changes the timestamp tenfold, which
+// max_skew rejects
+func signature(secret, ts, userId, nonce string) []byte {
+	h := hmac.New(sha512.New, []byte(secret))
+
+	if nonce == "" {
+		h.Write([]byte(ts + userId))
+		return h.Sum(nil)
+	}
+
+	for _, field := range []string{ts, userId, nonce} {
+		h.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+
+	return h.Sum(nil)
+}
 
-		// Validate DP-Secret next
-		if dpSecret != "" {
-			h := hmac.New(sha512.New, []byte(dpSecret))
-			h.Write([]byte(ts))
-			h.Write([]byte(r.Header.Get("X-DP-UserID")))
-			hexed := hex.EncodeToString(h.Sum(nil))
+// Authenticates requests using the X-DP-* headers set by deployproxy
+type dpAuthenticator struct{}
 
-			if r.Header.Get("X-DP-Signature") != hexed {
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte("Unauthorized. Signature from deployproxy mismatch"))
-				return
-			}
+func (dpAuthenticator) Authenticate(r *http.Request) (*plugins.Principal, error) {
+	cfg := current()
+
+	userId := r.Header.Get("X-DP-UserID")
+
+	if r.Header.Get("X-DP-Host") == "" && userId == "" {
+		// Not running under deployproxy, leave the request to the other auth plugins
+		return nil, nil
+	}
+
+	if r.Header.Get("X-DP-Host") != cfg.Url {
+		return nil, errors.New("Domain rebind detected. Expected " + cfg.Url + " but got " + r.Header.Get("X-DP-Host"))
+	}
+
+	if userId == "" {
+		return nil, errors.New("X-DP-UserID header not found. Not running under deployproxy?")
+	}
+
+	// Check for X-DP-Timestamp
+	ts := r.Header.Get("X-DP-Timestamp")
+
+	if ts == "" {
+		return nil, errors.New("X-DP-Timestamp header not found")
+	}
+
+	// Check if timestamp is valid
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+
+	if err != nil {
+		return nil, errors.New("X-DP-Timestamp is not a valid integer")
+	}
+
+	maxSkew := time.Duration(cfg.MaxSkew) * time.Second
+	tsTime := time.Unix(timestamp, 0)
+	skew := time.Since(tsTime)
+
+	if skew > maxSkew {
+		return nil, errors.New("X-DP-Timestamp is too old")
+	}
+
+	if skew < -maxSkew {
+		return nil, errors.New("X-DP-Timestamp is in the future")
+	}
+
+	// Nonces are pointless if nothing is signed
+	checkNonce := cfg.RequireNonce && !cfg.DpDisable
+
+	var nonce string
+
+	if checkNonce {
+		nonce = r.Header.Get("X-DP-Nonce")
+
+		if nonce == "" {
+			return nil, errors.New("X-DP-Nonce header not found")
 		}
+	}
 
-		// Check if timestamp is valid
-		timestamp, err := strconv.ParseInt(ts, 10, 64)
+	// Validate DP-Secret next
+	if !cfg.DpDisable {
+		sig, err := hex.DecodeString(r.Header.Get("X-DP-Signature"))
 
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized. X-DP-Timestamp is not a valid integer."))
-			return
+		if err != nil || len(sig) == 0 {
+			return nil, errors.New("X-DP-Signature header not found or invalid")
 		}
 
-		if time.Now().Unix()-timestamp > 10 {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized. X-DP-Timestamp is too old."))
-			return
+		if !hmac.Equal(sig, signature(cfg.DpSecret, ts, userId, nonce)) {
+			return nil, errors.New("Signature from deployproxy mismatch")
 		}
+	}
+
+	// Reject replays, this is only possible with nonces as deployproxy signs nothing else
+	// that differs between requests of a user within the same second
+	if checkNonce && !useNonce(nonce, tsTime, maxSkew) {
+		return nil, errors.New("X-DP-Nonce has already been used")
+	}
+
+	// Check if user is allowed
+	if len(cfg.AllowedUsers) > 0 && !slices.Contains(cfg.AllowedUsers, userId) {
+		return nil, errors.New("User not allowed to access this site")
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	return &plugins.Principal{ID: userId}, nil
 }
@@ -0,0 +1,223 @@
+package authdp
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret = "secret"
+	testUrl    = "https://sysmanage.example.com"
+)
+
+// A request as deployproxy would send it
+type dpRequest struct {
+	host      string
+	userId    string
+	ts        time.Time
+	nonce     string
+	signNonce string // Nonce included in the signature, defaults to nonce
+	signUser  string // User id included in the signature, defaults to userId
+	legacySig bool   // Sign without a nonce, as deployproxy versions without nonces do
+	secret    string
+	noSig     bool
+}
+
+func (d dpRequest) build() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/systemd/getServiceList", nil)
+
+	if d.host == "" {
+		d.host = testUrl
+	}
+
+	if d.secret == "" {
+		d.secret = testSecret
+	}
+
+	if d.ts.IsZero() {
+		d.ts = time.Now()
+	}
+
+	if d.signNonce == "" && !d.legacySig {
+		d.signNonce = d.nonce
+	}
+
+	if d.signUser == "" {
+		d.signUser = d.userId
+	}
+
+	ts := strconv.FormatInt(d.ts.Unix(), 10)
+
+	r.Header.Set("X-DP-Host", d.host)
+	r.Header.Set("X-DP-UserID", d.userId)
+	r.Header.Set("X-DP-Timestamp", ts)
+
+	if d.nonce != "" {
+		r.Header.Set("X-DP-Nonce", d.nonce)
+	}
+
+	if !d.noSig {
+		r.Header.Set("X-DP-Signature", hex.EncodeToString(signature(d.secret, ts, d.signUser, d.signNonce)))
+	}
+
+	return r
+}
+
+func setConfig(t *testing.T, c Config) {
+	c.Url = testUrl
+	c.MaxSkew = 10
+
+	if !c.DpDisable {
+		c.DpSecret = testSecret
+	}
+
+	cfgLock.Lock()
+	cfg = &c
+	cfgLock.Unlock()
+
+	nonces.Lock()
+	nonces.seen = map[string]time.Time{}
+	nonces.Unlock()
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		reqs []dpRequest // Sent in order, all but the last must be accepted
+		err  string      // Expected error of the last request, empty if it should be accepted
+	}{
+		{
+			name: "valid",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1"}},
+		},
+		{
+			name: "distinct nonces",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1"}, {userId: "alice", nonce: "n2"}},
+		},
+		{
+			name: "replayed nonce",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1"}, {userId: "alice", nonce: "n1"}},
+			err:  "already been used",
+		},
+		{
+			name: "missing nonce",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice"}},
+			err:  "X-DP-Nonce header not found",
+		},
+		{
+			name: "unsigned nonce",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1", signNonce: "n2"}},
+			err:  "mismatch",
+		},
+		{
+			name: "characters moved from the nonce to the user id",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1", signUser: "alic", signNonce: "en1"}},
+			err:  "mismatch",
+		},
+		{
+			name: "characters moved from the user id to the nonce",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alic", nonce: "en1", signUser: "alice", signNonce: "n1"}},
+			err:  "mismatch",
+		},
+		{
+			name: "wrong secret",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1", secret: "other"}},
+			err:  "mismatch",
+		},
+		{
+			name: "missing signature",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1", noSig: true}},
+			err:  "X-DP-Signature",
+		},
+		{
+			name: "old timestamp",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1", ts: time.Now().Add(-time.Minute)}},
+			err:  "too old",
+		},
+		{
+			name: "future timestamp",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1", ts: time.Now().Add(time.Minute)}},
+			err:  "in the future",
+		},
+		{
+			name: "domain rebind",
+			cfg:  Config{RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1", host: "https://evil.example.com"}},
+			err:  "Domain rebind",
+		},
+		{
+			name: "user not allowed",
+			cfg:  Config{RequireNonce: true, AllowedUsers: []string{"bob"}},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1"}},
+			err:  "not allowed",
+		},
+		{
+			name: "legacy signature without nonces",
+			cfg:  Config{},
+			reqs: []dpRequest{{userId: "alice"}, {userId: "alice"}},
+		},
+		{
+			name: "nonce is not signed without require_nonce",
+			cfg:  Config{},
+			reqs: []dpRequest{{userId: "alice", nonce: "n1", legacySig: true}},
+		},
+		{
+			name: "dp_disable skips signatures and nonces",
+			cfg:  Config{DpDisable: true, RequireNonce: true},
+			reqs: []dpRequest{{userId: "alice", noSig: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, tt.cfg)
+
+			for i, req := range tt.reqs {
+				p, err := dpAuthenticator{}.Authenticate(req.build())
+
+				if i < len(tt.reqs)-1 || tt.err == "" {
+					if err != nil {
+						t.Fatalf("request %d rejected: %v", i, err)
+					}
+
+					if p == nil || p.ID != req.userId {
+						t.Fatalf("request %d: got principal %+v, want %s", i, p, req.userId)
+					}
+
+					continue
+				}
+
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithoutDeployproxy(t *testing.T) {
+	setConfig(t, Config{RequireNonce: true})
+
+	p, err := dpAuthenticator{}.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if p != nil || err != nil {
+		t.Fatalf("got %+v, %v, want the request to be left to other auth plugins", p, err)
+	}
+}
@@ -0,0 +1,157 @@
+// Authenticates users through a OpenID Connect provider using the authorization code flow
+package authoidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/types"
+	"golang.org/x/exp/slices"
+)
+
+const ID = "authoidc"
+
+// The authoidc section of config.yaml
+type Config struct {
+	Issuer        string   `yaml:"issuer" validate:"required,url"`
+	ClientID      string   `yaml:"client_id" validate:"required"`
+	ClientSecret  string   `yaml:"client_secret"`                             // Not needed for public clients, PKCE is always used
+	RedirectURL   string   `yaml:"redirect_url" validate:"required,url"`      // Must point to /api/authoidc/callback
+	Scopes        []string `yaml:"scopes" default:"[openid, profile, email]"` // openid is always requested
+	UserClaim     string   `yaml:"user_claim" default:"sub"`                  // Claim used as the user id
+	NameClaim     string   `yaml:"name_claim" default:"name"`                 // Claim used as the display name of the user, optional
+	GroupsClaim   string   `yaml:"groups_claim" default:"groups"`             // Claim holding the groups of the user, optional
+	AllowedUsers  []string `yaml:"allowed_users"`                             // If both are empty, all users of the provider are allowed
+	AllowedGroups []string `yaml:"allowed_groups"`
+	SessionSecret string   `yaml:"session_secret" validate:"required,min=32"` // Key used to sign session cookies
+	SessionTTL    int      `yaml:"session_ttl" default:"28800" validate:"gte=60"`
+	CookieName    string   `yaml:"cookie_name" default:"sysmanage_session"`
+}
+
+var (
+	// Guards cfg and prov as they can change on reload
+	cfgLock sync.RWMutex
+
+	cfg  *Config
+	prov *provider
+)
+
+var preloaded bool
+
+// Returns the current config and provider
+func current() (*Config, *provider) {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+
+	return cfg, prov
+}
+
+// Returns whether a user may log in
+func (c *Config) allowed(userId string, groups []string) bool {
+	if len(c.AllowedUsers) == 0 && len(c.AllowedGroups) == 0 {
+		return true
+	}
+
+	if slices.Contains(c.AllowedUsers, userId) {
+		return true
+	}
+
+	for _, g := range groups {
+		if slices.Contains(c.AllowedGroups, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Loads the authoidc section of config.yaml
+func loadConfig(name string) error {
+	newCfg, err := plugins.DecodeConfig[Config](name)
+
+	if err != nil {
+		return errors.New("Failed to get authoidc config: " + err.Error())
+	}
+
+	if !slices.Contains(newCfg.Scopes, "openid") {
+		newCfg.Scopes = append([]string{"openid"}, newCfg.Scopes...)
+	}
+
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
+
+	// Keep the cached metadata and keys unless the issuer changed
+	if prov == nil || prov.issuer != newCfg.Issuer {
+		prov = &provider{issuer: newCfg.Issuer}
+	}
+
+	cfg = newCfg
+
+	return nil
+}
+
+// Fetches the provider metadata in the background so misconfigurations show up on startup
+// instead of on the first login
+func checkProvider() {
+	go func() {
+		_, p := current()
+
+		ctx, cancel := context.WithTimeout(state.Context, 30*time.Second)
+		defer cancel()
+
+		_, err := p.discover(ctx)
+
+		if err != nil {
+			fmt.Println("WARNING: authoidc: " + err.Error())
+		}
+	}()
+}
+
+func InitPlugin(c *types.PluginConfig) error {
+	if !preloaded {
+		panic("authoidc plugin must be preloaded")
+	}
+
+	err := loadConfig(c.Name)
+
+	if err != nil {
+		return err
+	}
+
+	checkProvider()
+
+	for _, route := range []string{"/login", "/callback", "/logout"} {
+		plugins.AddExemptRoute(ID, http.MethodGet, route)
+	}
+
+	loadOidcApi(c.Mux)
+
+	state.AuthPlugins = append(state.AuthPlugins, ID)
+
+	return nil
+}
+
+// Applies a new config. Changing session_secret or cookie_name logs out all users
+func Reload(c *types.PluginConfig) error {
+	err := loadConfig(c.Name)
+
+	if err != nil {
+		return err
+	}
+
+	checkProvider()
+
+	return nil
+}
+
+func Preload(c *types.PluginConfig) error {
+	plugins.RegisterAuthenticator(ID, oidcAuthenticator{})
+	preloaded = true
+	return nil
+}
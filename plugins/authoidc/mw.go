@@ -0,0 +1,45 @@
+package authoidc
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+)
+
+// Authenticates requests using the session cookie set on login
+type oidcAuthenticator struct{}
+
+func (oidcAuthenticator) Authenticate(r *http.Request) (*plugins.Principal, error) {
+	cfg, _ := current()
+
+	sess, err := readSession(r, cfg)
+
+	if err != nil {
+		// No or expired session, the user is sent to the login page by Challenge
+		return nil, nil
+	}
+
+	// The allowed users may have changed since the user logged in
+	if !cfg.allowed(sess.UserID, sess.Groups) {
+		return nil, errors.New("User not allowed to access this site")
+	}
+
+	return &plugins.Principal{
+		ID:          sess.UserID,
+		DisplayName: sess.Name,
+		Groups:      sess.Groups,
+	}, nil
+}
+
+// Sends users opening a page to the login page, API clients get a error instead
+func (oidcAuthenticator) Challenge(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet || strings.HasPrefix(r.URL.Path, "/api/") {
+		return false
+	}
+
+	http.Redirect(w, r, "/api/"+ID+"/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	return true
+}
@@ -0,0 +1,255 @@
+package authoidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Returns redirect if it is a path on this site, otherwise /. This stops the login
+// page from being used to send users to other sites
+func safeRedirect(redirect string) string {
+	// Browsers strip tabs and newlines and treat backslashes as slashes, so "/\t/evil.com" goes to //evil.com
+	for _, c := range redirect {
+		if c < 0x20 || c == 0x7f || c == '\\' {
+			return "/"
+		}
+	}
+
+	u, err := url.Parse(redirect)
+
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		return "/"
+	}
+
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") {
+		return "/"
+	}
+
+	return redirect
+}
+
+// Returns the claim as a list of strings, accepting both a single string and a list
+func stringsClaim(claims map[string]any, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var list []string
+
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				list = append(list, s)
+			}
+		}
+
+		return list
+	}
+
+	return nil
+}
+
+func loadOidcApi(r chi.Router) {
+	r.Get("/login", func(w http.ResponseWriter, r *http.Request) {
+		cfg, p := current()
+
+		meta, err := p.discover(r.Context())
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		var ls = loginState{
+			Redirect: safeRedirect(r.URL.Query().Get("redirect")),
+			Expiry:   time.Now().Add(loginTTL).Unix(),
+		}
+
+		for _, v := range []*string{&ls.State, &ls.Nonce, &ls.Verifier} {
+			*v, err = randomString(32)
+
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Failed to generate login state: " + err.Error()))
+				return
+			}
+		}
+
+		value, err := sign(cookieKey(cfg.SessionSecret, "login"), ls)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to sign login state: " + err.Error()))
+			return
+		}
+
+		setCookie(w, cfg, loginCookie, value, loginTTL)
+
+		challenge := sha256.Sum256([]byte(ls.Verifier))
+
+		q := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {cfg.ClientID},
+			"redirect_uri":          {cfg.RedirectURL},
+			"scope":                 {strings.Join(cfg.Scopes, " ")},
+			"state":                 {ls.State},
+			"nonce":                 {ls.Nonce},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+
+		authUrl := meta.AuthorizationEndpoint
+
+		if strings.Contains(authUrl, "?") {
+			authUrl += "&" + q.Encode()
+		} else {
+			authUrl += "?" + q.Encode()
+		}
+
+		http.Redirect(w, r, authUrl, http.StatusFound)
+	})
+
+	r.Get("/callback", func(w http.ResponseWriter, r *http.Request) {
+		cfg, p := current()
+
+		c, err := r.Cookie(loginCookie)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("No login in progress, please login again"))
+			return
+		}
+
+		clearCookie(w, cfg, loginCookie)
+
+		var ls loginState
+
+		err = verify(cookieKey(cfg.SessionSecret, "login"), c.Value, &ls)
+
+		if err != nil || time.Now().Unix() > ls.Expiry {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Login expired, please login again"))
+			return
+		}
+
+		if r.URL.Query().Get("state") != ls.State {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Login state mismatch, please login again"))
+			return
+		}
+
+		if e := r.URL.Query().Get("error"); e != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Login failed: " + e + ": " + r.URL.Query().Get("error_description")))
+			return
+		}
+
+		code := r.URL.Query().Get("code")
+
+		if code == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing code"))
+			return
+		}
+
+		tr, err := p.exchange(r.Context(), cfg, code, ls.Verifier)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		claims, err := p.verifyIDToken(r.Context(), cfg, tr.IDToken, ls.Nonce)
+
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Invalid id token: " + err.Error()))
+			return
+		}
+
+		var userId string
+
+		switch v := claims[cfg.UserClaim].(type) {
+		case string:
+			userId = v
+		case fmt.Stringer:
+			// Numeric ids
+			userId = v.String()
+		}
+
+		if userId == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("id token has no " + cfg.UserClaim + " claim"))
+			return
+		}
+
+		name, _ := claims[cfg.NameClaim].(string)
+
+		var groups []string
+
+		if cfg.GroupsClaim != "" {
+			groups = stringsClaim(claims, cfg.GroupsClaim)
+		}
+
+		if !cfg.allowed(userId, groups) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("User " + userId + " is not allowed to access this site."))
+			return
+		}
+
+		ttl := time.Duration(cfg.SessionTTL) * time.Second
+
+		value, err := sign(cookieKey(cfg.SessionSecret, "session"), session{
+			UserID: userId,
+			Name:   name,
+			Groups: groups,
+			Expiry: time.Now().Add(ttl).Unix(),
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to sign session: " + err.Error()))
+			return
+		}
+
+		setCookie(w, cfg, cfg.CookieName, value, ttl)
+
+		http.Redirect(w, r, ls.Redirect, http.StatusFound)
+	})
+
+	r.Get("/logout", func(w http.ResponseWriter, r *http.Request) {
+		cfg, p := current()
+
+		clearCookie(w, cfg, cfg.CookieName)
+
+		// Also log out at the provider if it supports it
+		meta, err := p.discover(r.Context())
+
+		if err != nil || meta.EndSessionEndpoint == "" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		q := url.Values{
+			"client_id": {cfg.ClientID},
+		}
+
+		logoutUrl := meta.EndSessionEndpoint
+
+		if strings.Contains(logoutUrl, "?") {
+			logoutUrl += "&" + q.Encode()
+		} else {
+			logoutUrl += "?" + q.Encode()
+		}
+
+		http.Redirect(w, r, logoutUrl, http.StatusFound)
+	})
+}
@@ -0,0 +1,549 @@
+package authoidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+const (
+	// How long the discovery document is cached for
+	discoveryTTL = time.Hour
+
+	// Unknown key IDs cause the JWKS to be refetched, but at most this often
+	jwksMinRefresh = time.Minute
+
+	// Allowed clock skew between sysmanage and the provider when checking exp and iat
+	clockSkew = time.Minute
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Supported ID token signing algorithms
+var supportedAlgs = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+}
+
+// The parts of the OpenID provider metadata (/.well-known/openid-configuration) sysmanage uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"` // Optional
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	AccessToken      string `json:"access_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// A OpenID provider, caching its metadata and signing keys
+type provider struct {
+	issuer string
+
+	// Serialises fetches from the provider, mu is never held while fetching so that
+	// cached metadata and keys can be used while a fetch is in progress
+	fetchMu sync.Mutex
+
+	mu            sync.Mutex
+	meta          *discovery
+	metaFetchedAt time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+func getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(url + " returned status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Returns the cached provider metadata, nil if it has expired
+func (p *provider) cachedMeta() *discovery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil && time.Since(p.metaFetchedAt) < discoveryTTL {
+		return p.meta
+	}
+
+	return nil
+}
+
+// Returns the provider metadata, fetching it if it is not cached
+func (p *provider) discover(ctx context.Context) (*discovery, error) {
+	if meta := p.cachedMeta(); meta != nil {
+		return meta, nil
+	}
+
+	p.fetchMu.Lock()
+	defer p.fetchMu.Unlock()
+
+	// Another request may have fetched it while this one waited
+	if meta := p.cachedMeta(); meta != nil {
+		return meta, nil
+	}
+
+	var meta discovery
+
+	err := getJSON(ctx, strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", &meta)
+
+	if err != nil {
+		return nil, errors.New("Failed to fetch provider metadata: " + err.Error())
+	}
+
+	if meta.Issuer != p.issuer {
+		return nil, errors.New("provider metadata is for issuer " + meta.Issuer + ", expected " + p.issuer)
+	}
+
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JwksURI == "" {
+		return nil, errors.New("provider metadata is missing the authorization, token or jwks endpoint")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.meta = &meta
+	p.metaFetchedAt = time.Now()
+
+	return p.meta, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Converts a JWK to a public key, returns nil for unsupported keys
+func (k jwk) publicKey() crypto.PublicKey {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+
+		if err != nil {
+			return nil
+		}
+
+		e, err := decodeBigInt(k.E)
+
+		if err != nil || !e.IsInt64() {
+			return nil
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil
+		}
+
+		x, err := decodeBigInt(k.X)
+
+		if err != nil {
+			return nil
+		}
+
+		y, err := decodeBigInt(k.Y)
+
+		if err != nil {
+			return nil
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	}
+
+	return nil
+}
+
+// Returns the cached signing key with the given ID and whether the JWKS may be refetched
+func (p *provider) cachedKey(kid string) (key crypto.PublicKey, refetch bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.keys[kid], time.Since(p.keysFetchedAt) >= jwksMinRefresh
+}
+
+// Returns the signing key with the given ID, refetching the JWKS if the key is unknown
+func (p *provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	meta, err := p.discover(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key, _ := p.cachedKey(kid); key != nil {
+		return key, nil
+	}
+
+	p.fetchMu.Lock()
+	defer p.fetchMu.Unlock()
+
+	// Another request may have refetched the keys while this one waited
+	key, refetch := p.cachedKey(kid)
+
+	if key != nil {
+		return key, nil
+	}
+
+	if !refetch {
+		return nil, errors.New("unknown signing key " + kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err = getJSON(ctx, meta.JwksURI, &set)
+
+	if err != nil {
+		return nil, errors.New("Failed to fetch signing keys: " + err.Error())
+	}
+
+	keys := map[string]crypto.PublicKey{}
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		if key := k.publicKey(); key != nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	key, ok := p.keys[kid]
+
+	if !ok {
+		return nil, errors.New("unknown signing key " + kid)
+	}
+
+	return key, nil
+}
+
+// Exchanges a authorization code for tokens
+func (p *provider) exchange(ctx context.Context, cfg *Config, code, verifier string) (*tokenResponse, error) {
+	meta, err := p.discover(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {cfg.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {cfg.ClientID},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	resp, err := httpClient.Do(req)
+
+	if err != nil {
+		return nil, errors.New("Failed to exchange code: " + err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr)
+
+	if err != nil {
+		return nil, errors.New("Failed to decode token response: " + err.Error())
+	}
+
+	if tr.Error != "" {
+		return nil, errors.New("token endpoint returned " + tr.Error + ": " + tr.ErrorDescription)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("token endpoint returned status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	if tr.IDToken == "" {
+		return nil, errors.New("token response has no id_token, is the openid scope set?")
+	}
+
+	return &tr, nil
+}
+
+// Verifies the signature of a JWT with the given key and algorithm
+func verifySignature(key crypto.PublicKey, alg string, signed, sig []byte) error {
+	var hash crypto.Hash
+
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return errors.New("unsupported algorithm " + alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+
+		if !ok {
+			return errors.New("key is not a RSA key")
+		}
+
+		if alg[:2] == "PS" {
+			return rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+
+		if !ok {
+			return errors.New("key is not a EC key")
+		}
+
+		size := (pub.Curve.Params().BitSize + 7) / 8
+
+		if len(sig) != 2*size {
+			return errors.New("invalid signature length")
+		}
+
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	}
+
+	return errors.New("unsupported algorithm " + alg)
+}
+
+// Returns the claim as a unix timestamp
+func numericDate(claims map[string]any, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+
+	if !ok {
+		return time.Time{}, false
+	}
+
+	f, err := n.Float64()
+
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(f), 0), true
+}
+
+// Verifies a ID token and returns its claims
+func (p *provider) verifyIDToken(ctx context.Context, cfg *Config, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+
+	if len(parts) != 3 {
+		return nil, errors.New("id token is not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil {
+		return nil, errors.New("invalid id token header: " + err.Error())
+	}
+
+	err = json.Unmarshal(headerJson, &header)
+
+	if err != nil {
+		return nil, errors.New("invalid id token header: " + err.Error())
+	}
+
+	// Only asymmetric algorithms, this also rejects "none"
+	if !supportedAlgs[header.Alg] {
+		return nil, errors.New("unsupported id token algorithm " + header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, errors.New("invalid id token signature: " + err.Error())
+	}
+
+	key, err := p.key(ctx, header.Kid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifySignature(key, header.Alg, []byte(parts[0]+"."+parts[1]), sig)
+
+	if err != nil {
+		return nil, errors.New("id token signature is invalid: " + err.Error())
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return nil, errors.New("invalid id token payload: " + err.Error())
+	}
+
+	var claims map[string]any
+
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+
+	err = dec.Decode(&claims)
+
+	if err != nil {
+		return nil, errors.New("invalid id token payload: " + err.Error())
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return nil, errors.New("id token was issued by " + iss + ", expected " + p.issuer)
+	}
+
+	var audiences []string
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+
+	var found bool
+
+	for _, aud := range audiences {
+		if aud == cfg.ClientID {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return nil, errors.New("id token is not meant for this client")
+	}
+
+	if azp, ok := claims["azp"].(string); ok && azp != cfg.ClientID {
+		return nil, errors.New("id token was issued to another client")
+	}
+
+	exp, ok := numericDate(claims, "exp")
+
+	if !ok {
+		return nil, errors.New("id token has no expiry")
+	}
+
+	if time.Now().After(exp.Add(clockSkew)) {
+		return nil, errors.New("id token has expired")
+	}
+
+	if iat, ok := numericDate(claims, "iat"); ok && iat.After(time.Now().Add(clockSkew)) {
+		return nil, errors.New("id token was issued in the future")
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	return claims, nil
+}
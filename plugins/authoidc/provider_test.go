@@ -0,0 +1,493 @@
+package authoidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const testClientID = "sysmanage"
+
+// A stand-in OpenID provider
+type testProvider struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+
+	mu          sync.Mutex
+	logins      map[string]url.Values // Code to the query of the authorization request
+	blockJwks   chan struct{}         // If set, JWKS requests wait until it is closed
+	jwksFetches int
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := &testProvider{key: key, logins: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                tp.URL,
+			AuthorizationEndpoint: tp.URL + "/authorize",
+			TokenEndpoint:         tp.URL + "/token",
+			JwksURI:               tp.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		tp.mu.Lock()
+		tp.jwksFetches++
+		block := tp.blockJwks
+		tp.mu.Unlock()
+
+		if block != nil {
+			<-block
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []jwk{{
+				Kty: "RSA",
+				Kid: "k1",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	// Logs in every user as alice
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		tp.mu.Lock()
+		tp.logins["code-1"] = q
+		tp.mu.Unlock()
+
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code=code-1&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		tp.mu.Lock()
+		login, ok := tp.logins[r.Form.Get("code")]
+		delete(tp.logins, r.Form.Get("code"))
+		tp.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+
+		if !ok || login.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(tokenResponse{
+			IDToken: tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{
+				"nonce": login.Get("nonce"),
+				"name":  "Alice",
+			})),
+		})
+	})
+
+	tp.Server = httptest.NewServer(mux)
+
+	t.Cleanup(tp.Close)
+
+	return tp
+}
+
+// Returns valid claims for alice, overridden by the given claims
+func (tp *testProvider) claims(override map[string]any) map[string]any {
+	claims := map[string]any{
+		"iss": tp.URL,
+		"sub": "alice",
+		"aud": testClientID,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+
+	for k, v := range override {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+
+		claims[k] = v
+	}
+
+	return claims
+}
+
+func (tp *testProvider) sign(t *testing.T, key *rsa.PrivateKey, alg, kid string, claims map[string]any) string {
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	signed := enc(map[string]string{"alg": alg, "kid": kid}) + "." + enc(claims)
+
+	digest := sha256.Sum256([]byte(signed))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func testConfig(tp *testProvider) *Config {
+	return &Config{
+		Issuer:        tp.URL,
+		ClientID:      testClientID,
+		RedirectURL:   "http://sysmanage.test/api/authoidc/callback",
+		Scopes:        []string{"openid"},
+		UserClaim:     "sub",
+		NameClaim:     "name",
+		SessionSecret: strings.Repeat("s", 32),
+		SessionTTL:    3600,
+		CookieName:    "sysmanage_session",
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	tp := newTestProvider(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := tp.claims(map[string]any{"nonce": "n1"})
+
+	tests := []struct {
+		name  string
+		token func() string
+		err   string
+	}{
+		{
+			name:  "valid",
+			token: func() string { return tp.sign(t, tp.key, "RS256", "k1", valid) },
+		},
+		{
+			name: "audience list",
+			token: func() string {
+				return tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n1", "aud": []string{"other", testClientID}}))
+			},
+		},
+		{
+			name:  "bad signature",
+			token: func() string { return tp.sign(t, otherKey, "RS256", "k1", valid) },
+			err:   "signature is invalid",
+		},
+		{
+			name: "tampered payload",
+			token: func() string {
+				parts := strings.Split(tp.sign(t, tp.key, "RS256", "k1", valid), ".")
+				forged := strings.Split(tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n1", "sub": "mallory"})), ".")
+				return parts[0] + "." + forged[1] + "." + parts[2]
+			},
+			err: "signature is invalid",
+		},
+		{
+			name: "alg none",
+			token: func() string {
+				parts := strings.Split(tp.sign(t, tp.key, "RS256", "k1", valid), ".")
+				header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"k1"}`))
+				return header + "." + parts[1] + "."
+			},
+			err: "unsupported id token algorithm",
+		},
+		{
+			name:  "unknown key",
+			token: func() string { return tp.sign(t, tp.key, "RS256", "k2", valid) },
+			err:   "unknown signing key",
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				return tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n1", "aud": "other"}))
+			},
+			err: "not meant for this client",
+		},
+		{
+			name: "wrong authorized party",
+			token: func() string {
+				return tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n1", "azp": "other"}))
+			},
+			err: "issued to another client",
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				return tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n1", "iss": "https://evil.example.com"}))
+			},
+			err: "expected " + tp.URL,
+		},
+		{
+			name: "expired",
+			token: func() string {
+				return tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n1", "exp": time.Now().Add(-2 * clockSkew).Unix()}))
+			},
+			err: "expired",
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				return tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n1", "exp": nil}))
+			},
+			err: "no expiry",
+		},
+		{
+			name: "issued in the future",
+			token: func() string {
+				return tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n1", "iat": time.Now().Add(2 * clockSkew).Unix()}))
+			},
+			err: "issued in the future",
+		},
+		{
+			name:  "nonce mismatch",
+			token: func() string { return tp.sign(t, tp.key, "RS256", "k1", tp.claims(map[string]any{"nonce": "n2"})) },
+			err:   "nonce mismatch",
+		},
+		{
+			name:  "missing nonce",
+			token: func() string { return tp.sign(t, tp.key, "RS256", "k1", tp.claims(nil)) },
+			err:   "nonce mismatch",
+		},
+	}
+
+	p := &provider{issuer: tp.URL}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.verifyIDToken(context.Background(), testConfig(tp), tt.token(), "n1")
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("valid token rejected: %v", err)
+				}
+
+				if claims["sub"] != "alice" {
+					t.Fatalf("got sub %v, want alice", claims["sub"])
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	tp := newTestProvider(t)
+
+	cfgLock.Lock()
+	cfg, prov = testConfig(tp), &provider{issuer: tp.URL}
+	cfgLock.Unlock()
+
+	r := chi.NewRouter()
+	r.Route("/api/authoidc", loadOidcApi)
+
+	// Login redirects to the provider with a PKCE challenge
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/authoidc/login?redirect=/systemd", nil))
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", w.Code, w.Body.String())
+	}
+
+	authUrl, err := url.Parse(w.Header().Get("Location"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if authUrl.Query().Get("code_challenge_method") != "S256" || authUrl.Query().Get("nonce") == "" {
+		t.Fatalf("authorization request has no PKCE challenge or nonce: %s", authUrl)
+	}
+
+	loginCookies := w.Result().Cookies()
+
+	// The provider redirects back with a code
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authUrl.String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/authoidc/callback?"+callback.RawQuery, nil)
+
+	for _, c := range loginCookies {
+		req.AddCookie(c)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/systemd" {
+		t.Fatalf("callback returned %d (%s): %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/systemd/getServiceList", nil)
+
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+
+	s, err := readSession(req, cfg)
+
+	if err != nil {
+		t.Fatalf("no session after login: %v", err)
+	}
+
+	if s.UserID != "alice" || s.Name != "Alice" {
+		t.Fatalf("got session %+v, want alice", s)
+	}
+}
+
+func TestExchangeRequiresVerifier(t *testing.T) {
+	tp := newTestProvider(t)
+
+	tp.mu.Lock()
+	tp.logins["code-1"] = url.Values{"code_challenge": {"not-the-challenge"}}
+	tp.mu.Unlock()
+
+	p := &provider{issuer: tp.URL}
+
+	_, err := p.exchange(context.Background(), testConfig(tp), "code-1", "verifier")
+
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("got error %v, want invalid_grant", err)
+	}
+}
+
+func TestFetchDoesNotBlockCache(t *testing.T) {
+	tp := newTestProvider(t)
+
+	p := &provider{issuer: tp.URL}
+
+	_, err := p.discover(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := make(chan struct{})
+
+	tp.mu.Lock()
+	tp.blockJwks = block
+	tp.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		p.key(context.Background(), "k1")
+	}()
+
+	// Wait for the key fetch to reach the provider
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		tp.mu.Lock()
+		fetches := tp.jwksFetches
+		tp.mu.Unlock()
+
+		if fetches > 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("key fetch never reached the provider")
+		}
+	}
+
+	res := make(chan error, 1)
+
+	go func() {
+		_, err := p.discover(context.Background())
+		res <- err
+	}()
+
+	select {
+	case err = <-res:
+		if err != nil {
+			t.Errorf("cached metadata was not returned while keys were fetched: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("discover blocked on the key fetch")
+	}
+
+	close(block)
+	<-done
+}
+
+func TestSafeRedirect(t *testing.T) {
+	tests := []struct {
+		redirect string
+		want     string
+	}{
+		{redirect: "/systemd", want: "/systemd"},
+		{redirect: "/deploy?id=app#logs", want: "/deploy?id=app#logs"},
+		{redirect: "", want: "/"},
+		{redirect: "systemd", want: "/"},
+		{redirect: "https://evil.com", want: "/"},
+		{redirect: "//evil.com", want: "/"},
+		{redirect: "/\\evil.com", want: "/"},
+		{redirect: "/\t/evil.com", want: "/"},
+		{redirect: "/\n/evil.com", want: "/"},
+		{redirect: "/x\\..\\..\\evil.com", want: "/"},
+		{redirect: "javascript:alert(1)", want: "/"},
+		{redirect: "/%2F/evil.com", want: "/"},
+	}
+
+	for _, tt := range tests {
+		if got := safeRedirect(tt.redirect); got != tt.want {
+			t.Errorf("safeRedirect(%q) = %q, want %q", tt.redirect, got, tt.want)
+		}
+	}
+}
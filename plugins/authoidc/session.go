@@ -0,0 +1,142 @@
+package authoidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Name of the cookie holding the state of a login in progress
+const loginCookie = "sysmanage_oidc_login"
+
+// How long a user has to log in at the provider
+const loginTTL = 10 * time.Minute
+
+// The session of a logged in user, stored in a signed cookie
+type session struct {
+	UserID string   `json:"u"`
+	Name   string   `json:"n,omitempty"`
+	Groups []string `json:"g,omitempty"`
+	Expiry int64    `json:"e"`
+}
+
+// The state of a login in progress, stored in a signed cookie until the provider redirects back
+type loginState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"` // PKCE code verifier
+	Redirect string `json:"r"` // Where to send the user after logging in
+	Expiry   int64  `json:"e"`
+}
+
+// Derives the key for a kind of cookie, so a cookie of one kind can never be used as another
+func cookieKey(secret, kind string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(kind))
+	return h.Sum(nil)
+}
+
+// Encodes v as base64(json).base64(hmac)
+func sign(key []byte, v any) (string, error) {
+	bytes, err := json.Marshal(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(bytes)
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// Checks the signature of a value encoded by sign and decodes it into v
+func verify(key []byte, value string, v any) error {
+	payload, sig, ok := strings.Cut(value, ".")
+
+	if !ok {
+		return errors.New("malformed cookie")
+	}
+
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+
+	if err != nil {
+		return errors.New("malformed cookie")
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+
+	if !hmac.Equal(gotSig, h.Sum(nil)) {
+		return errors.New("invalid cookie signature")
+	}
+
+	bytes, err := base64.RawURLEncoding.DecodeString(payload)
+
+	if err != nil {
+		return errors.New("malformed cookie")
+	}
+
+	return json.Unmarshal(bytes, v)
+}
+
+// Returns a random url-safe string with n bytes of entropy
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func setCookie(w http.ResponseWriter, cfg *Config, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.RedirectURL, "https://"),
+		// Lax so the cookies are sent when the provider redirects back
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearCookie(w http.ResponseWriter, cfg *Config, name string) {
+	setCookie(w, cfg, name, "", -time.Second)
+}
+
+// Returns the session of the request, if any
+func readSession(r *http.Request, cfg *Config) (*session, error) {
+	c, err := r.Cookie(cfg.CookieName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var s session
+
+	err = verify(cookieKey(cfg.SessionSecret, "session"), c.Value, &s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() > s.Expiry {
+		return nil, errors.New("session expired")
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,148 @@
+package authtoken
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+)
+
+func loadTokenApi(r chi.Router) {
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost, "/getTokenList")
+
+	r.Post("/createToken", func(w http.ResponseWriter, r *http.Request) {
+		// Otherwise a token could be used to create a token with more scopes than itself
+		if p := plugins.GetPrincipal(r); p != nil && p.Method == ID {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Tokens cannot be used to create tokens"))
+			return
+		}
+
+		var req CreateToken
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Failed to decode request: " + err.Error()))
+			return
+		}
+
+		err = state.Validator.Struct(req)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		cfg, tokens := current()
+
+		expiresAt, err := cfg.expiry(req.ExpiresIn)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		userId := plugins.UserID(r)
+
+		t := &Token{
+			Name:      req.Name,
+			UserID:    userId,
+			Scopes:    req.Scopes,
+			CreatedAt: time.Now(),
+			CreatedBy: userId,
+			ExpiresAt: expiresAt,
+		}
+
+		token, err := tokens.create(t)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		bytes, err := json.Marshal(CreatedToken{
+			Token:  token,
+			Detail: t.redacted(),
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to marshal token."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+
+	r.Post("/getTokenList", func(w http.ResponseWriter, r *http.Request) {
+		userId := plugins.UserID(r)
+
+		// An empty user id would list the tokens of all users
+		if userId == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("SAFETY VIOLATION: user id is unset"))
+			return
+		}
+
+		_, tokens := current()
+
+		list, err := tokens.list(userId)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		bytes, err := json.Marshal(list)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to marshal tokens."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+
+	r.Post("/revokeToken", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing id"))
+			return
+		}
+
+		userId := plugins.UserID(r)
+
+		if userId == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("SAFETY VIOLATION: user id is unset"))
+			return
+		}
+
+		_, tokens := current()
+
+		// Users can only revoke their own tokens, use the token CLI command for others
+		err := tokens.revoke(id, userId)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
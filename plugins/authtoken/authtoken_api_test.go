@@ -0,0 +1,114 @@
+package authtoken
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+)
+
+// Points the plugin at a empty tokens file and returns a router with its api
+func setupApi(t *testing.T) (*store, chi.Router) {
+	s := newTestStore(t)
+
+	cfgLock.Lock()
+	oldCfg, oldTokens := cfg, tokens
+	cfg, tokens = &Config{DefaultTTL: 3600}, s
+	cfgLock.Unlock()
+
+	t.Cleanup(func() {
+		cfgLock.Lock()
+		cfg, tokens = oldCfg, oldTokens
+		cfgLock.Unlock()
+	})
+
+	r := chi.NewRouter()
+	loadTokenApi(r)
+
+	return s, r
+}
+
+func callApi(r http.Handler, path, body string, p *plugins.Principal) *httptest.ResponseRecorder {
+	req := plugins.WithPrincipal(httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)), p)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestCreateToken(t *testing.T) {
+	s, r := setupApi(t)
+
+	body := `{"Name": "ci", "Scopes": ["systemd/*"]}`
+
+	// A token must not be able to mint tokens, which could have more scopes than itself
+	w := callApi(r, "/createToken", body, &plugins.Principal{ID: "alice", Method: ID})
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d (%s) creating a token with a token, want %d", w.Code, w.Body.String(), http.StatusForbidden)
+	}
+
+	w = callApi(r, "/createToken", body, &plugins.Principal{ID: "alice", Method: "authoidc"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d (%s), want %d", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	var created CreatedToken
+
+	err := json.Unmarshal(w.Body.Bytes(), &created)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := s.verify(created.Token)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tok.UserID != "alice" || tok.ExpiresAt == nil || created.Detail.Hash != "" {
+		t.Fatalf("got token %+v, want a token of alice expiring after default_ttl without its hash", created.Detail)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	s, r := setupApi(t)
+
+	createTestToken(t, s, &Token{Name: "alice", UserID: "alice", Scopes: []string{"*"}})
+	createTestToken(t, s, &Token{Name: "bob", UserID: "bob", Scopes: []string{"*"}})
+
+	list, _ := s.list("")
+
+	ids := map[string]string{}
+
+	for _, tok := range list {
+		ids[tok.UserID] = tok.ID
+	}
+
+	w := callApi(r, "/revokeToken?id="+ids["alice"], "", &plugins.Principal{ID: "bob"})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d revoking another user's token, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if list, _ := s.list("alice"); len(list) != 1 {
+		t.Fatalf("alice has %d tokens after bob tried to revoke hers, want 1", len(list))
+	}
+
+	w = callApi(r, "/revokeToken?id="+ids["bob"], "", &plugins.Principal{ID: "bob"})
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d (%s) revoking own token, want %d", w.Code, w.Body.String(), http.StatusNoContent)
+	}
+
+	if list, _ := s.list(""); len(list) != 1 || list[0].UserID != "alice" {
+		t.Fatalf("got tokens %+v, want only alice's", list)
+	}
+}
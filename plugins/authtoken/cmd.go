@@ -0,0 +1,154 @@
+package authtoken
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	coreconfig "github.com/infinitybotlist/sysmanage-web/core/config"
+	"github.com/infinitybotlist/sysmanage-web/core/server/cmd"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+)
+
+// A flag that can be given multiple times
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func init() {
+	cmd.AddCommand(cmd.Command{
+		Name:        "token",
+		Description: "Create, list and revoke API tokens (token create|list|revoke)",
+		Run:         tokenCommand,
+	})
+}
+
+func tokenUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  token create -user <id> -name <name> -scope <plugin>/<route> [-scope ...] [-expires <duration>]")
+	fmt.Println("  token list [-user <id>]")
+	fmt.Println("  token revoke <id>")
+	os.Exit(1)
+}
+
+func tokenCommand() {
+	if len(os.Args) < 3 {
+		tokenUsage()
+	}
+
+	config, err := coreconfig.Load(coreconfig.Path)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	state.SetConfig(config)
+
+	err = loadConfig(ID)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	cfg, tokens := current()
+
+	switch os.Args[2] {
+	case "create":
+		flags := flag.NewFlagSet("token create", flag.ExitOnError)
+
+		var (
+			userId, name string
+			scopes       listFlag
+			expires      time.Duration
+		)
+
+		flags.StringVar(&userId, "user", "", "User id the token acts as")
+		flags.StringVar(&name, "name", "", "Name of the token, e.g. what it is used for")
+		flags.Var(&scopes, "scope", "Route the token may access as <plugin>/<route>, globs are allowed. Can be given multiple times")
+		flags.DurationVar(&expires, "expires", 0, "How long the token is valid for (e.g. 720h), defaults to default_ttl")
+		flags.Parse(os.Args[3:])
+
+		if userId == "" || name == "" || len(scopes) == 0 {
+			tokenUsage()
+		}
+
+		expiresAt, err := cfg.expiry(int(expires.Seconds()))
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		token, err := tokens.create(&Token{
+			Name:      name,
+			UserID:    userId,
+			Scopes:    scopes,
+			CreatedAt: time.Now(),
+			CreatedBy: "cli",
+			ExpiresAt: expiresAt,
+		})
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Created token, it will not be shown again:")
+		fmt.Println()
+		fmt.Println(token)
+	case "list":
+		flags := flag.NewFlagSet("token list", flag.ExitOnError)
+
+		var userId string
+
+		flags.StringVar(&userId, "user", "", "Only list tokens of this user id")
+		flags.Parse(os.Args[3:])
+
+		list, err := tokens.list(userId)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, t := range list {
+			expiry := "never"
+
+			if t.ExpiresAt != nil {
+				expiry = t.ExpiresAt.Format(time.RFC3339)
+
+				if t.expired() {
+					expiry += " (expired)"
+				}
+			}
+
+			fmt.Printf("%s %s user=%s scopes=%s expires=%s\n", t.ID, t.Name, t.UserID, strings.Join(t.Scopes, ","), expiry)
+		}
+	case "revoke":
+		if len(os.Args) < 4 {
+			tokenUsage()
+		}
+
+		err := tokens.revoke(os.Args[3], "")
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Revoked token " + os.Args[3])
+	default:
+		tokenUsage()
+	}
+}
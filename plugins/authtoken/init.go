@@ -0,0 +1,108 @@
+// Authenticates automation clients (scripts, CI etc.) using bearer tokens
+//
+// Requests without a token are left to the other auth plugins so that either a browser session
+// or a token is accepted. authtoken should come first in auth.order (or be preloaded first)
+package authtoken
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+const ID = "authtoken"
+
+var (
+	// Guards cfg and tokens as they can change on reload
+	cfgLock sync.RWMutex
+
+	cfg    *Config
+	tokens *store
+)
+
+var preloaded bool
+
+// Returns the current config and tokens file
+func current() (*Config, *store) {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+
+	return cfg, tokens
+}
+
+// Loads the authtoken section of config.yaml
+func loadConfig(name string) error {
+	newCfg, err := plugins.DecodeConfig[Config](name)
+
+	if err != nil {
+		return errors.New("Failed to get authtoken config: " + err.Error())
+	}
+
+	if newCfg.MaxTTL > 0 && (newCfg.DefaultTTL == 0 || newCfg.DefaultTTL > newCfg.MaxTTL) {
+		return errors.New("invalid config:\n  plugins." + name + ".default_ttl: must be at most max_ttl")
+	}
+
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
+
+	cfg = newCfg
+
+	if tokens == nil || tokens.path != newCfg.TokensFile {
+		tokens = &store{path: newCfg.TokensFile}
+	}
+
+	return nil
+}
+
+// Returns when a token created now that is valid for expiresIn seconds expires, nil if it never expires
+func (c *Config) expiry(expiresIn int) (*time.Time, error) {
+	if expiresIn == 0 {
+		expiresIn = c.DefaultTTL
+	}
+
+	if c.MaxTTL > 0 && (expiresIn == 0 || expiresIn > c.MaxTTL) {
+		return nil, errors.New("tokens may be valid for at most " + strconv.Itoa(c.MaxTTL) + " seconds")
+	}
+
+	if expiresIn == 0 {
+		return nil, nil
+	}
+
+	exp := time.Now().Add(time.Duration(expiresIn) * time.Second)
+
+	return &exp, nil
+}
+
+func InitPlugin(c *types.PluginConfig) error {
+	if !preloaded {
+		panic("authtoken plugin must be preloaded")
+	}
+
+	err := loadConfig(c.Name)
+
+	if err != nil {
+		return err
+	}
+
+	loadTokenApi(c.Mux)
+
+	state.AuthPlugins = append(state.AuthPlugins, ID)
+
+	return nil
+}
+
+// Applies a new config, existing tokens stay valid unless tokens_file changed
+func Reload(c *types.PluginConfig) error {
+	return loadConfig(c.Name)
+}
+
+func Preload(c *types.PluginConfig) error {
+	plugins.RegisterAuthenticator(ID, tokenAuthenticator{})
+	preloaded = true
+	return nil
+}
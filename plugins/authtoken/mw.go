@@ -0,0 +1,40 @@
+package authtoken
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+)
+
+// Authenticates requests using the API token in the Authorization header
+type tokenAuthenticator struct{}
+
+func (tokenAuthenticator) Authenticate(r *http.Request) (*plugins.Principal, error) {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if !ok || !strings.HasPrefix(bearer, tokenPrefix) {
+		// Leave the request to the other auth plugins
+		return nil, nil
+	}
+
+	_, tokens := current()
+
+	t, err := tokens.verify(bearer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens are only for the API, /api/<plugin>/<route>
+	plugin, route, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+
+	if !strings.HasPrefix(r.URL.Path, "/api/") || !t.allows(plugin, route) {
+		return nil, &plugins.AuthError{
+			Status:  http.StatusForbidden,
+			Message: "Token " + t.ID + " is not scoped to " + r.URL.Path,
+		}
+	}
+
+	return &plugins.Principal{ID: t.UserID}, nil
+}
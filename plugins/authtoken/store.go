@@ -0,0 +1,317 @@
+package authtoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix of all tokens, makes them easy to spot in logs and secret scanners
+const tokenPrefix = "smt_"
+
+// The tokens file. The file is re-read whenever it changes on disk so that tokens
+// created or revoked through the CLI apply without a restart
+type store struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	tokens  []*Token
+}
+
+// Reloads the tokens if the file has changed. Callers must hold s.mu
+func (s *store) load() error {
+	st, err := os.Stat(s.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		s.tokens = nil
+		s.modTime = time.Time{}
+		return nil
+	}
+
+	if err != nil {
+		return errors.New("Failed to stat tokens file: " + err.Error())
+	}
+
+	if st.ModTime().Equal(s.modTime) && s.tokens != nil {
+		return nil
+	}
+
+	bytes, err := os.ReadFile(s.path)
+
+	if err != nil {
+		return errors.New("Failed to read tokens file: " + err.Error())
+	}
+
+	var tokens []*Token
+
+	err = json.Unmarshal(bytes, &tokens)
+
+	if err != nil {
+		return errors.New("Failed to decode tokens file: " + err.Error())
+	}
+
+	if tokens == nil {
+		tokens = []*Token{}
+	}
+
+	s.tokens = tokens
+	s.modTime = st.ModTime()
+
+	return nil
+}
+
+// Atomically writes the tokens file. Callers must hold s.mu
+func (s *store) save() error {
+	bytes, err := json.MarshalIndent(s.tokens, "", "\t")
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".tokens-*")
+
+	if err != nil {
+		return errors.New("Failed to write tokens file: " + err.Error())
+	}
+
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(bytes)
+
+	if err == nil {
+		err = f.Chmod(0600)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return errors.New("Failed to write tokens file: " + err.Error())
+	}
+
+	err = os.Rename(f.Name(), s.path)
+
+	if err != nil {
+		return errors.New("Failed to write tokens file: " + err.Error())
+	}
+
+	if st, err := os.Stat(s.path); err == nil {
+		s.modTime = st.ModTime()
+	}
+
+	return nil
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// Checks that each scope is <plugin>/<route> or *, with valid globs
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if scope == "*" {
+			continue
+		}
+
+		plugin, route, ok := strings.Cut(scope, "/")
+
+		if !ok || plugin == "" || route == "" {
+			return errors.New("invalid scope " + scope + ", expected <plugin>/<route> or *")
+		}
+
+		for _, glob := range []string{plugin, route} {
+			if _, err := path.Match(glob, ""); err != nil {
+				return errors.New("invalid scope " + scope + ": " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns whether a token may access a route of a plugin
+func (t *Token) allows(plugin, route string) bool {
+	for _, scope := range t.Scopes {
+		if scope == "*" {
+			return true
+		}
+
+		sp, sr, _ := strings.Cut(scope, "/")
+
+		if ok, _ := path.Match(sp, plugin); !ok {
+			continue
+		}
+
+		if ok, _ := path.Match(sr, route); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Token) expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// Returns a copy of the token without its hash
+func (t *Token) redacted() *Token {
+	c := *t
+	c.Hash = ""
+	return &c
+}
+
+// Creates and stores a new token, returning the token itself along with its details
+func (s *store) create(t *Token) (string, error) {
+	err := validateScopes(t.Scopes)
+
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+
+	for _, b := range [][]byte{id, secret} {
+		_, err = rand.Read(b)
+
+		if err != nil {
+			return "", errors.New("Failed to generate token: " + err.Error())
+		}
+	}
+
+	t.ID = hex.EncodeToString(id)
+
+	secretStr := base64.RawURLEncoding.EncodeToString(secret)
+	t.Hash = hashSecret(secretStr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.load()
+
+	if err != nil {
+		return "", err
+	}
+
+	s.tokens = append(s.tokens, t)
+
+	err = s.save()
+
+	if err != nil {
+		s.tokens = s.tokens[:len(s.tokens)-1]
+		return "", err
+	}
+
+	return tokenPrefix + t.ID + "_" + secretStr, nil
+}
+
+// Returns the token matching the given bearer token. Expired tokens are not returned
+func (s *store) verify(bearer string) (*Token, error) {
+	rest, ok := strings.CutPrefix(bearer, tokenPrefix)
+
+	if !ok {
+		return nil, errors.New("not a sysmanage token")
+	}
+
+	// IDs are hex so the first _ always separates the id from the secret
+	id, secret, ok := strings.Cut(rest, "_")
+
+	if !ok {
+		return nil, errors.New("malformed token")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range s.tokens {
+		if t.ID != id {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hashSecret(secret))) != 1 {
+			return nil, errors.New("invalid token")
+		}
+
+		if t.expired() {
+			return nil, errors.New("token has expired")
+		}
+
+		return t.redacted(), nil
+	}
+
+	return nil, errors.New("invalid token")
+}
+
+// Returns all tokens of a user (or all tokens if userId is empty) without their hashes
+func (s *store) list(userId string) ([]*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*Token{}
+
+	for _, t := range s.tokens {
+		if userId == "" || t.UserID == userId {
+			list = append(list, t.redacted())
+		}
+	}
+
+	return list, nil
+}
+
+// Deletes a token. If userId is set, only tokens of that user can be revoked
+func (s *store) revoke(id, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.load()
+
+	if err != nil {
+		return err
+	}
+
+	for i, t := range s.tokens {
+		if t.ID != id || (userId != "" && t.UserID != userId) {
+			continue
+		}
+
+		s.tokens = append(s.tokens[:i:i], s.tokens[i+1:]...)
+
+		err = s.save()
+
+		if err != nil {
+			// Force a reload on the next access
+			s.tokens = nil
+			return err
+		}
+
+		return nil
+	}
+
+	return errors.New("token not found")
+}
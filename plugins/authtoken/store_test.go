@@ -0,0 +1,116 @@
+package authtoken
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *store {
+	return &store{path: filepath.Join(t.TempDir(), "tokens.json")}
+}
+
+// Creates a token, failing the test on error
+func createTestToken(t *testing.T, s *store, tok *Token) string {
+	token, err := s.create(tok)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestStore(t)
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	valid := createTestToken(t, s, &Token{Name: "ci", UserID: "alice", Scopes: []string{"*"}, ExpiresAt: &future})
+	expired := createTestToken(t, s, &Token{Name: "old", UserID: "alice", Scopes: []string{"*"}, ExpiresAt: &past})
+
+	id, _, _ := strings.Cut(strings.TrimPrefix(valid, tokenPrefix), "_")
+
+	tests := []struct {
+		name   string
+		bearer string
+		err    string
+	}{
+		{name: "valid", bearer: valid},
+		{name: "unknown id", bearer: tokenPrefix + "0000000000000000_" + strings.Repeat("a", 43), err: "invalid token"},
+		{name: "wrong secret", bearer: tokenPrefix + id + "_" + strings.Repeat("a", 43), err: "invalid token"},
+		{name: "expired", bearer: expired, err: "token has expired"},
+		{name: "not a sysmanage token", bearer: "ghp_abc", err: "not a sysmanage token"},
+		{name: "malformed", bearer: tokenPrefix + id, err: "malformed token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, err := s.verify(tt.bearer)
+
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tok.UserID != "alice" || tok.Hash != "" {
+				t.Fatalf("got token %+v, want alice's token without its hash", tok)
+			}
+		})
+	}
+}
+
+func TestTokenAllows(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		plugin string
+		route  string
+		want   bool
+	}{
+		{scopes: []string{"*"}, plugin: "nginx", route: "buildNginx", want: true},
+		{scopes: []string{"nginx/buildNginx"}, plugin: "nginx", route: "buildNginx", want: true},
+		{scopes: []string{"nginx/buildNginx"}, plugin: "nginx", route: "getNginxDomainList", want: false},
+		{scopes: []string{"systemd/*"}, plugin: "systemd", route: "restartService", want: true},
+		{scopes: []string{"systemd/*"}, plugin: "nginx", route: "restartService", want: false},
+		{scopes: []string{"*/get*"}, plugin: "deploy", route: "getDeployList", want: true},
+		{scopes: []string{"*/get*"}, plugin: "deploy", route: "createDeploy", want: false},
+		{scopes: []string{"deploy/get?eploy"}, plugin: "deploy", route: "getDeploy", want: true},
+		{scopes: []string{"nginx/*", "deploy/createDeploy"}, plugin: "deploy", route: "createDeploy", want: true},
+		{scopes: nil, plugin: "nginx", route: "buildNginx", want: false},
+	}
+
+	for _, tt := range tests {
+		tok := &Token{Scopes: tt.scopes}
+
+		if got := tok.allows(tt.plugin, tt.route); got != tt.want {
+			t.Errorf("%q allows %s/%s = %v, want %v", tt.scopes, tt.plugin, tt.route, got, tt.want)
+		}
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		err    bool
+	}{
+		{scopes: []string{"*", "nginx/buildNginx", "systemd/*"}},
+		{scopes: []string{"nginx"}, err: true},
+		{scopes: []string{"/buildNginx"}, err: true},
+		{scopes: []string{"nginx/[build"}, err: true},
+	}
+
+	for _, tt := range tests {
+		if err := validateScopes(tt.scopes); (err != nil) != tt.err {
+			t.Errorf("validateScopes(%q) = %v, want error: %v", tt.scopes, err, tt.err)
+		}
+	}
+}
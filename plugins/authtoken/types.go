@@ -0,0 +1,33 @@
+package authtoken
+
+import "time"
+
+// The authtoken section of config.yaml
+type Config struct {
+	TokensFile string `yaml:"tokens_file" validate:"required"`
+	DefaultTTL int    `yaml:"default_ttl" default:"2592000" validate:"gte=0"` // Seconds, used when a token is created without an expiry. 0 means tokens never expire by default
+	MaxTTL     int    `yaml:"max_ttl" validate:"gte=0"`                       // Seconds, 0 means no limit
+}
+
+// A API token. Only the hash of the secret is stored
+type Token struct {
+	ID        string
+	Name      string
+	UserID    string   // The user the token acts as
+	Scopes    []string // Routes the token may access as <plugin>/<route>, globs are allowed (e.g. nginx/buildNginx, systemd/*, *)
+	Hash      string   `json:",omitempty"`
+	CreatedAt time.Time
+	CreatedBy string
+	ExpiresAt *time.Time // Unset if the token never expires
+}
+
+type CreateToken struct {
+	Name      string   `validate:"required"`
+	Scopes    []string `validate:"required,min=1"`
+	ExpiresIn int      `validate:"gte=0"` // Seconds, defaults to default_ttl
+}
+
+type CreatedToken struct {
+	Token  string // Only shown once
+	Detail *Token
+}
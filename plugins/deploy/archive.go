@@ -0,0 +1,500 @@
+package deploy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+)
+
+// Largest download in bytes, set from max_download_size
+var maxDownloadSize atomic.Int64
+
+func downloadLimit() int64 {
+	if max := maxDownloadSize.Load(); max > 0 {
+		return max
+	}
+
+	return 2048 << 20
+}
+
+func tooLargeError(what string) error {
+	return errors.New(what + " is larger than max_download_size (" + strconv.FormatInt(downloadLimit()>>20, 10) + " MB)")
+}
+
+// Copies a download to w, failing once it is larger than max_download_size. size is the
+// expected size of the download, -1 if unknown
+func copyDownload(w io.Writer, r io.Reader, what string, size int64) error {
+	max := downloadLimit()
+
+	if size > max {
+		return tooLargeError(what)
+	}
+
+	n, err := io.Copy(w, io.LimitReader(r, max+1))
+
+	if err != nil {
+		return err
+	}
+
+	if n > max {
+		return tooLargeError(what)
+	}
+
+	return nil
+}
+
+// Logs download and unpack progress every 10%, or every 10 MB if the size is unknown
+type progressWriter struct {
+	out     io.Writer
+	prefix  string
+	total   int64
+	written int64
+	next    int64
+}
+
+func newProgressWriter(out io.Writer, prefix string, total int64) *progressWriter {
+	p := &progressWriter{out: out, prefix: prefix, total: total}
+	p.next = p.step()
+	return p
+}
+
+func (p *progressWriter) step() int64 {
+	if p.total > 0 {
+		return p.total / 10
+	}
+
+	return 10 << 20
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+
+	if p.written >= p.next {
+		p.next += max64(p.step(), 1)
+
+		if p.total > 0 {
+			fmt.Fprintf(p.out, "%s: %d/%d MB (%d%%)\n", p.prefix, p.written>>20, p.total>>20, p.written*100/p.total)
+		} else {
+			fmt.Fprintf(p.out, "%s: %d MB\n", p.prefix, p.written>>20)
+		}
+	}
+
+	return len(b), nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// Checks a file against a checksum of the form sha256:<hex>
+func verifyChecksum(file, checksum string) error {
+	want, ok := strings.CutPrefix(checksum, "sha256:")
+
+	if !ok {
+		return errors.New("unsupported checksum " + checksum + ", expected sha256:<hex>")
+	}
+
+	f, err := os.Open(file)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, f)
+
+	if err != nil {
+		return err
+	}
+
+	got := hex.EncodeToString(h.Sum(nil))
+
+	if !strings.EqualFold(got, want) {
+		return errors.New("checksum mismatch, expected sha256:" + want + " but got sha256:" + got)
+	}
+
+	return nil
+}
+
+// Returns the path an archive entry should be unpacked to, with the first strip path
+// components removed. ok is false for entries that are stripped entirely
+func entryPath(dest, name string, strip int) (p string, ok bool, err error) {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+
+	if len(parts) <= strip || (len(parts) == 1 && parts[0] == "") {
+		return "", false, nil
+	}
+
+	rel := filepath.FromSlash(strings.Join(parts[strip:], "/"))
+
+	// Never write through a symlink unpacked earlier, it could point anywhere
+	dir := dest
+
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+
+		dir = filepath.Join(dir, part)
+
+		st, err := os.Lstat(dir)
+
+		if err == nil && st.Mode()&os.ModeSymlink != 0 {
+			return "", false, errors.New("refusing to unpack " + name + " through symlink " + dir)
+		}
+	}
+
+	return filepath.Join(dest, rel), true, nil
+}
+
+// Writes a regular file, replacing anything in its place
+func writeFile(p string, mode fs.FileMode, r io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+
+	if err != nil {
+		return err
+	}
+
+	os.RemoveAll(p)
+
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Unpacks a tar stream into dest. If whiteouts is set, OCI whiteout files remove the files
+// of earlier layers instead of being unpacked
+func unpackTar(r io.Reader, dest string, strip int, whiteouts bool) error {
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return errors.New("Failed to read tar: " + err.Error())
+		}
+
+		p, ok, err := entryPath(dest, hdr.Name, strip)
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if whiteouts {
+			base := filepath.Base(p)
+
+			if base == ".wh..wh..opq" {
+				// Opaque directory, hide everything from earlier layers
+				entries, _ := os.ReadDir(filepath.Dir(p))
+
+				for _, e := range entries {
+					os.RemoveAll(filepath.Join(filepath.Dir(p), e.Name()))
+				}
+
+				continue
+			}
+
+			if name, ok := strings.CutPrefix(base, ".wh."); ok {
+				os.RemoveAll(filepath.Join(filepath.Dir(p), name))
+				continue
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, hdr.FileInfo().Mode().Perm()|0700)
+		case tar.TypeReg:
+			err = writeFile(p, hdr.FileInfo().Mode(), tr)
+		case tar.TypeSymlink:
+			os.MkdirAll(filepath.Dir(p), 0755)
+			os.RemoveAll(p)
+			err = os.Symlink(hdr.Linkname, p)
+		case tar.TypeLink:
+			var target string
+
+			target, ok, err = entryPath(dest, hdr.Linkname, strip)
+
+			if err == nil && ok {
+				os.MkdirAll(filepath.Dir(p), 0755)
+				os.RemoveAll(p)
+				err = os.Link(target, p)
+			}
+		default:
+			// Devices, fifos etc. have no place in a deploy
+			continue
+		}
+
+		if err != nil {
+			return errors.New("Failed to unpack " + hdr.Name + ": " + err.Error())
+		}
+	}
+}
+
+// Unpacks a zip file into dest
+func unpackZip(file, dest string, strip int) error {
+	zr, err := zip.OpenReader(file)
+
+	if err != nil {
+		return errors.New("Failed to open zip: " + err.Error())
+	}
+
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		p, ok, err := entryPath(dest, zf.Name, strip)
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if zf.FileInfo().IsDir() {
+			err = os.MkdirAll(p, 0755)
+		} else if zf.Mode()&os.ModeSymlink != 0 {
+			err = errors.New("symlinks in zip files are not supported")
+		} else {
+			var rc io.ReadCloser
+
+			rc, err = zf.Open()
+
+			if err == nil {
+				err = writeFile(p, zf.Mode(), rc)
+				rc.Close()
+			}
+		}
+
+		if err != nil {
+			return errors.New("Failed to unpack " + zf.Name + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Unpacks a .tar, .tar.gz or .zip file into dest, detecting the format from its contents
+func unpackArchive(file, dest string, strip int) error {
+	f, err := os.Open(file)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return unpackZip(file, dest, strip)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+
+		if err != nil {
+			return errors.New("Failed to read gzip: " + err.Error())
+		}
+
+		defer gz.Close()
+
+		return unpackTar(gz, dest, strip, false)
+	default:
+		return unpackTar(br, dest, strip, false)
+	}
+}
+
+// Copies a directory tree, keeping file modes and symlinks
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			f, err := os.Open(p)
+
+			if err != nil {
+				return err
+			}
+
+			defer f.Close()
+
+			return writeFile(target, info.Mode(), f)
+		default:
+			return nil
+		}
+	})
+}
+
+// Downloads url into a temporary file in dir, the caller must remove the file
+func download(ctx context.Context, logId, url, token, dir string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(url + " returned " + resp.Status)
+	}
+
+	if resp.ContentLength > downloadLimit() {
+		return "", tooLargeError(url)
+	}
+
+	f, err := os.CreateTemp(dir, "download-*")
+
+	if err != nil {
+		return "", err
+	}
+
+	err = copyDownload(io.MultiWriter(f, newProgressWriter(logger.AutoLogger{ID: logId}, "Downloaded", resp.ContentLength)), resp.Body, url, resp.ContentLength)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.New("Failed to download " + url + ": " + err.Error())
+	}
+
+	return f.Name(), nil
+}
+
+// Unpacks an archive file into the build directory, verifying it against the checksum of the source
+func unpackSource(logId, file, buildDir string, d *DeployMeta) error {
+	if d.Src.Checksum != "" {
+		err := verifyChecksum(file, d.Src.Checksum)
+
+		if err != nil {
+			return err
+		}
+
+		logger.LogMap.Add(logId, "Checksum verified", true)
+	} else {
+		logger.LogMap.Add(logId, "WARNING: No checksum is set, the archive is not verified", true)
+	}
+
+	logger.LogMap.Add(logId, "Unpacking archive", true)
+
+	return unpackArchive(file, buildDir, d.Src.StripComponents)
+}
+
+// Deploys a .tar, .tar.gz or .zip file downloaded over HTTP(S). The token, if set, is sent as a bearer token
+func archiveSource(logId, buildDir string, d *DeployMeta) error {
+	logger.LogMap.Add(logId, "Downloading "+d.Src.Url, true)
+
+	file, err := download(tasks.Context(logId), logId, d.Src.Url, d.Src.Token, filepath.Dir(buildDir))
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file)
+
+	return unpackSource(logId, file, buildDir, d)
+}
+
+// Deploys a directory or archive file on the local machine
+func localSource(logId, buildDir string, d *DeployMeta) error {
+	st, err := os.Stat(d.Src.Url)
+
+	if err != nil {
+		return errors.New("Failed to stat source: " + err.Error())
+	}
+
+	if !st.IsDir() {
+		return unpackSource(logId, d.Src.Url, buildDir, d)
+	}
+
+	logger.LogMap.Add(logId, "Copying "+d.Src.Url, true)
+
+	return copyDir(d.Src.Url, buildDir)
+}
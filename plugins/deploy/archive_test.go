@@ -0,0 +1,381 @@
+package deploy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// A tar entry, a regular file unless typ is set
+type tarEntry struct {
+	name, body, link string
+	typ              byte
+}
+
+func makeTar(t *testing.T, gz bool, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+
+	var gw *gzip.Writer
+	var tw *tar.Writer
+
+	if gz {
+		gw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gw)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+
+	for _, e := range entries {
+		typ := e.typ
+
+		if typ == 0 {
+			typ = tar.TypeReg
+		}
+
+		err := tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: typ, Size: int64(len(e.body)), Mode: 0644, Linkname: e.link})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tw.Write([]byte(e.body))
+	}
+
+	tw.Close()
+
+	if gz {
+		gw.Close()
+	}
+
+	return buf.Bytes()
+}
+
+func sha256Digest(b []byte) string {
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// Lists the files of a directory as path=content, symlinks as path->target
+func listFiles(t *testing.T, dir string) string {
+	var files []string
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, _ := os.Readlink(p)
+			files = append(files, rel+"->"+link)
+		case info.Mode().IsRegular():
+			b, _ := os.ReadFile(p)
+			files = append(files, rel+"="+string(b))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(files)
+
+	return strings.Join(files, " ")
+}
+
+// Runs a deploy source into a new build directory
+func loadSource(t *testing.T, src *DeploySource) (string, error) {
+	buildDir := filepath.Join(t.TempDir(), "output")
+
+	err := os.MkdirAll(buildDir, 0755)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buildDir, DeploySources[src.Type]("test", buildDir, &DeployMeta{Src: src})
+}
+
+func setDownloadLimit(t *testing.T, max int64) {
+	old := maxDownloadSize.Load()
+	maxDownloadSize.Store(max)
+
+	t.Cleanup(func() {
+		maxDownloadSize.Store(old)
+	})
+}
+
+func TestArchiveSource(t *testing.T) {
+	tgz := makeTar(t, true,
+		tarEntry{name: "app-1.0/", typ: tar.TypeDir},
+		tarEntry{name: "app-1.0/index.html", body: "hi"},
+		tarEntry{name: "app-1.0/static/app.js", body: "js"},
+	)
+
+	var zipBuf bytes.Buffer
+
+	zw := zip.NewWriter(&zipBuf)
+	f, _ := zw.Create("site/index.html")
+	f.Write([]byte("zip"))
+	zw.Close()
+
+	files := map[string][]byte{
+		"/app.tar.gz": tgz,
+		"/app.zip":    zipBuf.Bytes(),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private.tar.gz" && r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		b, ok := files[strings.Replace(r.URL.Path, "private", "app", 1)]
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(b)
+	}))
+
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		src   DeploySource
+		limit int64
+		files string
+		err   string
+	}{
+		{
+			name:  "tarball with checksum",
+			src:   DeploySource{Url: srv.URL + "/app.tar.gz", Checksum: sha256Digest(tgz), StripComponents: 1},
+			files: "index.html=hi static/app.js=js",
+		},
+		{
+			name:  "without strip components",
+			src:   DeploySource{Url: srv.URL + "/app.tar.gz"},
+			files: "app-1.0/index.html=hi app-1.0/static/app.js=js",
+		},
+		{
+			name:  "zip",
+			src:   DeploySource{Url: srv.URL + "/app.zip", StripComponents: 1},
+			files: "index.html=zip",
+		},
+		{
+			name:  "token",
+			src:   DeploySource{Url: srv.URL + "/private.tar.gz", Token: "secret", StripComponents: 1},
+			files: "index.html=hi static/app.js=js",
+		},
+		{
+			name: "checksum mismatch",
+			src:  DeploySource{Url: srv.URL + "/app.tar.gz", Checksum: sha256Digest([]byte("other"))},
+			err:  "checksum mismatch",
+		},
+		{
+			name: "unsupported checksum",
+			src:  DeploySource{Url: srv.URL + "/app.tar.gz", Checksum: "md5:abc"},
+			err:  "unsupported checksum",
+		},
+		{
+			name: "not found",
+			src:  DeploySource{Url: srv.URL + "/missing.tar.gz"},
+			err:  "404",
+		},
+		{
+			name:  "larger than max_download_size",
+			src:   DeploySource{Url: srv.URL + "/app.tar.gz"},
+			limit: 16,
+			err:   "larger than max_download_size",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.limit != 0 {
+				setDownloadLimit(t, tt.limit)
+			}
+
+			tt.src.Type = "archive"
+
+			buildDir, err := loadSource(t, &tt.src)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := listFiles(t, buildDir); got != tt.files {
+				t.Fatalf("got files %q, want %q", got, tt.files)
+			}
+
+			// Downloads must not be left behind next to the build directory
+			entries, _ := os.ReadDir(filepath.Dir(buildDir))
+
+			if len(entries) != 1 {
+				t.Fatalf("download was not removed, found %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestUnpackArchiveStaysInside(t *testing.T) {
+	outside := t.TempDir()
+
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		files   string
+		err     string
+	}{
+		{
+			name:    "parent directory",
+			entries: []tarEntry{{name: "../../escaped", body: "x"}, {name: "ok", body: "ok"}},
+			files:   "escaped=x ok=ok",
+		},
+		{
+			name:    "absolute path",
+			entries: []tarEntry{{name: filepath.Join(outside, "abs"), body: "x"}},
+			files:   strings.TrimPrefix(filepath.Join(outside, "abs"), "/") + "=x",
+		},
+		{
+			name: "through symlink",
+			entries: []tarEntry{
+				{name: "link", typ: tar.TypeSymlink, link: outside},
+				{name: "link/pwned", body: "x"},
+			},
+			err: "through symlink",
+		},
+		{
+			name: "hardlink outside",
+			entries: []tarEntry{
+				{name: "ok", body: "ok"},
+				{name: "hard", typ: tar.TypeLink, link: "../../ok"},
+			},
+			files: "hard=ok ok=ok",
+		},
+		{
+			name:    "devices are skipped",
+			entries: []tarEntry{{name: "null", typ: tar.TypeChar}, {name: "ok", body: "ok"}},
+			files:   "ok=ok",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "archive.tar")
+
+			err := os.WriteFile(file, makeTar(t, false, tt.entries...), 0644)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dest := filepath.Join(dir, "out")
+
+			err = unpackArchive(file, dest, 0)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got := listFiles(t, dest); got != tt.files {
+				t.Fatalf("got files %q, want %q", got, tt.files)
+			}
+
+			if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+				t.Fatalf("archive wrote %d files outside the destination", len(entries))
+			}
+		})
+	}
+}
+
+func TestLocalSource(t *testing.T) {
+	src := t.TempDir()
+
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "sub", "a"), []byte("A"), 0644)
+	os.Symlink("sub/a", filepath.Join(src, "link"))
+
+	archive := filepath.Join(t.TempDir(), "app.tar.gz")
+	tgz := makeTar(t, true, tarEntry{name: "app/index.html", body: "hi"})
+	os.WriteFile(archive, tgz, 0644)
+
+	tests := []struct {
+		name  string
+		src   DeploySource
+		files string
+		err   string
+	}{
+		{
+			name:  "directory",
+			src:   DeploySource{Url: src},
+			files: "link->sub/a sub/a=A",
+		},
+		{
+			name:  "archive",
+			src:   DeploySource{Url: archive, Checksum: sha256Digest(tgz), StripComponents: 1},
+			files: "index.html=hi",
+		},
+		{
+			name: "archive checksum mismatch",
+			src:  DeploySource{Url: archive, Checksum: sha256Digest([]byte("other"))},
+			err:  "checksum mismatch",
+		},
+		{
+			name: "missing",
+			src:  DeploySource{Url: filepath.Join(src, "missing")},
+			err:  "Failed to stat source",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.src.Type = "local"
+
+			buildDir, err := loadSource(t, &tt.src)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := listFiles(t, buildDir); got != tt.files {
+				t.Fatalf("got files %q, want %q", got, tt.files)
+			}
+		})
+	}
+}
@@ -3,28 +3,95 @@ package deploy
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"gopkg.in/yaml.v2"
+	coreconfig "github.com/infinitybotlist/sysmanage-web/core/config"
 )
 
-func LoadConfig(name string) (*DeployMeta, error) {
-	// Read file into *DeployMeta
-	f, err := os.Open(deployConfigPath + "/" + name)
+// Returns the path of the config of a deploy. The id is the name of a deploy config in
+// deploy_config_path with or without .yaml, anything else (such as ../) is rejected
+func deployConfigFile(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) {
+		return "", errors.New("invalid deploy id " + id)
+	}
+
+	file := deployConfigPath + "/" + strings.TrimSuffix(id, ".yaml") + ".yaml"
+
+	st, err := os.Stat(file)
+
+	if err != nil || st.IsDir() {
+		return "", errors.New("deploy " + id + " not found")
+	}
+
+	return file, nil
+}
+
+// Reads a deploy config file, resolving ${ENV:NAME} and ${FILE:/path} references if resolve is set
+func readConfig(file string, resolve bool) (*DeployMeta, error) {
+	f, err := os.Open(file)
 
 	if err != nil {
-		return nil, errors.New("Failed to read deploy config " + err.Error() + f.Name())
+		return nil, err
 	}
 
-	// Read file into *DeployMeta
+	defer f.Close()
+
 	var meta *DeployMeta
 
-	err = yaml.NewDecoder(f).Decode(&meta)
+	if resolve {
+		_, err = coreconfig.Decode(f, &meta)
+	} else {
+		err = coreconfig.DecodeRaw(f, &meta)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if meta == nil {
+		return nil, errors.New("config is empty")
+	}
+
+	return meta, nil
+}
+
+// Loads a deploy config, resolving ${ENV:NAME} and ${FILE:/path} references. The result
+// may contain secrets and should never be sent to clients, use LoadRawConfig for that
+func LoadConfig(name string) (*DeployMeta, error) {
+	file, err := deployConfigFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := readConfig(file, true)
+
+	if err != nil {
+		return nil, errors.New("Failed to read deploy config " + err.Error() + name)
+	}
+
+	meta.ID = strings.TrimSuffix(name, ".yaml")
+
+	return meta, nil
+}
+
+// Loads a deploy config as-is, without resolving references
+func LoadRawConfig(name string) (*DeployMeta, error) {
+	file, err := deployConfigFile(name)
 
 	if err != nil {
-		return nil, errors.New("Failed to read deploy config " + err.Error() + f.Name())
+		return nil, err
 	}
 
+	meta, err := readConfig(file, false)
+
+	if err != nil {
+		return nil, errors.New("Failed to read deploy config " + err.Error() + name)
+	}
+
+	meta.ID = strings.TrimSuffix(name, ".yaml")
+
 	return meta, nil
 }
 
@@ -47,17 +114,8 @@ func GetDeployList() ([]*DeployMetaListItem, error) {
 			continue // Skip non-yaml files
 		}
 
-		// Read file into *DeployMeta
-		f, err := os.Open(deployConfigPath + "/" + file.Name())
-
-		if err != nil {
-			return nil, errors.New("Failed to read deploy config " + err.Error() + file.Name())
-		}
-
-		// Read file into *DeployMeta
-		var meta *DeployMeta
-
-		err = yaml.NewDecoder(f).Decode(&meta)
+		// Read file into *DeployMeta, references are left as-is as the list is sent to clients
+		meta, err := readConfig(deployConfigPath+"/"+file.Name(), false)
 
 		if err != nil {
 			return nil, errors.New("Failed to read deploy config " + err.Error() + file.Name())
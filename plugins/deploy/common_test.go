@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfigRejectsPaths(t *testing.T) {
+	dir := t.TempDir()
+
+	deployConfigPath = filepath.Join(dir, "deploys")
+
+	err := os.MkdirAll(deployConfigPath, 0755)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, content := range map[string]string{
+		"deploys/site.yaml": "output_path: /srv/site\n",
+		"secret.yaml":       "output_path: /srv/secret\n",
+	} {
+		err = os.WriteFile(filepath.Join(dir, file), []byte(content), 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id string
+		ok bool
+	}{
+		{id: "site", ok: true},
+		{id: "site.yaml", ok: true},
+		{id: "../secret"},
+		{id: "../secret.yaml"},
+		{id: "/etc/passwd"},
+		{id: ".."},
+		{id: "missing"},
+		{id: ""},
+	}
+
+	for _, tt := range tests {
+		for name, load := range map[string]func(string) (*DeployMeta, error){"LoadConfig": LoadConfig, "LoadRawConfig": LoadRawConfig} {
+			meta, err := load(tt.id)
+
+			if tt.ok && (err != nil || meta.ID != "site" || meta.OutputPath != "/srv/site") {
+				t.Errorf("%s(%q) = %+v, %v, want the site deploy", name, tt.id, meta, err)
+			}
+
+			if !tt.ok && err == nil {
+				t.Errorf("%s(%q) loaded %+v, want an error", name, tt.id, meta)
+			}
+		}
+	}
+}
+
+func TestConfigDecoders(t *testing.T) {
+	deployConfigPath = t.TempDir()
+
+	t.Setenv("TEST_TIMEOUT", "60")
+
+	// yes is a string in yaml 1.2 but a bool in yaml.v2, all loaders must agree
+	config := "output_path: /srv/site\ntimeout: ${ENV:TEST_TIMEOUT}\nenv:\n  FLAG: yes\n"
+
+	err := os.WriteFile(filepath.Join(deployConfigPath, "site.yaml"), []byte(config), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := LoadConfig("site")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.Timeout != 60 || !reflect.DeepEqual(meta.Env, map[string]string{"FLAG": "yes"}) {
+		t.Fatalf("LoadConfig got timeout %d and env %v", meta.Timeout, meta.Env)
+	}
+
+	raw, err := LoadRawConfig("site")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := GetDeployList()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 || list[0].ID != "site" {
+		t.Fatalf("got deploy list %+v, want the site deploy", list)
+	}
+
+	for name, m := range map[string]*DeployMeta{"LoadRawConfig": raw, "GetDeployList": list[0].Meta} {
+		if !reflect.DeepEqual(m.Env, meta.Env) {
+			t.Errorf("%s got env %v, want %v", name, m.Env, meta.Env)
+		}
+
+		// The reference cannot be shown in a int field
+		if m.Timeout != 0 || m.OutputPath != "/srv/site" {
+			t.Errorf("%s got timeout %d and output path %q", name, m.Timeout, m.OutputPath)
+		}
+	}
+}
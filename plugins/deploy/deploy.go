@@ -2,114 +2,107 @@ package deploy
 
 import (
 	"context"
-	"html/template"
+	"errors"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
 )
 
-const scriptTmpl = `
-#!/bin/bash
-
+const scriptTmpl = `#!/bin/bash
 {{range $val := .}}
-echo "> {{$val}}"
+printf '> %s\n' {{quote $val}}
 {{$val}}
 {{end}}
 `
 
-var templ = template.Must(template.New("script").Parse(scriptTmpl))
+// Template of a bash script running a list of commands, printing each command before it runs.
+// Also used by the shell actions of the actions plugin
+var ScriptTemplate = template.Must(template.New("script").Funcs(template.FuncMap{
+	"quote": shellQuote,
+}).Parse(scriptTmpl))
+
+// Quotes s as a single bash word that is never expanded
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
 
+// Runs a deploy, logging its output to logId. If logId is a registered task, the deploy is cancelled with it
 func InitDeploy(logId string, d *DeployMeta) {
+	tasks.Run(logId, func(logId string) error {
+		return runDeploy(tasks.Context(logId), logId, d)
+	})
+}
+
+func runDeploy(ctx context.Context, logId string, d *DeployMeta) error {
 	if d.Src == nil {
-		logger.LogMap.Add(logId, "FATAL: Deploy does not have an associated source setup.", true)
-		return
+		return errors.New("FATAL: Deploy does not have an associated source setup.")
 	}
 
 	if d.Broken {
-		logger.LogMap.Add(logId, "FATAL: Deploy is marked as broken.", true)
-		return
+		return errors.New("FATAL: Deploy is marked as broken.")
 	}
 
-	defer logger.LogMap.MarkDone(logId)
-
 	logger.LogMap.Add(logId, "Started deploy on: "+time.Now().Format(time.RFC3339), true)
 	logger.LogMap.Add(logId, "Deploy Source:"+d.Src.String(), true)
-	logger.LogMap.Add(logId, "Waiting for builds to finish...", true)
-
-	maxConcurrency++
-
-	waits := 0
-	for len(builds) > maxConcurrency {
-		waits++
-
-		if waits%5 == 0 {
-			// Print log entries
-			b := []string{}
+	logger.LogMap.Step(logId, "Waiting in deploy queue")
 
-			for k := range builds {
-				b = append(b, k+": "+builds[k].String())
-			}
+	release, err := enqueue(ctx, logId, d)
 
-			logger.LogMap.Add(logId, strings.Join(b, "\n"), true)
-		}
-
-		time.Sleep(5 * time.Second)
+	if err != nil {
+		return err
 	}
 
-	breakpoint.Lock()
-	builds[logId] = &DeployStatus{
-		Source:    d.Src,
-		CreatedAt: time.Now(),
-	}
-	breakpoint.Unlock()
+	defer release()
 
 	buildDir := "/tmp/deploys/" + logId + "/output"
 
-	err := os.MkdirAll(buildDir, 0755)
+	err = os.MkdirAll(buildDir, 0755)
 
 	if err != nil {
-		logger.LogMap.Add(logId, "FATAL: could not create build folder ["+buildDir+"]: "+err.Error(), true)
-		return
+		return errors.New("FATAL: could not create build folder [" + buildDir + "]: " + err.Error())
 	}
 
 	defer os.RemoveAll(buildDir)
 
 	logger.LogMap.Add(logId, "Output path: "+buildDir, true)
 
+	logger.LogMap.Step(logId, "Loading source")
+
 	srcFn, ok := DeploySources[d.Src.Type]
 
 	if !ok {
-		logger.LogMap.Add(logId, "FATAL: Unknown deploy source type: "+d.Src.Type, true)
-		return
+		return errors.New("FATAL: Unknown deploy source type: " + d.Src.Type)
 	}
 
 	err = srcFn(logId, buildDir, d)
 
 	if err != nil {
-		logger.LogMap.Add(logId, "Error loading source "+d.Src.Type+": "+err.Error(), true)
-		return
+		return errors.New("Error loading source " + d.Src.Type + ": " + err.Error())
 	}
 
+	logger.LogMap.Step(logId, "Running commands")
+
 	// Create script
 	f, err := os.Create(buildDir + "/builder")
 
 	if err != nil {
-		logger.LogMap.Add(logId, "Error creating script: "+err.Error(), true)
-		return
+		return errors.New("Error creating script: " + err.Error())
 	}
 
 	defer f.Close()
 
 	// Write script
-	err = templ.Execute(f, d.Commands)
+	err = ScriptTemplate.Execute(f, d.Commands)
 
 	if err != nil {
-		logger.LogMap.Add(logId, "Error writing script: "+err.Error(), true)
-		return
+		return errors.New("Error writing script: " + err.Error())
 	}
 
 	// Run script using bash as a seperate contained process
@@ -117,20 +110,26 @@ func InitDeploy(logId string, d *DeployMeta) {
 	// to the system
 
 	// Create a new bash process
-	ctx := context.Background()
+	buildCtx := ctx
+
 	if d.Timeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(d.Timeout)*time.Second)
+		buildCtx, cancel = context.WithTimeout(ctx, time.Duration(d.Timeout)*time.Second)
 		defer cancel()
 	}
 
-	cmd := exec.CommandContext(ctx, "bash", buildDir+"/builder")
+	cmd := exec.CommandContext(buildCtx, "bash", buildDir+"/builder")
 	cmd.Dir = buildDir
 	cmd.Env = os.Environ()
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 
+	// Kill the whole process group on cancellation, not just bash
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
 	for k, v := range d.Env {
 		cmd.Env = append(cmd.Env, k+"="+v)
 	}
@@ -141,10 +140,11 @@ func InitDeploy(logId string, d *DeployMeta) {
 	err = cmd.Run()
 
 	if err != nil {
-		logger.LogMap.Add(logId, "Error running command: "+err.Error(), true)
-		return
+		return errors.New("Error running command: " + err.Error())
 	}
 
+	logger.LogMap.Step(logId, "Copying config files")
+
 	// Copy any potential config files to deploy folder
 	logger.LogMap.Add(logId, "Copying config files to new deploy", true)
 	for _, file := range d.ConfigFiles {
@@ -159,61 +159,48 @@ func InitDeploy(logId string, d *DeployMeta) {
 
 		newF, err := os.Create(buildDir + "/" + file)
 		if err != nil {
-			logger.LogMap.Add(logId, "Error creating config file: "+err.Error(), true)
-			return
+			return errors.New("Error creating config file: " + err.Error())
 		}
 		defer newF.Close()
 
 		_, err = newF.ReadFrom(f)
 
 		if err != nil {
-			logger.LogMap.Add(logId, "Error copying config file: "+err.Error(), true)
-			return
+			return errors.New("Error copying config file: " + err.Error())
 		}
 	}
 
-	breakpoint.Lock()
-	defer breakpoint.Unlock()
+	logger.LogMap.Step(logId, "Publishing release")
 
-	// Ensure output path exists first before continuing
-	err = os.MkdirAll(d.OutputPath, 0755)
+	// Ensure the parent of the output path exists first before continuing
+	err = os.MkdirAll(filepath.Dir(d.OutputPath), 0755)
 
 	if err != nil {
-		logger.LogMap.Add(logId, "Error validating service folder: "+err.Error(), true)
-		return
+		return errors.New("Error validating service folder: " + err.Error())
 	}
 
-	// Rename service folder
-	err = os.Rename(d.OutputPath, d.OutputPath+"-old")
+	breakpoint.Lock()
+	rel, err := publishRelease(logId, buildDir, d)
+	breakpoint.Unlock()
 
 	if err != nil {
-		logger.LogMap.Add(logId, "Error renaming service folder: "+err.Error(), true)
-		return
+		return err
 	}
 
-	// Move deploy folder to service folder
-	err = os.Rename(buildDir, d.OutputPath)
-
-	if err != nil {
-		logger.LogMap.Add(logId, "Error moving build directory: "+err.Error(), true)
-
-		// Move old service folder back
-		err = os.Rename(d.OutputPath+"-old", d.OutputPath)
+	logger.LogMap.Add(logId, "Published release "+rel.ID, true)
 
-		if err != nil {
-			logger.LogMap.Add(logId, "Error moving old service folder back: "+err.Error(), true)
-		}
-
-		return
-	}
-
-	// Remove old service folder
-	err = os.RemoveAll(d.OutputPath + "-old")
+	// The timeout of the deploy only covers the build, health checks have timeouts of their own
+	err = runPostDeploy(ctx, logId, d)
 
 	if err != nil {
-		logger.LogMap.Add(logId, "Error removing old service folder: "+err.Error(), true)
-		return
+		return rollbackAfter(logId, d, rel.Previous, err)
 	}
 
+	breakpoint.Lock()
+	pruneReleases(logId, d)
+	breakpoint.Unlock()
+
 	logger.LogMap.Add(logId, "Deploy finished on: "+time.Now().Format(time.RFC3339), true)
+
+	return nil
 }
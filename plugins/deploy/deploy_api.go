@@ -2,13 +2,25 @@ package deploy
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
 	"golang.org/x/exp/slices"
 )
 
 func loadDeployApi(r chi.Router) {
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost,
+		"/getDeployList",
+		"/getDeployMeta",
+		"/getDeployQueue",
+		"/getDeploySourceTypes",
+		"/getDeployWebhookSourceTypes",
+		"/listReleases",
+	)
+
 	r.Post("/getDeployMeta", func(w http.ResponseWriter, r *http.Request) {
 		id := r.URL.Query().Get("id")
 
@@ -18,7 +30,7 @@ func loadDeployApi(r chi.Router) {
 			return
 		}
 
-		cfg, err := LoadConfig(id)
+		cfg, err := LoadRawConfig(id)
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -110,14 +122,6 @@ func loadDeployApi(r chi.Router) {
 			return
 		}
 
-		token := r.URL.Query().Get("token")
-
-		if token == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("missing token"))
-			return
-		}
-
 		cfg, err := LoadConfig(id)
 
 		if err != nil {
@@ -156,7 +160,15 @@ func loadDeployApi(r chi.Router) {
 			return
 		}
 
-		logId, err := fn(cfg, wid, id, token)
+		logId, err := fn(r, cfg, wid, id)
+
+		var ignored *IgnoredError
+
+		if errors.As(err, &ignored) {
+			// Not a failure, forges would otherwise mark the webhook as broken
+			w.Write([]byte(ignored.Error()))
+			return
+		}
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -166,4 +178,94 @@ func loadDeployApi(r chi.Router) {
 
 		w.Write([]byte(logId))
 	})
+
+	r.Post("/listReleases", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing id"))
+			return
+		}
+
+		cfg, err := LoadRawConfig(id)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed to load config: " + err.Error()))
+			return
+		}
+
+		releases, err := ListReleases(cfg)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		jsonStr, err := json.Marshal(releases)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to encode releases."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonStr)
+	})
+
+	r.Post("/rollback", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing id"))
+			return
+		}
+
+		release := r.URL.Query().Get("release")
+
+		if release == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing release"))
+			return
+		}
+
+		cfg, err := LoadConfig(id)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed to load config: " + err.Error()))
+			return
+		}
+
+		t, err := tasks.New(ID, "rollback", plugins.UserID(r))
+
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		go tasks.Run(t.ID, func(logId string) error {
+			return RollbackDeploy(logId, cfg, release)
+		})
+
+		w.Write([]byte(t.ID))
+	})
+
+	r.Post("/getDeployQueue", func(w http.ResponseWriter, r *http.Request) {
+		jsonStr, err := json.Marshal(GetDeployQueue())
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to encode deploy queue."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonStr)
+	})
 }
@@ -0,0 +1,38 @@
+package deploy
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+)
+
+func TestScriptTemplate(t *testing.T) {
+	commands := []string{
+		`echo $(echo expanded)`,
+		`echo "it's" && echo '$HOME' > /dev/null`,
+		"echo `echo backticks`",
+	}
+
+	var script bytes.Buffer
+
+	err := ScriptTemplate.Execute(&script, commands)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("bash", "-c", script.String()).CombinedOutput()
+
+	if err != nil {
+		t.Fatalf("script failed: %v\n%s", err, out)
+	}
+
+	// Commands are printed as written and then run
+	want := "> echo $(echo expanded)\nexpanded\n" +
+		"> echo \"it's\" && echo '$HOME' > /dev/null\nit's\n" +
+		"> echo `echo backticks`\nbackticks\n"
+
+	if string(out) != want {
+		t.Fatalf("got output\n%s\nwant\n%s", out, want)
+	}
+}
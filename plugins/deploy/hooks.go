@@ -0,0 +1,191 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/plugins/systemd"
+)
+
+// Runs systemctl, swapped out in tests
+var systemctl = systemd.Systemctl
+
+// Restarts and reloads the systemd units of a deploy
+func restartUnits(logId string, d *DeployMeta) error {
+	if len(d.RestartUnits) > 0 {
+		logger.LogMap.Step(logId, "Restarting units")
+
+		err := systemctl(logId, append([]string{"restart"}, d.RestartUnits...)...)
+
+		if err != nil {
+			return errors.New("Error restarting units: " + err.Error())
+		}
+	}
+
+	if len(d.ReloadUnits) > 0 {
+		logger.LogMap.Step(logId, "Reloading units")
+
+		err := systemctl(logId, append([]string{"reload"}, d.ReloadUnits...)...)
+
+		if err != nil {
+			return errors.New("Error reloading units: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Runs the post deploy hooks once a release is live
+func runPostDeploy(ctx context.Context, logId string, d *DeployMeta) error {
+	err := restartUnits(logId, d)
+
+	if err != nil {
+		return err
+	}
+
+	if d.HealthCheck == nil {
+		return nil
+	}
+
+	logger.LogMap.Step(logId, "Checking health")
+
+	return d.HealthCheck.wait(ctx, logId, d)
+}
+
+// Makes the previous release live again after the post deploy hooks of a release failed with cause.
+// The returned error always wraps cause and says whether the previous release and its units came back
+func rollbackAfter(logId string, d *DeployMeta, previous string, cause error) error {
+	if previous == "" {
+		return errors.New(cause.Error() + ", there is no previous release to roll back to")
+	}
+
+	logger.LogMap.Step(logId, "Rolling back")
+
+	err := rollback(logId, d, previous)
+
+	if err != nil {
+		logger.LogMap.Add(logId, "ERROR: Rolling back to release "+previous+" failed: "+err.Error(), true)
+		return errors.New(cause.Error() + ", rolling back to release " + previous + " also failed: " + err.Error())
+	}
+
+	err = restartUnits(logId, d)
+
+	if err != nil {
+		logger.LogMap.Add(logId, "ERROR: Restarting the units of release "+previous+" failed: "+err.Error(), true)
+		return errors.New(cause.Error() + ", rolled back to release " + previous + " but its units did not come back: " + err.Error())
+	}
+
+	return errors.New(cause.Error() + ", rolled back to release " + previous)
+}
+
+// Makes a single health check attempt
+func (h *HealthCheck) check(ctx context.Context, logId string, d *DeployMeta) error {
+	timeout := h.Timeout
+
+	if timeout <= 0 {
+		timeout = 10
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	if h.Url != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.Url, nil)
+
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			return err
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return errors.New(h.Url + " returned " + resp.Status)
+		}
+	}
+
+	if h.Command != "" {
+		cmd := exec.CommandContext(ctx, "bash", "-c", h.Command)
+		cmd.Dir = d.OutputPath
+		cmd.Env = os.Environ()
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setpgid: true,
+		}
+
+		// Kill the whole process group on timeout, not just bash
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+
+		for k, v := range d.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+
+		cmd.Stdout = logger.AutoLogger{ID: logId}
+		cmd.Stderr = logger.AutoLogger{ID: logId, Error: true}
+
+		err := cmd.Run()
+
+		if err != nil {
+			return errors.New("command failed: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Retries the health check until it passes or runs out of retries
+func (h *HealthCheck) wait(ctx context.Context, logId string, d *DeployMeta) error {
+	if h.Url == "" && h.Command == "" {
+		return errors.New("health_check needs a url or command")
+	}
+
+	retries := h.Retries
+
+	if retries <= 0 {
+		retries = 5
+	}
+
+	interval := h.Interval
+
+	if interval <= 0 {
+		interval = 5
+	}
+
+	var err error
+
+	for i := 1; i <= retries; i++ {
+		err = h.check(ctx, logId, d)
+
+		if err == nil {
+			logger.LogMap.Add(logId, "Health check passed", true)
+			return nil
+		}
+
+		logger.LogMap.Add(logId, "Health check "+strconv.Itoa(i)+"/"+strconv.Itoa(retries)+" failed: "+err.Error(), true)
+
+		if i == retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("Health check cancelled: " + ctx.Err().Error())
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+	}
+
+	return errors.New("Health check failed: " + err.Error())
+}
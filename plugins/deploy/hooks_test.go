@@ -0,0 +1,130 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stubSystemctl(t *testing.T, err error) *[]string {
+	var calls []string
+
+	old := systemctl
+	systemctl = func(logId string, args ...string) error {
+		calls = append(calls, strings.Join(args, " "))
+		return err
+	}
+
+	t.Cleanup(func() {
+		systemctl = old
+	})
+
+	return &calls
+}
+
+func TestHealthCheckOutlivesBuildTimeout(t *testing.T) {
+	stubSystemctl(t, nil)
+
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "index.html"), []byte("hi"), 0644)
+
+	d := &DeployMeta{
+		Src:        &DeploySource{Type: "local", Url: src},
+		OutputPath: filepath.Join(t.TempDir(), "out"),
+		Commands:   []string{"sleep 1"},
+		Timeout:    2,
+		HealthCheck: &HealthCheck{
+			Command: "sleep 1.5",
+			Retries: 1,
+			Timeout: 5,
+		},
+	}
+
+	err := runDeploy(context.Background(), "test-healthcheck-"+time.Now().Format("150405.000"), d)
+
+	if err != nil {
+		t.Fatalf("got error %v, want the health check to pass after the build timeout", err)
+	}
+}
+
+func TestRollbackAfter(t *testing.T) {
+	cause := errors.New("Health check failed")
+
+	tests := []struct {
+		name     string
+		previous string
+		restart  error
+		err      string
+		live     string
+	}{
+		{
+			name: "no previous release",
+			err:  "Health check failed, there is no previous release to roll back to",
+			live: "new",
+		},
+		{
+			name:     "previous release is gone",
+			previous: "missing",
+			err:      "Health check failed, rolling back to release missing also failed: release missing not found",
+			live:     "new",
+		},
+		{
+			name:     "units do not restart",
+			previous: "old",
+			restart:  errors.New("exit status 1"),
+			err:      "Health check failed, rolled back to release old but its units did not come back: Error restarting units: exit status 1",
+			live:     "old",
+		},
+		{
+			name:     "rolled back",
+			previous: "old",
+			err:      "Health check failed, rolled back to release old",
+			live:     "old",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := stubSystemctl(t, tt.restart)
+
+			d := &DeployMeta{
+				OutputPath:   filepath.Join(t.TempDir(), "out"),
+				RestartUnits: []string{"app.service"},
+			}
+
+			for _, id := range []string{"old", "new"} {
+				os.MkdirAll(releasesPath(d)+"/"+id, 0755)
+
+				err := writeRelease(d, &Release{ID: id})
+
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := switchRelease(d, "new")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = rollbackAfter("test", d, tt.previous, cause)
+
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %s", err, tt.err)
+			}
+
+			if live := currentRelease(d); live != tt.live {
+				t.Fatalf("got live release %s, want %s", live, tt.live)
+			}
+
+			if tt.live == "old" && (len(*calls) != 1 || (*calls)[0] != "restart app.service") {
+				t.Fatalf("got systemctl calls %q, want a restart of app.service", *calls)
+			}
+		})
+	}
+}
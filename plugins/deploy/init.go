@@ -3,11 +3,11 @@ package deploy
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"sync"
 
 	"github.com/infinitybotlist/sysmanage-web/core/plugins"
-	"github.com/infinitybotlist/sysmanage-web/core/state"
 	"github.com/infinitybotlist/sysmanage-web/plugins/frontend"
 	"github.com/infinitybotlist/sysmanage-web/types"
 )
@@ -15,13 +15,32 @@ import (
 const ID = "deploy"
 
 var (
-	maxConcurrency = 1
-	breakpoint     sync.Mutex
-	builds         = map[string]*DeployStatus{}
+	// Guards deploy_config_path and switching releases
+	breakpoint sync.Mutex
 
 	deployConfigPath string
 )
 
+// Loads the deploy section of config.yaml
+func loadConfig(name string) error {
+	cfg, err := plugins.DecodeConfig[Config](name)
+
+	if err != nil {
+		return errors.New("Failed to get deploy config: " + err.Error())
+	}
+
+	setMaxConcurrency(cfg.MaxConcurrency)
+
+	maxDownloadSize.Store(cfg.MaxDownloadSize << 20)
+
+	breakpoint.Lock()
+	defer breakpoint.Unlock()
+
+	deployConfigPath = cfg.DeployConfigPath
+
+	return nil
+}
+
 func InitPlugin(c *types.PluginConfig) error {
 	// Register links
 	frontend.AddLink(c, frontend.Link{
@@ -31,25 +50,14 @@ func InitPlugin(c *types.PluginConfig) error {
 		Href:        "@root",
 	})
 
-	cfgData, err := plugins.GetConfig(c.Name)
-
-	if err != nil {
-		return errors.New("Failed to get deploy config: " + err.Error())
-	}
-
-	maxConcurrency, err = cfgData.GetInt("max_concurrency")
-
-	if err != nil {
-		return err
-	}
-
-	deployConfigPath, err = cfgData.GetString("deploy_config_path")
+	err := loadConfig(c.Name)
 
 	if err != nil {
 		return err
 	}
 
-	state.AuthExemptRoutes = append(state.AuthExemptRoutes, "/createDeploy")
+	// Webhooks authenticate themselves using the token of the webhook
+	plugins.AddExemptRoute(ID, http.MethodPost, "/createDeploy")
 
 	// Also, remove any old stale deploys here too
 	go func() {
@@ -68,3 +76,8 @@ func InitPlugin(c *types.PluginConfig) error {
 
 	return nil
 }
+
+// Applies a new max_concurrency and deploy_config_path, running deploys are not affected
+func Reload(c *types.PluginConfig) error {
+	return loadConfig(c.Name)
+}
@@ -0,0 +1,508 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+)
+
+// Manifests are small, anything larger is not a manifest
+const maxManifestSize = 4 << 20
+
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform"`
+}
+
+// An image manifest or, if Manifests is set, an image index
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// Somewhere images can be read from, either a registry or a OCI layout on disk
+type ociStore interface {
+	// Returns the manifest with the given tag or digest
+	manifest(ref string) ([]byte, error)
+	blob(digest string) (io.ReadCloser, error)
+}
+
+// Checks data against a sha256 digest
+func verifyDigest(digest string, data []byte) error {
+	h := sha256.Sum256(data)
+
+	if digest != "sha256:"+hex.EncodeToString(h[:]) {
+		return errors.New("digest mismatch for " + digest)
+	}
+
+	return nil
+}
+
+// Blob paths are built from digests, so they must be checked first
+func validDigest(digest string) bool {
+	hexDigest, ok := strings.CutPrefix(digest, "sha256:")
+
+	if !ok || len(hexDigest) != 64 {
+		return false
+	}
+
+	_, err := hex.DecodeString(hexDigest)
+
+	return err == nil
+}
+
+// A OCI image layout directory, e.g. one written by skopeo copy or docker buildx --output type=oci
+type ociLayout struct {
+	dir string
+}
+
+func (l ociLayout) blob(digest string) (io.ReadCloser, error) {
+	if !validDigest(digest) {
+		return nil, errors.New("unsupported digest " + digest)
+	}
+
+	return os.Open(filepath.Join(l.dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")))
+}
+
+func (l ociLayout) manifest(ref string) ([]byte, error) {
+	if strings.HasPrefix(ref, "sha256:") {
+		f, err := l.blob(ref)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		return io.ReadAll(io.LimitReader(f, maxManifestSize))
+	}
+
+	index, err := os.ReadFile(filepath.Join(l.dir, "index.json"))
+
+	if err != nil || ref == "" {
+		return index, err
+	}
+
+	var m ociManifest
+
+	err = json.Unmarshal(index, &m)
+
+	if err != nil {
+		return nil, errors.New("Failed to decode index.json: " + err.Error())
+	}
+
+	for _, desc := range m.Manifests {
+		if desc.Annotations["org.opencontainers.image.ref.name"] == ref {
+			return l.manifest(desc.Digest)
+		}
+	}
+
+	return nil, errors.New("tag " + ref + " not found in " + l.dir)
+}
+
+// A repository on a registry speaking the OCI distribution API
+type ociRegistry struct {
+	ctx   context.Context
+	base  string // E.g. https://ghcr.io/v2/org/app
+	auth  string // user:password or a bearer token
+	token string // Bearer token from the auth challenge
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Answers a WWW-Authenticate challenge, getting a bearer token for the repository
+func (r *ociRegistry) login(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+
+	user, pass, hasUser := strings.Cut(r.auth, ":")
+
+	if strings.EqualFold(scheme, "basic") {
+		if !hasUser {
+			return errors.New("registry requires a username and password")
+		}
+
+		r.token = ""
+		return nil
+	}
+
+	if !strings.EqualFold(scheme, "bearer") {
+		return errors.New("unsupported registry auth scheme " + scheme)
+	}
+
+	p := map[string]string{}
+
+	for _, m := range challengeParam.FindAllStringSubmatch(params, -1) {
+		p[m[1]] = m[2]
+	}
+
+	if p["realm"] == "" {
+		return errors.New("registry auth challenge has no realm")
+	}
+
+	q := url.Values{}
+
+	if p["service"] != "" {
+		q.Set("service", p["service"])
+	}
+
+	if p["scope"] != "" {
+		q.Set("scope", p["scope"])
+	}
+
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, p["realm"]+"?"+q.Encode(), nil)
+
+	if err != nil {
+		return err
+	}
+
+	if hasUser {
+		req.SetBasicAuth(user, pass)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return errors.New("Failed to get registry token: " + err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Failed to get registry token: " + resp.Status)
+	}
+
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&tok)
+
+	if err != nil {
+		return errors.New("Failed to decode registry token: " + err.Error())
+	}
+
+	r.token = tok.Token
+
+	if r.token == "" {
+		r.token = tok.AccessToken
+	}
+
+	return nil
+}
+
+// Makes a GET request to the repository, logging in and retrying once if the registry asks for it
+func (r *ociRegistry) get(path string, accept ...string) (*http.Response, error) {
+	for i := 0; ; i++ {
+		req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.base+path, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		} else if user, pass, ok := strings.Cut(r.auth, ":"); ok {
+			req.SetBasicAuth(user, pass)
+		} else if r.auth != "" {
+			req.Header.Set("Authorization", "Bearer "+r.auth)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && i == 0 {
+			resp.Body.Close()
+
+			err = r.login(resp.Header.Get("WWW-Authenticate"))
+
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New("registry returned " + resp.Status + " for " + path)
+		}
+
+		return resp, nil
+	}
+}
+
+func (r *ociRegistry) manifest(ref string) ([]byte, error) {
+	resp, err := r.get("/manifests/"+ref, manifestTypes...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+}
+
+func (r *ociRegistry) blob(digest string) (io.ReadCloser, error) {
+	if !validDigest(digest) {
+		return nil, errors.New("unsupported digest " + digest)
+	}
+
+	resp, err := r.get("/blobs/" + digest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Parses a image reference such as nginx, ghcr.io/org/app:v1 or localhost:5000/app@sha256:...
+// into the repository url and tag or digest. A http:// prefix uses plain HTTP
+func parseImageRef(image string) (base, ref string) {
+	scheme := "https"
+
+	if rest, ok := strings.CutPrefix(image, "http://"); ok {
+		scheme = "http"
+		image = rest
+	} else {
+		image = strings.TrimPrefix(image, "https://")
+	}
+
+	host, repo, ok := strings.Cut(image, "/")
+
+	// Docker Hub images have no registry host
+	if !ok || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host, repo = "registry-1.docker.io", image
+
+		if !strings.Contains(repo, "/") {
+			repo = "library/" + repo
+		}
+	}
+
+	ref = "latest"
+
+	if name, digest, ok := strings.Cut(repo, "@"); ok {
+		repo, ref = name, digest
+	} else if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, ref = repo[:i], repo[i+1:]
+	}
+
+	return scheme + "://" + host + "/v2/" + repo, ref
+}
+
+// Picks the manifest for this machine out of a image index. A index with a single manifest without
+// a platform (e.g. the index.json of a OCI layout) is not multi-platform, so that manifest is used
+func selectPlatform(manifests []ociDescriptor) (ociDescriptor, error) {
+	if len(manifests) == 1 && manifests[0].Platform == nil {
+		return manifests[0], nil
+	}
+
+	var available []string
+
+	for _, desc := range manifests {
+		if desc.Platform == nil {
+			continue
+		}
+
+		if desc.Platform.OS == "linux" && desc.Platform.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+
+		available = append(available, desc.Platform.OS+"/"+desc.Platform.Architecture)
+	}
+
+	return ociDescriptor{}, errors.New("image has no linux/" + runtime.GOARCH + " variant, available: " + strings.Join(available, ", "))
+}
+
+// Returns the image manifest for ref, picking the manifest for this machine out of image indexes
+func resolveManifest(logId string, store ociStore, ref string) (*ociManifest, error) {
+	for depth := 0; depth < 4; depth++ {
+		data, err := store.manifest(ref)
+
+		if err != nil {
+			return nil, errors.New("Failed to get manifest " + ref + ": " + err.Error())
+		}
+
+		if strings.HasPrefix(ref, "sha256:") {
+			err = verifyDigest(ref, data)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var m ociManifest
+
+		err = json.Unmarshal(data, &m)
+
+		if err != nil {
+			return nil, errors.New("Failed to decode manifest " + ref + ": " + err.Error())
+		}
+
+		if len(m.Manifests) == 0 {
+			return &m, nil
+		}
+
+		next, err := selectPlatform(m.Manifests)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if next.Platform != nil {
+			logger.LogMap.Add(logId, "Using "+next.Platform.OS+"/"+next.Platform.Architecture+" image "+next.Digest, true)
+		}
+
+		ref = next.Digest
+	}
+
+	return nil, errors.New("image index is nested too deeply")
+}
+
+// Downloads a layer, checks its digest and applies it on top of buildDir
+func applyLayer(logId, buildDir string, store ociStore, layer ociDescriptor) error {
+	if strings.Contains(layer.MediaType, "zstd") {
+		return errors.New("zstd compressed layers are not supported")
+	}
+
+	rc, err := store.blob(layer.Digest)
+
+	if err != nil {
+		return errors.New("Failed to get layer " + layer.Digest + ": " + err.Error())
+	}
+
+	defer rc.Close()
+
+	f, err := os.CreateTemp(filepath.Dir(buildDir), "layer-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+
+	err = copyDownload(io.MultiWriter(f, h, newProgressWriter(logger.AutoLogger{ID: logId}, "Downloaded", layer.Size)), rc, "layer "+layer.Digest, layer.Size)
+
+	if err != nil {
+		return errors.New("Failed to download layer " + layer.Digest + ": " + err.Error())
+	}
+
+	if layer.Digest != "sha256:"+hex.EncodeToString(h.Sum(nil)) {
+		return errors.New("digest mismatch for layer " + layer.Digest)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(f)
+
+	var r io.Reader = br
+
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+
+		if err != nil {
+			return errors.New("Failed to read layer " + layer.Digest + ": " + err.Error())
+		}
+
+		defer gz.Close()
+
+		r = gz
+	}
+
+	return unpackTar(r, buildDir, 0, true)
+}
+
+// Deploys the filesystem of a container image. The url is either an OCI layout directory or
+// an image reference on a registry, ref overrides the tag or digest of the image and the
+// token is a user:password or bearer token for the registry
+func ociSource(logId, buildDir string, d *DeployMeta) error {
+	var store ociStore
+	var ref string
+
+	if _, err := os.Stat(filepath.Join(d.Src.Url, "oci-layout")); err == nil {
+		store = ociLayout{dir: d.Src.Url}
+	} else {
+		var base string
+
+		base, ref = parseImageRef(d.Src.Url)
+
+		store = &ociRegistry{
+			ctx:  tasks.Context(logId),
+			base: base,
+			auth: d.Src.Token,
+		}
+	}
+
+	if d.Src.Ref != "" {
+		ref = d.Src.Ref
+	}
+
+	logger.LogMap.Add(logId, "Resolving image "+d.Src.Url, true)
+
+	m, err := resolveManifest(logId, store, ref)
+
+	if err != nil {
+		return err
+	}
+
+	if len(m.Layers) == 0 {
+		return errors.New("image has no layers")
+	}
+
+	for i, layer := range m.Layers {
+		logger.LogMap.Add(logId, "Unpacking layer "+strconv.Itoa(i+1)+"/"+strconv.Itoa(len(m.Layers))+" "+layer.Digest, true)
+
+		err = applyLayer(logId, buildDir, store, layer)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package deploy
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// An image built in memory, servable as a OCI layout or from a registry
+type testImage struct {
+	blobs map[string][]byte
+	tags  map[string]string
+}
+
+func (img *testImage) add(b []byte) ociDescriptor {
+	digest := sha256Digest(b)
+	img.blobs[digest] = b
+	return ociDescriptor{Digest: digest, Size: int64(len(b))}
+}
+
+func (img *testImage) addJSON(t *testing.T, mediaType string, v any) ociDescriptor {
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := img.add(b)
+	desc.MediaType = mediaType
+	return desc
+}
+
+// Builds a image with a gzip layer and a plain layer removing files from it, tagged v1. If
+// arch is set, v1 is a index with that platform
+func newTestImage(t *testing.T, arch string) *testImage {
+	img := &testImage{blobs: map[string][]byte{}, tags: map[string]string{}}
+
+	base := img.add(makeTar(t, true,
+		tarEntry{name: "app/index.html", body: "v1"},
+		tarEntry{name: "app/gone", body: "x"},
+		tarEntry{name: "cache/", typ: tar.TypeDir},
+		tarEntry{name: "cache/old", body: "x"},
+	))
+	base.MediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	top := img.add(makeTar(t, false,
+		tarEntry{name: "app/.wh.gone"},
+		tarEntry{name: "cache/.wh..wh..opq"},
+		tarEntry{name: "cache/new", body: "y"},
+	))
+	top.MediaType = "application/vnd.oci.image.layer.v1.tar"
+
+	manifest := img.addJSON(t, "application/vnd.oci.image.manifest.v1+json", ociManifest{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Layers:    []ociDescriptor{base, top},
+	})
+
+	if arch != "" {
+		manifest.Platform = &struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		}{Architecture: arch, OS: "linux"}
+
+		manifest = img.addJSON(t, "application/vnd.oci.image.index.v1+json", ociManifest{
+			MediaType: "application/vnd.oci.image.index.v1+json",
+			Manifests: []ociDescriptor{manifest},
+		})
+	}
+
+	img.tags["v1"] = manifest.Digest
+
+	return img
+}
+
+// Writes the image as a OCI layout directory
+func (img *testImage) layout(t *testing.T) string {
+	dir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755)
+	os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+
+	var index ociManifest
+
+	for digest, b := range img.blobs {
+		os.WriteFile(filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")), b, 0644)
+	}
+
+	for tag, digest := range img.tags {
+		index.Manifests = append(index.Manifests, ociDescriptor{
+			Digest:      digest,
+			Annotations: map[string]string{"org.opencontainers.image.ref.name": tag},
+		})
+	}
+
+	b, _ := json.Marshal(index)
+	os.WriteFile(filepath.Join(dir, "index.json"), b, 0644)
+
+	return dir
+}
+
+// Serves the image as app on a registry requiring a bearer token for user:pw
+func (img *testImage) registry(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pw" || r.URL.Query().Get("scope") != "repository:app:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			w.Write([]byte(`{"token":"registry-token"}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="test",scope="repository:app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if ref, ok := strings.CutPrefix(r.URL.Path, "/v2/app/manifests/"); ok {
+			if digest, ok := img.tags[ref]; ok {
+				ref = digest
+			}
+
+			b, ok := img.blobs[ref]
+
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Write(b)
+			return
+		}
+
+		if digest, ok := strings.CutPrefix(r.URL.Path, "/v2/app/blobs/"); ok {
+			b, ok := img.blobs[digest]
+
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Write(b)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// The files of a image from newTestImage once both layers are applied
+const testImageFiles = "app/index.html=v1 cache/new=y"
+
+func TestOCISource(t *testing.T) {
+	img := newTestImage(t, "")
+	multiArch := newTestImage(t, runtime.GOARCH)
+	otherArch := newTestImage(t, "unknownarch")
+
+	// A layer blob that does not match its digest
+	corrupt := newTestImage(t, "")
+
+	for digest, b := range corrupt.blobs {
+		if !strings.HasPrefix(string(b), "{") {
+			corrupt.blobs[digest] = append([]byte{}, b[:len(b)-1]...)
+			break
+		}
+	}
+
+	srv := img.registry(t)
+	regURL := "http://" + strings.TrimPrefix(srv.URL, "http://") + "/app"
+
+	tests := []struct {
+		name  string
+		src   DeploySource
+		limit int64
+		files string
+		err   string
+	}{
+		{
+			name:  "layout",
+			src:   DeploySource{Url: img.layout(t), Ref: "v1"},
+			files: testImageFiles,
+		},
+		{
+			name:  "layout by digest",
+			src:   DeploySource{Url: img.layout(t), Ref: img.tags["v1"]},
+			files: testImageFiles,
+		},
+		{
+			name:  "layout without ref",
+			src:   DeploySource{Url: img.layout(t)},
+			files: testImageFiles,
+		},
+		{
+			name:  "multi-platform index",
+			src:   DeploySource{Url: multiArch.layout(t), Ref: "v1"},
+			files: testImageFiles,
+		},
+		{
+			name: "no matching platform",
+			src:  DeploySource{Url: otherArch.layout(t), Ref: "v1"},
+			err:  "image has no linux/" + runtime.GOARCH + " variant, available: linux/unknownarch",
+		},
+		{
+			name: "missing tag",
+			src:  DeploySource{Url: img.layout(t), Ref: "v2"},
+			err:  "tag v2 not found",
+		},
+		{
+			name: "layer digest mismatch",
+			src:  DeploySource{Url: corrupt.layout(t), Ref: "v1"},
+			err:  "digest mismatch",
+		},
+		{
+			name:  "layer larger than max_download_size",
+			src:   DeploySource{Url: img.layout(t), Ref: "v1"},
+			limit: 16,
+			err:   "larger than max_download_size",
+		},
+		{
+			name:  "registry",
+			src:   DeploySource{Url: regURL + ":v1", Token: "user:pw"},
+			files: testImageFiles,
+		},
+		{
+			name:  "registry with ref",
+			src:   DeploySource{Url: regURL, Ref: "v1", Token: "user:pw"},
+			files: testImageFiles,
+		},
+		{
+			name: "registry with wrong credentials",
+			src:  DeploySource{Url: regURL + ":v1", Token: "user:wrong"},
+			err:  "Failed to get registry token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.limit != 0 {
+				setDownloadLimit(t, tt.limit)
+			}
+
+			tt.src.Type = "oci"
+
+			buildDir, err := loadSource(t, &tt.src)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := listFiles(t, buildDir); got != tt.files {
+				t.Fatalf("got files %q, want %q", got, tt.files)
+			}
+		})
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image, base, ref string
+	}{
+		{"nginx", "https://registry-1.docker.io/v2/library/nginx", "latest"},
+		{"org/app:1.2", "https://registry-1.docker.io/v2/org/app", "1.2"},
+		{"ghcr.io/org/app:v1", "https://ghcr.io/v2/org/app", "v1"},
+		{"localhost:5000/app", "https://localhost:5000/v2/app", "latest"},
+		{"http://localhost:5000/app@sha256:abc", "http://localhost:5000/v2/app", "sha256:abc"},
+	}
+
+	for _, tt := range tests {
+		base, ref := parseImageRef(tt.image)
+
+		if base != tt.base || ref != tt.ref {
+			t.Errorf("parseImageRef(%q) = %q, %q, want %q, %q", tt.image, base, ref, tt.base, tt.ref)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+)
+
+type queueEntry struct {
+	QueuedDeploy
+	ready chan struct{} // Closed once the deploy may run
+}
+
+// Deploys run in the order they were queued, at most max_concurrency at once and never
+// two of the same deploy at once
+var queue = struct {
+	sync.Mutex
+	max     int
+	waiting []*queueEntry
+	running []*queueEntry
+	changed chan struct{} // Closed and replaced whenever the queue changes
+}{
+	max:     1,
+	changed: make(chan struct{}),
+}
+
+// Returns the key deploys are serialised by
+func queueKey(d *DeployMeta) string {
+	if d.ID != "" {
+		return d.ID
+	}
+
+	return d.OutputPath
+}
+
+// Starts as many waiting deploys as possible. Callers must hold queue
+func dispatch() {
+	waiting := queue.waiting[:0]
+
+	for _, e := range queue.waiting {
+		if len(queue.running) < queue.max && !isRunning(e.DeployID) {
+			now := time.Now()
+			e.StartedAt = &now
+
+			queue.running = append(queue.running, e)
+			close(e.ready)
+			continue
+		}
+
+		waiting = append(waiting, e)
+	}
+
+	queue.waiting = waiting
+
+	close(queue.changed)
+	queue.changed = make(chan struct{})
+}
+
+// Returns whether a deploy with the given id is running. Callers must hold queue
+func isRunning(deployId string) bool {
+	for _, e := range queue.running {
+		if e.DeployID == deployId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Removes the deploy from the queue. Callers must hold queue
+func (e *queueEntry) remove() {
+	for i, w := range queue.waiting {
+		if w == e {
+			queue.waiting = append(queue.waiting[:i], queue.waiting[i+1:]...)
+			break
+		}
+	}
+
+	for i, r := range queue.running {
+		if r == e {
+			queue.running = append(queue.running[:i], queue.running[i+1:]...)
+			break
+		}
+	}
+}
+
+// Returns the 1-based position of a waiting deploy, 0 if it is not waiting. Callers must hold queue
+func position(e *queueEntry) int {
+	for i, w := range queue.waiting {
+		if w == e {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+func setMaxConcurrency(max int) {
+	queue.Lock()
+	defer queue.Unlock()
+
+	queue.max = max
+
+	dispatch()
+}
+
+// Waits for a deploy slot, logging the position of the deploy in the queue to logId.
+// The returned function must be called to free the slot once the deploy is done
+func enqueue(ctx context.Context, logId string, d *DeployMeta) (func(), error) {
+	e := &queueEntry{
+		QueuedDeploy: QueuedDeploy{
+			LogID:    logId,
+			DeployID: queueKey(d),
+			QueuedAt: time.Now(),
+		},
+		ready: make(chan struct{}),
+	}
+
+	if d.Src != nil {
+		e.Source = d.Src.String()
+	}
+
+	if t, ok := tasks.Get(logId); ok {
+		e.UserID = t.UserID
+	}
+
+	release := func() {
+		queue.Lock()
+		defer queue.Unlock()
+
+		e.remove()
+		dispatch()
+	}
+
+	queue.Lock()
+	queue.waiting = append(queue.waiting, e)
+	dispatch()
+	queue.Unlock()
+
+	lastPos := 0
+
+	for {
+		queue.Lock()
+		pos := position(e)
+		changed := queue.changed
+		queue.Unlock()
+
+		if pos == 0 {
+			// Dispatched
+			<-e.ready
+			return release, nil
+		}
+
+		if pos != lastPos {
+			logger.LogMap.Add(logId, "Position in deploy queue: "+strconv.Itoa(pos), true)
+			lastPos = pos
+		}
+
+		select {
+		case <-ctx.Done():
+			// The deploy may have been dispatched in the meantime, so it is removed from both lists
+			release()
+
+			return nil, errors.New("FATAL: Deploy cancelled while waiting in the deploy queue")
+		case <-changed:
+		}
+	}
+}
+
+// Returns the running deploys followed by the waiting ones in queue order
+func GetDeployQueue() []QueuedDeploy {
+	queue.Lock()
+	defer queue.Unlock()
+
+	list := make([]QueuedDeploy, 0, len(queue.running)+len(queue.waiting))
+
+	for _, e := range queue.running {
+		list = append(list, e.QueuedDeploy)
+	}
+
+	for i, e := range queue.waiting {
+		q := e.QueuedDeploy
+		q.Position = i + 1
+		list = append(list, q)
+	}
+
+	return list
+}
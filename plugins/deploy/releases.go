@@ -0,0 +1,361 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+)
+
+const defaultKeepReleases = 5
+
+func releasesPath(d *DeployMeta) string {
+	if d.ReleasesPath != "" {
+		return d.ReleasesPath
+	}
+
+	return d.OutputPath + ".releases"
+}
+
+// Metadata of a release is stored next to it so that it does not end up in the output path
+func releaseMetaPath(d *DeployMeta, id string) string {
+	return releasesPath(d) + "/" + id + ".json"
+}
+
+// Returns a release id, these sort by creation time
+func newReleaseId(t time.Time, suffix string) string {
+	return t.UTC().Format("20060102-150405") + "-" + suffix
+}
+
+// Returns the commit a build was made from, empty if the source is not a git repository
+func sourceCommit(buildDir string) string {
+	repo, err := git.PlainOpen(buildDir)
+
+	if err != nil {
+		return ""
+	}
+
+	head, err := repo.Head()
+
+	if err != nil {
+		return ""
+	}
+
+	return head.Hash().String()
+}
+
+// Returns the id of the release output_path points to, empty if it does not point to a release
+func currentRelease(d *DeployMeta) string {
+	target, err := os.Readlink(d.OutputPath)
+
+	if err != nil {
+		return ""
+	}
+
+	dir, err := filepath.Abs(releasesPath(d))
+
+	if err != nil || filepath.Dir(target) != dir {
+		return ""
+	}
+
+	return filepath.Base(target)
+}
+
+func writeRelease(d *DeployMeta, rel *Release) error {
+	bytes, err := json.MarshalIndent(rel, "", "\t")
+
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(releaseMetaPath(d, rel.ID), bytes, 0644)
+
+	if err != nil {
+		return errors.New("Failed to write release metadata: " + err.Error())
+	}
+
+	return nil
+}
+
+// Returns the releases of a deploy, newest first
+func ListReleases(d *DeployMeta) ([]*Release, error) {
+	fsd, err := os.ReadDir(releasesPath(d))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Release{}, nil
+	}
+
+	if err != nil {
+		return nil, errors.New("Failed to read releases: " + err.Error())
+	}
+
+	current := currentRelease(d)
+
+	releases := []*Release{}
+
+	for _, file := range fsd {
+		id, ok := strings.CutSuffix(file.Name(), ".json")
+
+		if !ok || file.IsDir() {
+			continue
+		}
+
+		// Skip metadata of releases that were removed by hand
+		if st, err := os.Stat(releasesPath(d) + "/" + id); err != nil || !st.IsDir() {
+			continue
+		}
+
+		bytes, err := os.ReadFile(releaseMetaPath(d, id))
+
+		if err != nil {
+			return nil, errors.New("Failed to read release " + id + ": " + err.Error())
+		}
+
+		var rel *Release
+
+		err = json.Unmarshal(bytes, &rel)
+
+		if err != nil {
+			return nil, errors.New("Failed to decode release " + id + ": " + err.Error())
+		}
+
+		rel.ID = id
+		rel.Current = id == current
+
+		releases = append(releases, rel)
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].CreatedAt.After(releases[j].CreatedAt)
+	})
+
+	return releases, nil
+}
+
+// Atomically points output_path at a release by renaming a new symlink over it
+func switchRelease(d *DeployMeta, id string) error {
+	target, err := filepath.Abs(releasesPath(d) + "/" + id)
+
+	if err != nil {
+		return err
+	}
+
+	tmp := d.OutputPath + ".tmp-" + id
+
+	os.Remove(tmp)
+
+	err = os.Symlink(target, tmp)
+
+	if err != nil {
+		return errors.New("Failed to create symlink: " + err.Error())
+	}
+
+	err = os.Rename(tmp, d.OutputPath)
+
+	if err != nil {
+		os.Remove(tmp)
+		return errors.New("Failed to switch output path to release " + id + ": " + err.Error())
+	}
+
+	return nil
+}
+
+// Moves an output_path deployed before releases were kept into a release, so that it can be rolled back to
+func adoptOutput(logId string, d *DeployMeta) error {
+	st, err := os.Lstat(d.OutputPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return errors.New("Failed to stat output path: " + err.Error())
+	}
+
+	if st.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	if !st.IsDir() {
+		return errors.New("output path " + d.OutputPath + " is not a directory")
+	}
+
+	rel := &Release{
+		ID:        newReleaseId(st.ModTime(), "initial"),
+		Source:    "existing output path",
+		CreatedAt: st.ModTime(),
+	}
+
+	logger.LogMap.Add(logId, "Keeping existing output path as release "+rel.ID, true)
+
+	err = os.Rename(d.OutputPath, releasesPath(d)+"/"+rel.ID)
+
+	if err != nil {
+		return errors.New("Failed to move output path into releases: " + err.Error())
+	}
+
+	err = writeRelease(d, rel)
+
+	if err != nil {
+		return err
+	}
+
+	return switchRelease(d, rel.ID)
+}
+
+// Removes the oldest releases over keep_releases, the live release is always kept
+func pruneReleases(logId string, d *DeployMeta) {
+	keep := d.KeepReleases
+
+	if keep <= 0 {
+		keep = defaultKeepReleases
+	}
+
+	releases, err := ListReleases(d)
+
+	if err != nil {
+		logger.LogMap.Add(logId, "WARNING: Could not prune releases: "+err.Error(), true)
+		return
+	}
+
+	for i, rel := range releases {
+		if i < keep || rel.Current {
+			continue
+		}
+
+		logger.LogMap.Add(logId, "Removing old release "+rel.ID, true)
+
+		err = os.RemoveAll(releasesPath(d) + "/" + rel.ID)
+
+		if err != nil {
+			logger.LogMap.Add(logId, "WARNING: Could not remove release "+rel.ID+": "+err.Error(), true)
+			continue
+		}
+
+		os.Remove(releaseMetaPath(d, rel.ID))
+	}
+}
+
+// Moves a finished build into a new release and makes it the live one. Old releases are only pruned
+// once the post deploy hooks pass, so the previous release can still be rolled back to. Callers must hold breakpoint
+func publishRelease(logId, buildDir string, d *DeployMeta) (*Release, error) {
+	err := os.MkdirAll(releasesPath(d), 0755)
+
+	if err != nil {
+		return nil, errors.New("Error creating releases folder: " + err.Error())
+	}
+
+	err = adoptOutput(logId, d)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	suffix := logId
+
+	if len(suffix) > 8 {
+		suffix = suffix[:8]
+	}
+
+	rel := &Release{
+		ID:        newReleaseId(now, suffix),
+		Commit:    sourceCommit(buildDir),
+		Source:    d.Src.String(),
+		CreatedAt: now,
+		LogID:     logId,
+		Previous:  currentRelease(d),
+	}
+
+	if t, ok := tasks.Get(logId); ok {
+		rel.UserID = t.UserID
+	}
+
+	err = os.Rename(buildDir, releasesPath(d)+"/"+rel.ID)
+
+	if err != nil {
+		return nil, errors.New("Error moving build directory: " + err.Error())
+	}
+
+	err = writeRelease(d, rel)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = switchRelease(d, rel.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rel, nil
+}
+
+// Points output_path back at a older release of a deploy and runs the post deploy hooks, logging to logId
+func RollbackDeploy(logId string, d *DeployMeta, releaseId string) error {
+	if releaseId == "" || filepath.Base(releaseId) != releaseId {
+		return errors.New("invalid release id")
+	}
+
+	// Never roll back while the deploy is running
+	release, err := enqueue(tasks.Context(logId), logId, d)
+
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	err = rollback(logId, d, releaseId)
+
+	if err != nil {
+		return err
+	}
+
+	err = runPostDeploy(tasks.Context(logId), logId, d)
+
+	if err != nil {
+		return err
+	}
+
+	logger.LogMap.Add(logId, "Rollback finished on: "+time.Now().Format(time.RFC3339), true)
+
+	return nil
+}
+
+// Makes a existing release live again
+func rollback(logId string, d *DeployMeta, releaseId string) error {
+	breakpoint.Lock()
+	defer breakpoint.Unlock()
+
+	releases, err := ListReleases(d)
+
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range releases {
+		if rel.ID != releaseId {
+			continue
+		}
+
+		if rel.Current {
+			return errors.New("release " + releaseId + " is already live")
+		}
+
+		logger.LogMap.Add(logId, "Rolling back "+d.OutputPath+" to release "+releaseId, true)
+
+		return switchRelease(d, releaseId)
+	}
+
+	return errors.New("release " + releaseId + " not found")
+}
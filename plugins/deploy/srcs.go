@@ -1,13 +1,16 @@
 package deploy
 
 import (
+	"crypto/subtle"
 	"errors"
+	"net/http"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
-	"github.com/infinitybotlist/eureka/crypto"
 	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
 )
 
 // A deploy source is a public API provided for use by other sysmanage plugins
@@ -17,8 +20,12 @@ import (
 // E.g: func(logId, buildDir string, d *DeployMeta) error
 var DeploySources = map[string]func(logId, buildDir string, d *DeployMeta) error{
 	"git": func(logId, buildDir string, d *DeployMeta) error {
+		if strings.ContainsAny(d.Src.Ref, "*?[") {
+			return errors.New("ref " + d.Src.Ref + " is a glob, globs belong in the ref of a webhook")
+		}
+
 		logger.LogMap.Add(logId, "Cloning "+d.Src.Url, true)
-		_, err := git.PlainClone(buildDir, false, &git.CloneOptions{
+		repo, err := git.PlainClone(buildDir, false, &git.CloneOptions{
 			URL: d.Src.Url,
 			Auth: &githttp.BasicAuth{
 				Username: d.Src.Token,
@@ -32,20 +39,46 @@ var DeploySources = map[string]func(logId, buildDir string, d *DeployMeta) error
 			return err
 		}
 
-		return nil
+		if d.Commit == "" {
+			return nil
+		}
+
+		logger.LogMap.Add(logId, "Checking out "+d.Commit, true)
+
+		wt, err := repo.Worktree()
+
+		if err != nil {
+			return err
+		}
+
+		return wt.Checkout(&git.CheckoutOptions{
+			Hash: plumbing.NewHash(d.Commit),
+		})
 	},
+	"archive": archiveSource,
+	"local":   localSource,
+	"oci":     ociSource,
 }
 
 // Public API to allow plugins to define custom webhook sources
-var DeployWebhookSources = map[string]func(cfg *DeployMeta, wid, id, token string) (logId string, err error){
-	"api": func(cfg *DeployMeta, wid, id, token string) (logId string, err error) {
+//
+// Sources are given the webhook request to verify it with, wid is the id of the webhook and id the id of the deploy.
+// Return a *IgnoredError for valid webhooks that should not start a deploy
+var DeployWebhookSources = map[string]func(r *http.Request, cfg *DeployMeta, wid, id string) (logId string, err error){
+	"api": func(r *http.Request, cfg *DeployMeta, wid, id string) (logId string, err error) {
+		token := r.URL.Query().Get("token")
+
+		if token == "" {
+			return "", errors.New("missing token")
+		}
+
 		var flag bool
 		for _, webh := range cfg.Webhooks {
 			if webh.Type != "api" {
 				continue
 			}
 
-			if wid == webh.Id && webh.Token == token {
+			if wid == webh.Id && subtle.ConstantTimeCompare([]byte(webh.Token), []byte(token)) == 1 {
 				flag = true
 				break
 			}
@@ -55,10 +88,24 @@ var DeployWebhookSources = map[string]func(cfg *DeployMeta, wid, id, token strin
 			return "", errors.New("invalid token")
 		}
 
-		reqId := crypto.RandString(64)
+		t, err := tasks.New(ID, "deploy", "webhook:"+wid)
+
+		if err != nil {
+			return "", err
+		}
 
-		go InitDeploy(reqId, cfg)
+		go InitDeploy(t.ID, cfg)
 
-		return reqId, nil
+		return t.ID, nil
 	},
+	"github": gitWebhookSource("github", "X-GitHub-Event", func(secret string, r *http.Request, body []byte) bool {
+		sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		return ok && validSignature(secret, sig, body)
+	}),
+	"gitlab": gitWebhookSource("gitlab", "X-Gitlab-Event", func(secret string, r *http.Request, body []byte) bool {
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+	}),
+	"gitea": gitWebhookSource("gitea", "X-Gitea-Event", func(secret string, r *http.Request, body []byte) bool {
+		return validSignature(secret, r.Header.Get("X-Gitea-Signature"), body)
+	}),
 }
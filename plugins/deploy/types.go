@@ -2,28 +2,62 @@ package deploy
 
 import "time"
 
+// The deploy section of config.yaml
+type Config struct {
+	MaxConcurrency   int    `yaml:"max_concurrency" default:"1" validate:"gte=1"`
+	DeployConfigPath string `yaml:"deploy_config_path" validate:"required"`
+	MaxDownloadSize  int64  `yaml:"max_download_size" default:"2048" validate:"gte=1"` // Largest archive or image layer sources may download, in MB
+}
+
 type DeployMetaListItem struct {
 	ID   string
 	Meta *DeployMeta
 }
 
 type DeployMeta struct {
+	ID          string            `yaml:"-"` // Set by LoadConfig, deploys with the same id never run at once
 	AllowedIps  []string          `yaml:"allowed_ips"`
 	Src         *DeploySource     `yaml:"src"`
 	Broken      bool              `yaml:"broken"`
 	OutputPath  string            `yaml:"output_path"`
 	Commands    []string          `yaml:"commands"`
 	Webhooks    []*DeployWebhook  `yaml:"webhooks"`
-	Timeout     int               `yaml:"timeout"`
+	Timeout     int               `yaml:"timeout"` // Seconds the build commands may take, health checks use their own timeout
 	Env         map[string]string `yaml:"env"`
 	ConfigFiles []string          `yaml:"config_files"`
+
+	// Builds are kept as releases in this directory (defaults to <output_path>.releases) and
+	// output_path is a symlink to the live one. Must be on the same filesystem as /tmp/deploys
+	ReleasesPath string `yaml:"releases_path"`
+	KeepReleases int    `yaml:"keep_releases"` // Number of releases to keep, defaults to 5
+
+	// Run once a release is live. If any of these fail, the previous release is made live again
+	RestartUnits []string     `yaml:"restart_units"` // Systemd units to restart
+	ReloadUnits  []string     `yaml:"reload_units"`  // Systemd units to reload
+	HealthCheck  *HealthCheck `yaml:"health_check"`
+
+	// Set by webhooks to deploy the pushed commit rather than the head of the ref
+	Commit string `yaml:"-"`
+}
+
+// Checks that a deploy is healthy, if both a url and a command are set both must pass
+type HealthCheck struct {
+	Url      string `yaml:"url"`      // Healthy if a GET request returns a 2xx status
+	Command  string `yaml:"command"`  // Healthy if the command exits with 0, run with bash in output_path
+	Retries  int    `yaml:"retries"`  // Attempts before the deploy is considered unhealthy, defaults to 5
+	Interval int    `yaml:"interval"` // Seconds between attempts, defaults to 5
+	Timeout  int    `yaml:"timeout"`  // Seconds a attempt may take, defaults to 10
 }
 
 type DeploySource struct {
 	Type  string `yaml:"type"`
 	Url   string `yaml:"url"`
 	Token string `yaml:"token"`
-	Ref   string `yaml:"ref"`
+	Ref   string `yaml:"ref"` // The branch, tag or (for oci sources) image tag or digest to deploy, use the ref of a webhook to match several
+
+	// Archive sources are checked against this sha256:<hex> checksum if set
+	Checksum        string `yaml:"checksum"`
+	StripComponents int    `yaml:"strip_components"` // Leading path components to strip from archive entries
 }
 
 func (d DeploySource) String() string {
@@ -32,15 +66,36 @@ func (d DeploySource) String() string {
 
 type DeployWebhook struct {
 	Id    string `yaml:"id"`
-	Token string `yaml:"token"`
+	Token string `yaml:"token"` // The token of api webhooks, or the secret of github, gitlab and gitea webhooks
 	Type  string `yaml:"type"`
+
+	// Events that start a deploy for github, gitlab and gitea webhooks, defaults to push and tag_push
+	Events []string `yaml:"events"`
+
+	// Pushes to refs matching this glob (e.g. refs/tags/v*) start a deploy of the pushed ref and commit.
+	// Defaults to the ref of the source, or the default branch if the source has no ref
+	Ref string `yaml:"ref"`
 }
 
-type DeployStatus struct {
-	Source    *DeploySource
-	CreatedAt time.Time
+// A deploy in the deploy queue
+type QueuedDeploy struct {
+	LogID     string
+	DeployID  string
+	Source    string
+	UserID    string
+	QueuedAt  time.Time
+	StartedAt *time.Time // Set once the deploy is running
+	Position  int        // Position in the queue, 0 once the deploy is running
 }
 
-func (d DeployStatus) String() string {
-	return d.Source.String() + " - " + d.CreatedAt.Format(time.RFC3339) + " (" + time.Since(d.CreatedAt).String() + ")"
+// A build of a deploy, kept in the releases directory so that it can be rolled back to
+type Release struct {
+	ID        string
+	Commit    string // Commit the release was built from, if the source is a git repository
+	Source    string
+	CreatedAt time.Time
+	UserID    string // The user that started the deploy
+	LogID     string // The task the release was built in
+	Previous  string // The release that was live before this one, if any
+	Current   bool   // Whether output_path points to this release, set by ListReleases
 }
@@ -0,0 +1,192 @@
+package deploy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+	"golang.org/x/exp/slices"
+)
+
+// GitHub caps webhook payloads at 25 MB
+const maxWebhookBody = 25 << 20
+
+// Events that start a deploy if a webhook has no events set
+var defaultWebhookEvents = []string{"push", "tag_push"}
+
+// Returned by webhook sources for valid webhooks that should not start a deploy, e.g. a push to another branch
+type IgnoredError struct {
+	Reason string
+}
+
+func (e *IgnoredError) Error() string {
+	return "ignored: " + e.Reason
+}
+
+// The fields of a push event shared by GitHub, GitLab and Gitea
+type pushEvent struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"` // The pushed commit, all zeros if the ref was deleted
+	Repository struct {
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Project struct {
+		DefaultBranch string `json:"default_branch"`
+	} `json:"project"` // GitLab
+}
+
+// Checks a hex encoded HMAC-SHA256 of the body
+func validSignature(secret, sig string, body []byte) bool {
+	got, err := hex.DecodeString(sig)
+
+	if err != nil || len(got) == 0 {
+		return false
+	}
+
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+
+	return hmac.Equal(got, h.Sum(nil))
+}
+
+// Normalises event names, e.g. GitLab's "Tag Push Hook" becomes tag_push
+func normaliseEvent(event string) string {
+	event = strings.TrimSuffix(event, " Hook")
+	return strings.ReplaceAll(strings.ToLower(event), " ", "_")
+}
+
+// Checks that a push should be deployed by a webhook, setting the ref and commit to deploy for git sources.
+// Returns a *IgnoredError for pushes to other refs
+func applyPush(webh *DeployWebhook, cfg *DeployMeta, event string, push pushEvent) error {
+	if push.Ref == "" || push.After == "" {
+		return &IgnoredError{Reason: event + " event has no ref or commit"}
+	}
+
+	if strings.Trim(push.After, "0") == "" {
+		return &IgnoredError{Reason: push.Ref + " was deleted"}
+	}
+
+	if cfg.Src == nil {
+		return errors.New("deploy does not have an associated source setup")
+	}
+
+	// Without a ref, only pushes to the default branch are deployed
+	want := webh.Ref
+
+	if want == "" {
+		want = cfg.Src.Ref
+	}
+
+	if want == "" {
+		branch := push.Repository.DefaultBranch
+
+		if branch == "" {
+			branch = push.Project.DefaultBranch
+		}
+
+		if branch == "" {
+			return &IgnoredError{Reason: "no ref is set and the default branch is unknown"}
+		}
+
+		want = "refs/heads/" + branch
+	}
+
+	if ok, _ := path.Match(want, push.Ref); !ok {
+		return &IgnoredError{Reason: push.Ref + " does not match " + want}
+	}
+
+	// Other sources (such as oci) have refs of their own, a push only triggers a deploy of them
+	if cfg.Src.Type == "git" {
+		cfg.Src.Ref = push.Ref
+		cfg.Commit = push.After
+	}
+
+	return nil
+}
+
+// Returns a webhook source for a git forge. verify checks the request against the secret of the webhook
+func gitWebhookSource(typ, eventHeader string, verify func(secret string, r *http.Request, body []byte) bool) func(r *http.Request, cfg *DeployMeta, wid, id string) (string, error) {
+	return func(r *http.Request, cfg *DeployMeta, wid, id string) (string, error) {
+		var webh *DeployWebhook
+
+		for _, w := range cfg.Webhooks {
+			if w.Type == typ && w.Id == wid {
+				webh = w
+				break
+			}
+		}
+
+		if webh == nil || webh.Token == "" {
+			return "", errors.New("invalid webhook")
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+
+		if err != nil {
+			return "", errors.New("Failed to read webhook: " + err.Error())
+		}
+
+		if len(body) > maxWebhookBody {
+			return "", errors.New("webhook payload too large")
+		}
+
+		if !verify(webh.Token, r, body) {
+			return "", errors.New("invalid signature")
+		}
+
+		event := normaliseEvent(r.Header.Get(eventHeader))
+
+		events := webh.Events
+
+		if len(events) == 0 {
+			events = defaultWebhookEvents
+		}
+
+		if !slices.Contains(events, event) {
+			return "", &IgnoredError{Reason: "event " + event + " is not enabled for this webhook"}
+		}
+
+		// GitHub can also send the payload as a form
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			form, err := url.ParseQuery(string(body))
+
+			if err != nil {
+				return "", errors.New("Failed to parse webhook form: " + err.Error())
+			}
+
+			body = []byte(form.Get("payload"))
+		}
+
+		var push pushEvent
+
+		err = json.Unmarshal(body, &push)
+
+		if err != nil {
+			return "", errors.New("Failed to decode webhook: " + err.Error())
+		}
+
+		err = applyPush(webh, cfg, event, push)
+
+		if err != nil {
+			return "", err
+		}
+
+		t, err := tasks.New(ID, "deploy", "webhook:"+wid)
+
+		if err != nil {
+			return "", err
+		}
+
+		go InitDeploy(t.ID, cfg)
+
+		return t.ID, nil
+	}
+}
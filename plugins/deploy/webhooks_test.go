@@ -0,0 +1,130 @@
+package deploy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestApplyPush(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+
+	push := func(ref string) pushEvent {
+		p := pushEvent{Ref: ref, After: commit}
+		p.Repository.DefaultBranch = "main"
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		webhRef string
+		src     DeploySource
+		push    pushEvent
+		ignored bool
+		ref     string // Ref of the source after the push
+		commit  string
+	}{
+		{
+			name:   "default branch",
+			src:    DeploySource{Type: "git"},
+			push:   push("refs/heads/main"),
+			ref:    "refs/heads/main",
+			commit: commit,
+		},
+		{
+			name:    "other branch",
+			src:     DeploySource{Type: "git"},
+			push:    push("refs/heads/dev"),
+			ignored: true,
+		},
+		{
+			name:   "source ref",
+			src:    DeploySource{Type: "git", Ref: "refs/heads/prod"},
+			push:   push("refs/heads/prod"),
+			ref:    "refs/heads/prod",
+			commit: commit,
+		},
+		{
+			name:    "webhook glob",
+			webhRef: "refs/tags/v*",
+			src:     DeploySource{Type: "git", Ref: "refs/heads/main"},
+			push:    push("refs/tags/v1.2.0"),
+			ref:     "refs/tags/v1.2.0",
+			commit:  commit,
+		},
+		{
+			name:    "webhook glob replaces the source ref",
+			webhRef: "refs/tags/v*",
+			src:     DeploySource{Type: "git", Ref: "refs/heads/main"},
+			push:    push("refs/heads/main"),
+			ignored: true,
+		},
+		{
+			name:    "deleted ref",
+			src:     DeploySource{Type: "git"},
+			push:    pushEvent{Ref: "refs/heads/main", After: strings.Repeat("0", 40)},
+			ignored: true,
+		},
+		{
+			name:    "oci source keeps its ref",
+			webhRef: "refs/tags/v*",
+			src:     DeploySource{Type: "oci", Url: "ghcr.io/org/app", Ref: "sha256:abc"},
+			push:    push("refs/tags/v1.2.0"),
+			ref:     "sha256:abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src
+			cfg := &DeployMeta{Src: &src}
+
+			err := applyPush(&DeployWebhook{Ref: tt.webhRef}, cfg, "push", tt.push)
+
+			var ignored *IgnoredError
+
+			if tt.ignored {
+				if !errors.As(err, &ignored) {
+					t.Fatalf("got error %v, want the push to be ignored", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.Src.Ref != tt.ref || cfg.Commit != tt.commit {
+				t.Fatalf("got ref %q and commit %q, want %q and %q", cfg.Src.Ref, cfg.Commit, tt.ref, tt.commit)
+			}
+		})
+	}
+}
+
+func TestGitSourceRejectsGlobs(t *testing.T) {
+	_, err := loadSource(t, &DeploySource{Type: "git", Url: "https://example.invalid/repo.git", Ref: "refs/tags/v*"})
+
+	if err == nil || !strings.Contains(err.Error(), "is a glob") {
+		t.Fatalf("got error %v, want the glob to be rejected", err)
+	}
+}
+
+func TestApiWebhookToken(t *testing.T) {
+	cfg := &DeployMeta{
+		Src:      &DeploySource{Type: "git"},
+		Webhooks: []*DeployWebhook{{Id: "ci", Type: "api", Token: "secret"}},
+	}
+
+	for _, query := range []string{"wid=ci", "wid=ci&token=wrong", "wid=ci&token=secre", "wid=other&token=secret"} {
+		r := httptest.NewRequest(http.MethodPost, "/createDeploy?"+query, nil)
+
+		_, err := DeployWebhookSources["api"](r, cfg, r.URL.Query().Get("wid"), "site")
+
+		if err == nil {
+			t.Errorf("%s was accepted", query)
+		}
+	}
+}
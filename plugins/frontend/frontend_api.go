@@ -15,43 +15,30 @@ func GetRegisteredLinks(r *http.Request) ([]Link, error) {
 	var reg []Link
 
 	if plugins.Enabled("acl") {
-		var aclResultCache = make(map[string]bool)
+		userId := plugins.UserID(r)
 
-		for _, link := range RegisteredLinks {
-			// Cached
-			if status, ok := aclResultCache[link.Plugin]; ok {
-				if status {
-					// Cached as true, append
-					reg = append(reg, link)
-				}
+		if userId == "" {
+			return nil, errors.New("user id is unset")
+		}
 
-				// Cached as false, continue
-				continue
-			}
+		var aclResultCache = make(map[string]bool)
 
-			userId := r.Header.Get("X-DP-UserID")
+		for _, link := range RegisteredLinks {
+			allowed, ok := aclResultCache[link.Plugin]
 
-			if userId == "" {
-				return nil, errors.New("user id is unset")
-			}
+			if !ok {
+				req, err := http.NewRequest("GET", "/api/"+link.Plugin+"/@frontend", nil)
 
-			req, err := http.NewRequest("GET", "/api/"+link.Plugin+"/@frontend", nil)
+				if err != nil {
+					return nil, errors.New("failed to check acls for " + link.Plugin)
+				}
 
-			if err != nil {
-				return nil, errors.New("failed to check acls for " + link.Plugin)
+				allowed = acl.CheckACL(userId, req).Allowed()
+				aclResultCache[link.Plugin] = allowed
 			}
 
-			chk := acl.CheckACL(userId, req)
-
-			if chk == nil {
-				// Allowed
+			if allowed {
 				reg = append(reg, link)
-				aclResultCache[link.Plugin] = true
-			} else {
-				// Disallowed
-				if len(chk.PerPlugin) > 0 || len(chk.PerRoute) > 0 {
-					aclResultCache[link.Plugin] = false
-				}
 			}
 		}
 	} else {
@@ -62,6 +49,8 @@ func GetRegisteredLinks(r *http.Request) ([]Link, error) {
 }
 
 func loadFrontendApi(r chi.Router) {
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost, "/getRegisteredLinks")
+
 	r.Post("/getRegisteredLinks", func(w http.ResponseWriter, r *http.Request) {
 		reg, err := GetRegisteredLinks(r)
 
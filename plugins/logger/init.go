@@ -1,10 +1,46 @@
 package logger
 
-import "github.com/infinitybotlist/sysmanage-web/types"
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
 
 const ID = "logger"
 
+// The logger section of config.yaml
+type Config struct {
+	AdminRoles []string `yaml:"admin_roles" default:"[admin]"` // Roles (from the acl plugin) that may cancel the tasks of other users
+}
+
+var config atomic.Pointer[Config]
+
+func loadConfig(name string) error {
+	cfg, err := plugins.DecodeConfig[Config](name)
+
+	if err != nil {
+		return errors.New("Failed to get logger config: " + err.Error())
+	}
+
+	config.Store(cfg)
+
+	return nil
+}
+
 func InitPlugin(c *types.PluginConfig) error {
+	err := loadConfig(c.Name)
+
+	if err != nil {
+		return err
+	}
+
 	loadLoggerApi(c.Mux)
+	loadStreamApi(c.StreamMux)
 	return nil
 }
+
+func Reload(c *types.PluginConfig) error {
+	return loadConfig(c.Name)
+}
@@ -2,26 +2,225 @@ package logger
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+	"github.com/infinitybotlist/sysmanage-web/plugins/acl"
+	"golang.org/x/exp/slices"
 )
 
+// How often a keepalive comment is sent on idle log streams
+const streamKeepalive = 15 * time.Second
+
+// A single event on a log stream
+type streamEvent struct {
+	Seq    int            `json:"seq"`
+	Line   string         `json:"line,omitempty"`
+	Done   bool           `json:"done"`
+	Result *logger.Result `json:"result,omitempty"`
+}
+
+// Returns the line appended to the log of a finished task
+func resultLine(result *logger.Result) string {
+	if result == nil {
+		return "\n\n=====\nTask completed"
+	}
+
+	switch result.Status {
+	case logger.ResultFailure:
+		return "\n\n=====\nTask failed: " + result.Error
+	case logger.ResultCancelled:
+		return "\n\n=====\nTask cancelled"
+	default:
+		return "\n\n=====\nTask completed successfully"
+	}
+}
+
+// Parses a offset into the log of a task, empty strings are treated as 0
+func parseOffset(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	offset, err := strconv.Atoi(s)
+
+	if err != nil || offset < 0 {
+		return 0, errors.New("offset must be a positive integer")
+	}
+
+	return offset, nil
+}
+
+// Returns the lines of a log entry from the line numbered since onwards and the number of the line after them.
+// Lines are numbered from the start of the task, so numbers stay the same when the start of a large log is dropped
+func linesSince(console logger.LogEntry, since int) ([]string, int) {
+	start := since - console.Offset
+
+	if start < 0 {
+		start = 0
+	}
+
+	if start > len(console.LastLog) {
+		start = len(console.LastLog)
+	}
+
+	return console.LastLog[start:], console.Offset + len(console.LastLog)
+}
+
+// Returns whether a user has one of the admin roles, so may cancel the tasks of others
+func isAdmin(userId string) bool {
+	cfg := config.Load()
+
+	if cfg == nil || userId == "" {
+		return false
+	}
+
+	for _, role := range acl.RolesOf(userId) {
+		if slices.Contains(cfg.AdminRoles, role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writes a event to a log stream
+func writeEvent(w io.Writer, event string, ev streamEvent) error {
+	bytes, err := json.Marshal(ev)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq+1, event, bytes)
+
+	return err
+}
+
+// Routes on the stream mux, these are not cut off by the request timeout
+func loadStreamApi(r chi.Router) {
+	// Streams a log entry as Server-Sent Events. The id of every event is its seq plus one, the offset
+	// to resume from using Last-Event-ID (or since) after a reconnect
+	r.Get("/streamLogEntry", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Missing id"))
+			return
+		}
+
+		offset := r.URL.Query().Get("since")
+
+		if r.Header.Get("Last-Event-ID") != "" {
+			offset = r.Header.Get("Last-Event-ID")
+		}
+
+		since, err := parseOffset(offset)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid since: " + err.Error()))
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Streaming is not supported"))
+			return
+		}
+
+		updates, unsubscribe := logger.Subscribe(id)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		keepalive := time.NewTicker(streamKeepalive)
+		defer keepalive.Stop()
+
+		for {
+			console := logger.LogMap.Get(id)
+
+			lines, next := linesSince(console, since)
+
+			for i, line := range lines {
+				err = writeEvent(w, "log", streamEvent{Seq: next - len(lines) + i, Line: line})
+
+				if err != nil {
+					return
+				}
+			}
+
+			since = next
+
+			if console.IsDone {
+				writeEvent(w, "done", streamEvent{Seq: since, Done: true, Result: console.Result})
+				flusher.Flush()
+				return
+			}
+
+			flusher.Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-tasks.Shutdown():
+				// Clients reconnect with Last-Event-ID once sysmanage is back
+				return
+			case <-updates:
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+		}
+	})
+}
+
 func loadLoggerApi(r chi.Router) {
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost, "/getLogEntry", "/getLogStatus", "/getTask", "/listTasks")
+
 	r.Post("/getLogEntry", func(w http.ResponseWriter, r *http.Request) {
+		since, err := parseOffset(r.URL.Query().Get("since"))
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid since: " + err.Error()))
+			return
+		}
+
 		// Fetch from logger.LogMap
 		console := logger.LogMap.Get(r.URL.Query().Get("id"))
 
+		lines, next := linesSince(console, since)
+
+		// X-Log-Length is the offset to pass as since in the next request
+		w.Header().Set("X-Log-Length", strconv.Itoa(next))
+
 		if console.IsDone {
-			console.LastLog = append(console.LastLog, "\n\n=====\nTask completed successfully")
+			// Limit capacity so append copies instead of writing into the stored log
+			lines = append(lines[:len(lines):len(lines)], resultLine(console.Result))
 			w.Header().Set("X-Is-Done", "1")
+
+			if console.Result != nil {
+				w.Header().Set("X-Task-Result", string(console.Result.Status))
+			}
 		}
 
 		w.Header().Set("X-Last-Updated", console.LastUpdate.Format(time.RFC3339))
 
-		bytes, err := json.Marshal(console.LastLog)
+		bytes, err := json.Marshal(lines)
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -31,4 +230,76 @@ func loadLoggerApi(r chi.Router) {
 
 		w.Write([]byte(bytes))
 	})
+
+	// Returns the status of a log entry (result and step timings) without its output
+	r.Post("/getLogStatus", func(w http.ResponseWriter, r *http.Request) {
+		console := logger.LogMap.Get(r.URL.Query().Get("id"))
+
+		console.LastLog = nil
+
+		bytes, err := json.Marshal(console)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to marshal log status."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+
+	r.Post("/listTasks", func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := json.Marshal(tasks.List())
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to marshal task list."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+
+	r.Post("/getTask", func(w http.ResponseWriter, r *http.Request) {
+		t, ok := tasks.Get(r.URL.Query().Get("id"))
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Task not found"))
+			return
+		}
+
+		bytes, err := json.Marshal(t)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to marshal task."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+
+	r.Post("/cancelTask", func(w http.ResponseWriter, r *http.Request) {
+		userId := plugins.UserID(r)
+
+		err := tasks.Cancel(r.URL.Query().Get("id"), userId, isAdmin(userId))
+
+		if errors.Is(err, tasks.ErrNotOwner) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
 }
@@ -0,0 +1,216 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+	"github.com/infinitybotlist/sysmanage-web/plugins/acl"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+var eventHeader = regexp.MustCompile(`id: (\d+)\nevent: (\w+)\n`)
+
+// Returns the id and type of every event on a stream as id:type
+func streamEvents(t *testing.T, r http.Handler, query, lastEventId string) string {
+	req := httptest.NewRequest(http.MethodGet, "/streamLogEntry?"+query, nil)
+
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d (%s)", w.Code, w.Body.String())
+	}
+
+	var events []string
+
+	for _, m := range eventHeader.FindAllStringSubmatch(w.Body.String(), -1) {
+		events = append(events, m[1]+":"+m[2])
+	}
+
+	return strings.Join(events, " ")
+}
+
+func TestStreamLogEntry(t *testing.T) {
+	old := logger.LogMap
+	logger.LogMap = logger.NewMemoryStore()
+
+	t.Cleanup(func() {
+		logger.LogMap = old
+	})
+
+	logger.LogMap.Add("task", "a", true)
+	logger.LogMap.Add("task", "b", true)
+	logger.LogMap.MarkDone("task", logger.Result{Status: logger.ResultSuccess})
+
+	// The first 5 lines were dropped, event ids keep counting from the start of the task
+	logger.LogMap.Set("trimmed", logger.LogEntry{LastUpdate: time.Now(), LastLog: []string{"f", "g"}, Offset: 5, IsDone: true})
+
+	r := chi.NewRouter()
+	loadStreamApi(r)
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventId string
+		events      string
+	}{
+		{name: "from the start", query: "id=task", events: "1:log 2:log 3:done"},
+		{name: "since", query: "id=task&since=1", events: "2:log 3:done"},
+		{name: "resume after last line", query: "id=task", lastEventId: "2", events: "3:done"},
+		{name: "resume after done", query: "id=task", lastEventId: "3", events: "3:done"},
+		{name: "trimmed from the start", query: "id=trimmed", events: "6:log 7:log 8:done"},
+		{name: "trimmed resume", query: "id=trimmed", lastEventId: "6", events: "7:log 8:done"},
+		{name: "trimmed resume past dropped lines", query: "id=trimmed&since=2", events: "6:log 7:log 8:done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := streamEvents(t, r, tt.query, tt.lastEventId); got != tt.events {
+				t.Fatalf("got events %q, want %q", got, tt.events)
+			}
+		})
+	}
+}
+
+func TestCancelTask(t *testing.T) {
+	state.Context = context.Background()
+	state.SetConfig(&types.Config{
+		Plugins: map[string]map[string]any{
+			ID: {"admin_roles": []any{"ops"}},
+			acl.ID: {
+				"roles":    map[string]any{"ops": map[string]any{}},
+				"bindings": map[string]any{"carol": []any{"ops"}},
+			},
+		},
+	})
+
+	err := acl.Preload(&types.PluginConfig{Name: acl.ID})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = acl.InitPlugin(&types.PluginConfig{Name: acl.ID, Mux: chi.NewRouter()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+
+	err = InitPlugin(&types.PluginConfig{Name: ID, Mux: r, StreamMux: r})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		status int
+	}{
+		{name: "owner", user: "alice", status: http.StatusNoContent},
+		{name: "other user", user: "bob", status: http.StatusForbidden},
+		{name: "admin role", user: "carol", status: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task, err := tasks.New(ID, "test", "alice")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/cancelTask?id="+task.ID, nil)
+			req = plugins.WithPrincipal(req, &plugins.Principal{ID: tt.user})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d (%s), want %d", w.Code, w.Body.String(), tt.status)
+			}
+		})
+	}
+}
+
+func TestGetLogEntryOffset(t *testing.T) {
+	old := logger.LogMap
+	logger.LogMap = logger.NewMemoryStore()
+
+	t.Cleanup(func() {
+		logger.LogMap = old
+	})
+
+	logger.LogMap.Set("trimmed", logger.LogEntry{LastUpdate: time.Now(), LastLog: []string{"f", "g"}, Offset: 5})
+
+	r := chi.NewRouter()
+	loadLoggerApi(r)
+
+	tests := []struct {
+		since  string
+		body   string
+		length string
+	}{
+		{since: "0", body: `["f","g"]`, length: "7"},
+		{since: "6", body: `["g"]`, length: "7"},
+		{since: "7", body: `[]`, length: "7"},
+		{since: "9", body: `[]`, length: "7"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/getLogEntry?id=trimmed&since="+tt.since, nil))
+
+		if got := strings.TrimSpace(w.Body.String()); got != tt.body || w.Header().Get("X-Log-Length") != tt.length {
+			t.Fatalf("since %s: got %s with length %s, want %s with length %s", tt.since, got, w.Header().Get("X-Log-Length"), tt.body, tt.length)
+		}
+	}
+}
+
+// Shuts tasks down for the rest of the package, so this must stay the last test
+func TestStreamEndsOnShutdown(t *testing.T) {
+	old := logger.LogMap
+	logger.LogMap = logger.NewMemoryStore()
+
+	t.Cleanup(func() {
+		logger.LogMap = old
+	})
+
+	logger.LogMap.Add("running", "a", true)
+
+	r := chi.NewRouter()
+	loadStreamApi(r)
+
+	done := make(chan string)
+
+	go func() {
+		done <- streamEvents(t, r, "id=running", "")
+	}()
+
+	tasks.BeginShutdown()
+
+	select {
+	case got := <-done:
+		if got != "1:log" {
+			t.Fatalf("got events %q, want %q", got, "1:log")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end on shutdown")
+	}
+}
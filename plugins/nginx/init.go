@@ -45,25 +45,19 @@ func InitPlugin(c *types.PluginConfig) error {
 
 	nginxTemplate = string(bytes)
 
-	cfgData, err := plugins.GetConfig(c.Name)
+	cfg, err := plugins.DecodeConfig[Config](c.Name)
 
 	if err != nil {
 		return errors.New("Failed to get nginx config: " + err.Error())
 	}
 
-	nginxDefinitions, err = cfgData.GetString("nginx_definitions")
+	nginxDefinitions = cfg.NginxDefinitions
 
-	if err != nil {
-		return err
-	}
-
-	cfApiToken, err := cfgData.GetString("cf_api_token")
-
-	if err == nil {
-		api, err := cloudflare.NewWithAPIToken(cfApiToken)
+	if cfg.CfApiToken != "" {
+		api, err := cloudflare.NewWithAPIToken(cfg.CfApiToken)
 
 		if err != nil {
-			panic(err)
+			return errors.New("Failed to create cloudflare client: " + err.Error())
 		}
 
 		cf = api
@@ -71,7 +65,7 @@ func InitPlugin(c *types.PluginConfig) error {
 		setupCf()
 	}
 
-	cfIp, _ = cfgData.GetString("cf_ip")
+	cfIp = cfg.CfIp
 
 	loadNginxApi(c.Mux)
 
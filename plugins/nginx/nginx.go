@@ -102,22 +102,22 @@ func getNginxDomainList() ([]NginxServerManage, error) {
 	return servers, nil
 }
 
-func buildNginx(reqId string) {
-	defer logger.LogMap.MarkDone(reqId)
-
+// Builds the nginx config and reloads nginx, logging to reqId. Use tasks.Run to run this as a task
+func BuildNginx(reqId string) error {
+	logger.LogMap.Step(reqId, "Waiting for builds")
 	logger.LogMap.Add(reqId, "Waiting for other builds to finish...", true)
 
 	state.LsOp.Lock()
 	defer state.LsOp.Unlock()
 
+	logger.LogMap.Step(reqId, "Generating config")
 	logger.LogMap.Add(reqId, "Starting build process to convert nginx templates to nginx config files...", true)
 
 	// First load in the _meta.yaml file from the folder
 	open, err := os.Open(nginxDefinitions + "/_meta.yaml")
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to open _meta.yaml file in "+nginxDefinitions, true)
-		return
+		return errors.New("ERROR: Failed to open _meta.yaml file in " + nginxDefinitions)
 	}
 
 	var meta NginxMeta
@@ -125,24 +125,21 @@ func buildNginx(reqId string) {
 	err = yaml.NewDecoder(open).Decode(&meta)
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to decode _meta.yaml file in "+nginxDefinitions+": "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to decode _meta.yaml file in " + nginxDefinitions + ": " + err.Error())
 	}
 
 	// Validate meta
 	err = state.Validator.Struct(meta)
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to validate _meta.yaml file in "+nginxDefinitions+": "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to validate _meta.yaml file in " + nginxDefinitions + ": " + err.Error())
 	}
 
 	// Next load every nginx definition in the folder
 	fsd, err := os.ReadDir(nginxDefinitions)
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to read nginx definition "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to read nginx definition " + err.Error())
 	}
 
 	var nginxTemplate = template.Must(
@@ -197,28 +194,24 @@ func buildNginx(reqId string) {
 
 		// Ensure certfile and keyfile exist
 		if _, err := os.Stat(certFile); os.IsNotExist(err) {
-			logger.LogMap.Add(reqId, "SANITY FAILED: Failed to find required certfile "+certFile, true)
-			return
+			return errors.New("SANITY FAILED: Failed to find required certfile " + certFile)
 		}
 
 		if _, err := os.Stat(keyFile); os.IsNotExist(err) {
-			logger.LogMap.Add(reqId, "SANITY FAILED: Failed to find required keyfile "+keyFile, true)
-			return
+			return errors.New("SANITY FAILED: Failed to find required keyfile " + keyFile)
 		}
 
 		// Try parsing certfile and keyfile
 		_, err = tls.LoadX509KeyPair(certFile, keyFile)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "SANITY FAILED: Failed to parse certfile "+certFile+" and keyfile "+keyFile+": "+err.Error(), true)
-			return
+			return errors.New("SANITY FAILED: Failed to parse certfile " + certFile + " and keyfile " + keyFile + ": " + err.Error())
 		}
 
 		open, err := os.Open(nginxDefinitions + "/" + file.Name())
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to open nginx definition "+file.Name()+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to open nginx definition " + file.Name() + ": " + err.Error())
 		}
 
 		defer open.Close()
@@ -228,8 +221,7 @@ func buildNginx(reqId string) {
 		err = yaml.NewDecoder(open).Decode(&nginxCfg)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to decode nginx definition "+file.Name()+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to decode nginx definition " + file.Name() + ": " + err.Error())
 		}
 
 		if len(nginxCfg.Servers) == 0 {
@@ -241,8 +233,7 @@ func buildNginx(reqId string) {
 		err = state.Validator.Struct(nginxCfg)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to validate nginx definition "+file.Name()+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to validate nginx definition " + file.Name() + ": " + err.Error())
 		}
 
 		// Create file
@@ -250,8 +241,7 @@ func buildNginx(reqId string) {
 		out, err := os.Create("/etc/nginx/conf.d/" + outFile)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to create config file "+outFile+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to create config file " + outFile + ": " + err.Error())
 		}
 
 		defer out.Close()
@@ -274,13 +264,14 @@ func buildNginx(reqId string) {
 		})
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to execute nginx template "+outFile+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to execute nginx template " + outFile + ": " + err.Error())
 		}
 
 		logger.LogMap.Add(reqId, "Created nginx file /etc/nginx/conf.d/"+outFile, true)
 	}
 
+	logger.LogMap.Step(reqId, "Validating config")
+
 	// Run nginx -t to validate config
 	cmd := exec.Command("nginx", "-t")
 
@@ -290,10 +281,11 @@ func buildNginx(reqId string) {
 	err = cmd.Run()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to validate nginx config: "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to validate nginx config: " + err.Error())
 	}
 
+	logger.LogMap.Step(reqId, "Restarting nginx")
+
 	// Restart nginx
 	cmd = exec.Command("systemctl", "restart", "nginx")
 
@@ -303,11 +295,12 @@ func buildNginx(reqId string) {
 	err = cmd.Run()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to restart nginx: "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to restart nginx: " + err.Error())
 	}
 
 	logger.LogMap.Add(reqId, "Restarted nginx", true)
+
+	return nil
 }
 
 // Get preferred outbound ip of this machine
@@ -323,12 +316,10 @@ func getOutboundIP() (net.IP, error) {
 	return localAddr.IP, nil
 }
 
-func updateDnsRecordCf(reqId string) {
-	defer logger.LogMap.MarkDone(reqId)
-
+func updateDnsRecordCf(reqId string) error {
 	if cf == nil {
 		logger.LogMap.Add(reqId, "Not updating DNS, CF is disabled!", true)
-		return
+		return nil
 	}
 
 	logger.LogMap.Add(reqId, "Updating DNS record", true)
@@ -341,7 +332,7 @@ func updateDnsRecordCf(reqId string) {
 		ip, err = getOutboundIP()
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "Failed to get IP address:"+err.Error(), true)
+			return errors.New("Failed to get IP address:" + err.Error())
 		}
 
 		logger.LogMap.Add(reqId, "Current IP address is "+ip.String(), true)
@@ -349,7 +340,7 @@ func updateDnsRecordCf(reqId string) {
 		ip = net.ParseIP(cfIp)
 
 		if ip == nil {
-			logger.LogMap.Add(reqId, "Failed to get IP address:"+err.Error(), true)
+			return errors.New("Failed to get IP address: invalid cf_ip " + cfIp)
 		}
 	}
 
@@ -357,9 +348,12 @@ func updateDnsRecordCf(reqId string) {
 	srv, err := getNginxDomainList()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "Failed to get nginx domain list:"+err.Error(), true)
+		return errors.New("Failed to get nginx domain list:" + err.Error())
 	}
 
+	// Failing records are skipped but fail the task at the end
+	var failed int
+
 	for _, s := range srv {
 		for _, serverName := range s.Server.Servers {
 			if _, ok := zoneMap[s.Domain]; !ok {
@@ -381,11 +375,13 @@ func updateDnsRecordCf(reqId string) {
 
 				if err != nil {
 					logger.LogMap.Add(reqId, "Failed to list DNS records for "+domExpanded+": "+err.Error(), true)
+					failed++
 					continue
 				}
 
 				if len(records) > 1 {
 					logger.LogMap.Add(reqId, "Found multiple records for "+domExpanded+", skipping... len="+strconv.Itoa(len(records)), true)
+					failed++
 					continue
 				}
 
@@ -407,6 +403,7 @@ func updateDnsRecordCf(reqId string) {
 
 					if err != nil {
 						logger.LogMap.Add(reqId, "Failed to update DNS record for "+domExpanded+": "+err.Error(), true)
+						failed++
 						continue
 					}
 				} else {
@@ -426,22 +423,26 @@ func updateDnsRecordCf(reqId string) {
 
 					if err != nil {
 						logger.LogMap.Add(reqId, "Failed to create DNS record for "+domExpanded+": "+err.Error(), true)
+						failed++
 					}
 				}
 			}
 		}
 	}
-}
 
-func deleteDomain(reqId, domain string) {
-	defer logger.LogMap.MarkDone(reqId)
+	if failed > 0 {
+		return errors.New("Failed to update " + strconv.Itoa(failed) + " DNS record(s)")
+	}
 
+	return nil
+}
+
+func deleteDomain(reqId, domain string) error {
 	// Load meta
 	meta, err := loadNginxMeta()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "Failed to load nginx meta: "+err.Error(), true)
-		return
+		return errors.New("Failed to load nginx meta: " + err.Error())
 	}
 
 	certFile := meta.NginxCertPath + "/cert-" + domain + ".pem"
@@ -454,8 +455,7 @@ func deleteDomain(reqId, domain string) {
 		err = os.Remove(certFile)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "Failed to delete cert file: "+err.Error(), true)
-			return
+			return errors.New("Failed to delete cert file: " + err.Error())
 		} else {
 			logger.LogMap.Add(reqId, "Deleted cert file", true)
 		}
@@ -468,8 +468,7 @@ func deleteDomain(reqId, domain string) {
 		err = os.Remove(keyFile)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "Failed to delete key file: "+err.Error(), true)
-			return
+			return errors.New("Failed to delete key file: " + err.Error())
 		} else {
 			logger.LogMap.Add(reqId, "Deleted key file", true)
 		}
@@ -480,8 +479,7 @@ func deleteDomain(reqId, domain string) {
 	err = os.Remove("/etc/nginx/conf.d/" + outFile)
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "Failed to delete nginx config file: "+err.Error(), true)
-		return
+		return errors.New("Failed to delete nginx config file: " + err.Error())
 	}
 
 	logger.LogMap.Add(reqId, "Deleted nginx config file", true)
@@ -490,8 +488,7 @@ func deleteDomain(reqId, domain string) {
 	err = os.Remove(nginxDefinitions + "/" + domain + ".yaml")
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to delete YAML config: "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to delete YAML config: " + err.Error())
 	}
 
 	// TODO: Here, consider removing cloudflare domains?
@@ -506,8 +503,7 @@ func deleteDomain(reqId, domain string) {
 	err = cmd.Run()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to validate nginx config: "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to validate nginx config: " + err.Error())
 	}
 
 	// Restart nginx
@@ -519,9 +515,10 @@ func deleteDomain(reqId, domain string) {
 	err = cmd.Run()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to restart nginx: "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to restart nginx: " + err.Error())
 	}
 
 	logger.LogMap.Add(reqId, "Restarted nginx", true)
+
+	return nil
 }
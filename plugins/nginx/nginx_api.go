@@ -10,28 +10,47 @@ import (
 	"os"
 	"strings"
 
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
 	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
 	"github.com/infinitybotlist/sysmanage-web/plugins/persist"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/infinitybotlist/eureka/crypto"
 	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 )
 
 func loadNginxApi(r chi.Router) {
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost, "/getCertList", "/getDomainList")
+
 	r.Post("/buildNginx", func(w http.ResponseWriter, r *http.Request) {
-		reqId := crypto.RandString(64)
+		t, err := tasks.New(ID, "buildNginx", plugins.UserID(r))
+
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		reqId := t.ID
 
-		go buildNginx(reqId)
+		go tasks.Run(reqId, BuildNginx)
 
 		w.Write([]byte(reqId))
 	})
 
 	r.Post("/updateDnsRecordCf", func(w http.ResponseWriter, r *http.Request) {
-		reqId := crypto.RandString(64)
+		t, err := tasks.New(ID, "updateDnsRecordCf", plugins.UserID(r))
+
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		reqId := t.ID
 
-		go updateDnsRecordCf(reqId)
+		go tasks.Run(reqId, updateDnsRecordCf)
 
 		w.Write([]byte(reqId))
 	})
@@ -397,12 +416,23 @@ func loadNginxApi(r chi.Router) {
 		if domainName == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Domain must be specified"))
+			return
 		}
 
 		// create task id
-		reqId := crypto.RandString(64)
+		t, err := tasks.New(ID, "deleteDomain", plugins.UserID(r))
+
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		reqId := t.ID
 
-		go deleteDomain(reqId, domainName)
+		go tasks.Run(reqId, func(reqId string) error {
+			return deleteDomain(reqId, domainName)
+		})
 
 		w.Write([]byte(reqId))
 	})
@@ -1,5 +1,12 @@
 package nginx
 
+// The nginx section of config.yaml
+type Config struct {
+	NginxDefinitions string `yaml:"nginx_definitions" validate:"required"`
+	CfApiToken       string `yaml:"cf_api_token"` // Optional, DNS records are not managed if unset
+	CfIp             string `yaml:"cf_ip"`        // Optional, the IP address to point DNS records to. Defaults to the public IP of the server
+}
+
 type NginxServerManage struct {
 	Domain string    `validate:"required"`
 	Server NginxYaml `validate:"required"`
@@ -17,41 +17,29 @@ var UseTokenAuth bool
 
 const ID = "persist"
 
-func InitPlugin(c *types.PluginConfig) error {
-	cfgData, err := plugins.GetConfig(c.Name)
-
-	if err != nil {
-		return errors.New("Failed to get nginx config: " + err.Error())
-	}
-
-	Author, err = cfgData.GetString("author")
-
-	if err != nil {
-		fmt.Println("INFO: No author set for persist plugin, defaulting to sysmanage-web[auto]")
-		Author = "sysmanage-web[auto]"
-	}
-
-	UseTokenAuth, err = cfgData.GetBool("use_token_auth")
-
-	if err != nil {
-		fmt.Println("INFO: No use_token_auth set for persist plugin, defaulting to false")
-	}
+// The persist section of config.yaml
+type Config struct {
+	Author       string `yaml:"author" default:"sysmanage-web[auto]"`
+	UseTokenAuth bool   `yaml:"use_token_auth"`
+	Password     string `yaml:"password"`
+	Username     string `yaml:"username" validate:"required_unless=UseTokenAuth true"` // Defaults to password when using token auth
+}
 
-	Password, err = cfgData.GetString("password")
+func InitPlugin(c *types.PluginConfig) error {
+	cfg, err := plugins.DecodeConfig[Config](c.Name)
 
 	if err != nil {
-		return err
+		return errors.New("Failed to get persist config: " + err.Error())
 	}
 
-	Username, err = cfgData.GetString("username")
+	Author = cfg.Author
+	UseTokenAuth = cfg.UseTokenAuth
+	Password = cfg.Password
+	Username = cfg.Username
 
-	if err != nil {
-		if UseTokenAuth {
-			fmt.Println("INFO: No username set for persist plugin, defaulting to password")
-			Username = Password
-		} else {
-			return err
-		}
+	if Username == "" {
+		fmt.Println("INFO: No username set for persist plugin, defaulting to password")
+		Username = Password
 	}
 
 	if !UseTokenAuth {
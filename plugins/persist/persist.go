@@ -87,7 +87,7 @@ func PersistToGit(logId string) error {
 			return nil
 		}
 	} else {
-		logger.LogMap.Add(logId, "FATAL: Error getting git status - "+err.Error(), true)
+		return errors.New("FATAL: Error getting git status - " + err.Error())
 	}
 
 	// Add all changes to the staging area
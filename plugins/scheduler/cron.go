@@ -0,0 +1,232 @@
+package scheduler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How far ahead to look for the next run before giving up (e.g. for 0 0 30 2 *)
+const maxLookahead = 5
+
+// A parsed cron expression, each field is a bitset of the allowed values
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+
+	// Whether the day of month and day of week fields started with a *. As in
+	// vixie cron, a day matches if either field matches when both are restricted
+	domStar, dowStar bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dowNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Parses a standard 5 field cron expression (minute hour day-of-month month day-of-week)
+// or one of the @yearly, @monthly, @weekly, @daily and @hourly macros
+func parseCron(spec string) (*cronExpr, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := cronMacros[spec]
+
+		if !ok {
+			return nil, errors.New("unknown macro " + spec)
+		}
+
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+
+	if len(fields) != 5 {
+		return nil, errors.New("expected 5 fields, got " + strconv.Itoa(len(fields)))
+	}
+
+	var (
+		c   cronExpr
+		err error
+	)
+
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, errors.New("minute: " + err.Error())
+	}
+
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, errors.New("hour: " + err.Error())
+	}
+
+	if c.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, errors.New("day of month: " + err.Error())
+	}
+
+	if c.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, errors.New("month: " + err.Error())
+	}
+
+	// 7 is also sunday
+	if c.dow, err = parseField(fields[4], 0, 7, dowNames); err != nil {
+		return nil, errors.New("day of week: " + err.Error())
+	}
+
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+
+	return &c, nil
+}
+
+// Parses a comma separated list of values, ranges (a-b) and steps (*/n, a-b/n, a/n)
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+
+			if err != nil || step < 1 {
+				return 0, errors.New("invalid step " + stepStr)
+			}
+		}
+
+		var lo, hi int
+
+		switch {
+		case rng == "*":
+			lo, hi = min, max
+		case strings.Contains(rng, "-"):
+			loStr, hiStr, _ := strings.Cut(rng, "-")
+
+			var err error
+
+			if lo, err = parseValue(loStr, min, max, names); err != nil {
+				return 0, err
+			}
+
+			if hi, err = parseValue(hiStr, min, max, names); err != nil {
+				return 0, err
+			}
+
+			if lo > hi {
+				return 0, errors.New("invalid range " + rng)
+			}
+		default:
+			var err error
+
+			if lo, err = parseValue(rng, min, max, names); err != nil {
+				return 0, err
+			}
+
+			hi = lo
+
+			// a/n means every n starting at a
+			if hasStep {
+				hi = max
+			}
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+
+	if err != nil {
+		return 0, errors.New("invalid value " + s)
+	}
+
+	if v < min || v > max {
+		return 0, errors.New(s + " is out of range (" + strconv.Itoa(min) + "-" + strconv.Itoa(max) + ")")
+	}
+
+	return v, nil
+}
+
+func (c *cronExpr) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// Returns next if it is after t. Otherwise next was normalized into the past by a DST change
+// (e.g. 02:00 becoming 01:00 on the day clocks are moved forward) and t is moved a minute forward instead
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+
+	return t.Add(time.Minute)
+}
+
+// Returns the first time matching the expression strictly after t, in the location of t.
+// Returns the zero time if there is no such time within the next few years
+func (c *cronExpr) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	limit := t.Year() + maxLookahead
+
+	for t.Year() <= limit {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
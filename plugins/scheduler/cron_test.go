@@ -0,0 +1,197 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns the values set in a field bitset
+func bitsOf(values ...int) uint64 {
+	var bits uint64
+
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+
+	return bits
+}
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		names    map[string]int
+		want     uint64
+		err      string
+	}{
+		{field: "*", min: 1, max: 12, want: bitsOf(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)},
+		{field: "5", min: 0, max: 59, want: bitsOf(5)},
+		{field: "1-4", min: 0, max: 23, want: bitsOf(1, 2, 3, 4)},
+		{field: "*/15", min: 0, max: 59, want: bitsOf(0, 15, 30, 45)},
+		{field: "10-20/5", min: 0, max: 59, want: bitsOf(10, 15, 20)},
+		{field: "50/4", min: 0, max: 59, want: bitsOf(50, 54, 58)},
+		{field: "1,3,5-6", min: 0, max: 6, want: bitsOf(1, 3, 5, 6)},
+		{field: "jan,MAR-may", min: 1, max: 12, names: monthNames, want: bitsOf(1, 3, 4, 5)},
+		{field: "mon-fri", min: 0, max: 7, names: dowNames, want: bitsOf(1, 2, 3, 4, 5)},
+		{field: "60", min: 0, max: 59, err: "60 is out of range (0-59)"},
+		{field: "0", min: 1, max: 31, err: "0 is out of range (1-31)"},
+		{field: "5-1", min: 0, max: 59, err: "invalid range 5-1"},
+		{field: "*/0", min: 0, max: 59, err: "invalid step 0"},
+		{field: "*/x", min: 0, max: 59, err: "invalid step x"},
+		{field: "foo", min: 1, max: 12, names: monthNames, err: "invalid value foo"},
+		{field: "1,", min: 0, max: 59, err: "invalid value "},
+	}
+
+	for _, tt := range tests {
+		got, err := parseField(tt.field, tt.min, tt.max, tt.names)
+
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseField(%q) error = %v, want %s", tt.field, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("parseField(%q) = %b, %v, want %b", tt.field, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  string
+	}{
+		{spec: "0 3 * * *"},
+		{spec: "@daily"},
+		{spec: " */5 * * * mon-fri "},
+		{spec: "@fortnightly", err: "unknown macro @fortnightly"},
+		{spec: "* * * *", err: "expected 5 fields, got 4"},
+		{spec: "* * * * * *", err: "expected 5 fields, got 6"},
+		{spec: "* 24 * * *", err: "hour: 24 is out of range (0-23)"},
+		{spec: "* * * 13 *", err: "month: 13 is out of range (1-12)"},
+		{spec: "* * * * 8", err: "day of week: 8 is out of range (0-7)"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseCron(tt.spec)
+
+		if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("parseCron(%q) error = %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+
+	// 7 is sunday as well as 0
+	c, err := parseCron("0 0 * * 7")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.dow&bitsOf(0) == 0 {
+		t.Fatalf("day of week 7 does not match sunday")
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return v
+	}
+
+	local := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, ny)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return v
+	}
+
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  time.Time // Zero if the expression never matches
+	}{
+		{name: "next minute", spec: "* * * * *", after: utc("2026-01-01 10:00"), want: utc("2026-01-01 10:01")},
+		{name: "strictly after", spec: "0 3 * * *", after: utc("2026-01-01 03:00"), want: utc("2026-01-02 03:00")},
+		{name: "seconds are dropped", spec: "* * * * *", after: utc("2026-01-01 10:00").Add(59 * time.Second), want: utc("2026-01-01 10:01")},
+		{name: "step", spec: "*/20 * * * *", after: utc("2026-01-01 10:41"), want: utc("2026-01-01 11:00")},
+		{name: "range with step", spec: "0 9-17/4 * * *", after: utc("2026-01-01 13:00"), want: utc("2026-01-01 17:00")},
+		{name: "start with step", spec: "0 22/1 * * *", after: utc("2026-01-01 10:00"), want: utc("2026-01-01 22:00")},
+		{name: "list", spec: "15,45 * * * *", after: utc("2026-01-01 10:20"), want: utc("2026-01-01 10:45")},
+		{name: "month name", spec: "0 0 1 jun *", after: utc("2026-01-01 10:00"), want: utc("2026-06-01 00:00")},
+		{name: "day name", spec: "0 0 * * fri", after: utc("2026-01-01 10:00"), want: utc("2026-01-02 00:00")},  // 2026-01-01 is a thursday
+		{name: "7 is sunday", spec: "0 0 * * 7", after: utc("2026-01-01 10:00"), want: utc("2026-01-04 00:00")}, // 2026-01-04 is a sunday
+		{name: "day of week range over sunday", spec: "0 0 * * 6-7", after: utc("2026-01-04 10:00"), want: utc("2026-01-10 00:00")},
+		// With both restricted either may match: the 13th (a tuesday) comes before the next friday
+		{name: "day of month or day of week", spec: "0 0 13 * fri", after: utc("2026-01-10 00:00"), want: utc("2026-01-13 00:00")},
+		{name: "day of week or day of month", spec: "0 0 20 * fri", after: utc("2026-01-10 00:00"), want: utc("2026-01-16 00:00")},
+		// A * in either field means only the other one restricts the day
+		{name: "star day of month", spec: "0 0 * * fri", after: utc("2026-01-10 00:00"), want: utc("2026-01-16 00:00")},
+		{name: "stepped star day of week", spec: "0 0 13 * */1", after: utc("2026-01-10 00:00"), want: utc("2026-01-13 00:00")},
+		{name: "month rollover", spec: "0 0 1 * *", after: utc("2026-01-31 23:59"), want: utc("2026-02-01 00:00")},
+		{name: "short month is skipped", spec: "0 0 31 * *", after: utc("2026-01-31 00:00"), want: utc("2026-03-31 00:00")},
+		{name: "year rollover", spec: "0 0 1 1 *", after: utc("2026-12-31 23:59"), want: utc("2027-01-01 00:00")},
+		{name: "leap day", spec: "0 0 29 2 *", after: utc("2026-01-01 00:00"), want: utc("2028-02-29 00:00")},
+		{name: "impossible date", spec: "0 0 30 2 *", after: utc("2026-01-01 00:00")},
+		{name: "april 31", spec: "0 0 31 4 *", after: utc("2026-01-01 00:00")},
+		{name: "location is kept", spec: "0 9 * * *", after: local("2026-01-01 10:00"), want: local("2026-01-02 09:00")},
+		// On 2026-03-08 clocks go from 02:00 to 03:00, so 02:00 is normalized back to 01:00
+		{name: "spring forward over the gap", spec: "30 3 * * *", after: local("2026-03-08 01:30"), want: local("2026-03-08 03:30")},
+		{name: "spring forward skipped hour", spec: "30 2 * * *", after: local("2026-03-08 01:30"), want: local("2026-03-09 02:30")},
+		{name: "spring forward minutes", spec: "*/15 * * * *", after: local("2026-03-08 01:50"), want: local("2026-03-08 03:00")},
+		// On 2026-11-01 clocks go from 02:00 back to 01:00
+		{name: "fall back", spec: "0 3 * * *", after: local("2026-11-01 00:30"), want: local("2026-11-01 03:00")},
+		{name: "fall back minutes", spec: "*/30 * * * *", after: local("2026-11-01 01:45"), want: local("2026-11-01 01:45").Add(15 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.spec)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := c.next(tt.after)
+
+			if !got.Equal(tt.want) {
+				t.Fatalf("next(%s) = %s, want %s", tt.after, got, tt.want)
+			}
+
+			if !got.IsZero() && got.Location() != tt.after.Location() {
+				t.Fatalf("got location %s, want %s", got.Location(), tt.after.Location())
+			}
+		})
+	}
+}
+
+func TestForward(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	if got := forward(t0, t0.Add(time.Hour)); !got.Equal(t0.Add(time.Hour)) {
+		t.Fatalf("got %s, want the later time", got)
+	}
+
+	for _, next := range []time.Time{t0, t0.Add(-time.Hour)} {
+		if got := forward(t0, next); !got.Equal(t0.Add(time.Minute)) {
+			t.Fatalf("forward(%s) = %s, want a minute after t", next, got)
+		}
+	}
+}
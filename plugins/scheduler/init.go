@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"errors"
+
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+	"github.com/infinitybotlist/sysmanage-web/plugins/frontend"
+	"github.com/infinitybotlist/sysmanage-web/types"
+)
+
+const ID = "scheduler"
+
+// Loads the scheduler section of config.yaml along with the schedules file
+func loadConfig(name string) error {
+	cfg, err := plugins.DecodeConfig[Config](name)
+
+	if err != nil {
+		return errors.New("Failed to get scheduler config: " + err.Error())
+	}
+
+	return loadSchedules(cfg.SchedulesFile)
+}
+
+func InitPlugin(c *types.PluginConfig) error {
+	// Register links
+	frontend.AddLink(c, frontend.Link{
+		Title:       "Scheduler",
+		Description: "View, pause and trigger scheduled actions, deploys and builds.",
+		LinkText:    "View Schedules",
+		Href:        "@root",
+	})
+
+	err := loadConfig(c.Name)
+
+	if err != nil {
+		return err
+	}
+
+	loadSchedulerApi(c.Mux)
+
+	return nil
+}
+
+// Re-reads the schedules file, runs in progress are not affected
+func Reload(c *types.PluginConfig) error {
+	return loadConfig(c.Name)
+}
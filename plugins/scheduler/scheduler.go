@@ -0,0 +1,412 @@
+package scheduler
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+	"github.com/infinitybotlist/sysmanage-web/plugins/actions"
+	"github.com/infinitybotlist/sysmanage-web/plugins/deploy"
+	"github.com/infinitybotlist/sysmanage-web/plugins/nginx"
+	"github.com/infinitybotlist/sysmanage-web/plugins/systemd"
+	"golang.org/x/exp/slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Number of runs kept in the history of each schedule
+const historySize = 20
+
+// How long the scheduler sleeps at most before checking the clock again, so that
+// clock changes and suspends do not delay runs for long
+const maxSleep = time.Minute
+
+type entry struct {
+	Schedule
+	expr *cronExpr
+	loc  *time.Location
+
+	// Held while checking for and starting a run so a schedule never runs twice at once
+	runLock sync.Mutex
+	stop    chan struct{}
+
+	// Guarded by schedLock
+	paused     bool
+	filePaused bool // Paused as set in the schedules file
+	nextRun    time.Time
+	history    []Run
+}
+
+var (
+	// Guards schedules and the mutable fields of each entry
+	schedLock sync.Mutex
+	schedules []*entry
+)
+
+func newEntry(s Schedule) (*entry, error) {
+	err := state.Validator.Struct(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := parseCron(s.Cron)
+
+	if err != nil {
+		return nil, errors.New("invalid cron expression: " + err.Error())
+	}
+
+	loc := time.Local
+
+	if s.Timezone != "" {
+		loc, err = time.LoadLocation(s.Timezone)
+
+		if err != nil {
+			return nil, errors.New("invalid timezone: " + err.Error())
+		}
+	}
+
+	if s.Type != ScheduleAction && len(s.Params) > 0 {
+		return nil, errors.New("params can only be set on action schedules")
+	}
+
+	return &entry{
+		Schedule:   s,
+		expr:       expr,
+		loc:        loc,
+		stop:       make(chan struct{}),
+		paused:     s.Paused,
+		filePaused: s.Paused,
+	}, nil
+}
+
+// Loads the schedules file and (re)starts all schedules. The history of schedules is kept
+// across reloads, as is a pause made through the API unless paused is changed in the file
+func loadSchedules(path string) error {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return errors.New("Failed to open schedules file: " + err.Error())
+	}
+
+	defer f.Close()
+
+	var list []Schedule
+
+	err = yaml.NewDecoder(f).Decode(&list)
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.New("Failed to decode schedules file: " + err.Error())
+	}
+
+	var (
+		errs    []string
+		entries []*entry
+		seen    = map[string]bool{}
+	)
+
+	for i, s := range list {
+		name := s.ID
+
+		if name == "" {
+			name = "#" + strconv.Itoa(i)
+		}
+
+		if seen[s.ID] {
+			errs = append(errs, "schedule "+name+": duplicate id")
+			continue
+		}
+
+		seen[s.ID] = true
+
+		e, err := newEntry(s)
+
+		if err != nil {
+			errs = append(errs, "schedule "+name+": "+err.Error())
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid schedules file:\n  " + strings.Join(errs, "\n  "))
+	}
+
+	schedLock.Lock()
+	defer schedLock.Unlock()
+
+	for _, old := range schedules {
+		close(old.stop)
+
+		for _, e := range entries {
+			if e.ID != old.ID {
+				continue
+			}
+
+			e.history = old.history
+
+			if e.filePaused == old.filePaused {
+				e.paused = old.paused
+			}
+		}
+	}
+
+	schedules = entries
+
+	for _, e := range entries {
+		go e.loop()
+	}
+
+	return nil
+}
+
+// Returns the schedule with the given id
+func find(id string) (*entry, bool) {
+	schedLock.Lock()
+	defer schedLock.Unlock()
+
+	for _, e := range schedules {
+		if e.ID == id {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// Waits for each run of the schedule and triggers it until the schedule is stopped
+func (e *entry) loop() {
+	after := time.Now()
+
+	for {
+		next := e.expr.next(after.In(e.loc))
+
+		schedLock.Lock()
+		e.nextRun = next
+		schedLock.Unlock()
+
+		if next.IsZero() {
+			return
+		}
+
+		for time.Now().Before(next) {
+			wait := time.Until(next)
+
+			if wait > maxSleep {
+				wait = maxSleep
+			}
+
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-e.stop:
+				timer.Stop()
+				return
+			case <-state.Context.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		after = next
+
+		if now := time.Now(); now.After(after) {
+			after = now
+		}
+
+		schedLock.Lock()
+		paused := e.paused
+		schedLock.Unlock()
+
+		if paused {
+			continue
+		}
+
+		e.trigger("cron", "")
+	}
+}
+
+// Returns the plugin that needs to be loaded to run a schedule of the given type
+func pluginOf(typ ScheduleType) string {
+	switch typ {
+	case ScheduleAction:
+		return actions.ID
+	case ScheduleDeploy:
+		return deploy.ID
+	case ScheduleBuildServices:
+		return systemd.ID
+	case ScheduleBuildNginx:
+		return nginx.ID
+	}
+
+	return ""
+}
+
+// Starts the target of the schedule, returning the ID of the started task (if any)
+func (e *entry) start(owner string) (string, error) {
+	if plugin := pluginOf(e.Type); !slices.Contains(state.LoadedPlugins, plugin) {
+		return "", errors.New("plugin " + plugin + " is not loaded")
+	}
+
+	switch e.Type {
+	case ScheduleAction:
+		resp, err := actions.Execute(e.Target, owner, e.Params)
+
+		if err != nil {
+			return "", err
+		}
+
+		if resp.TaskID == "" && resp.StatusCode >= 400 {
+			return "", errors.New(resp.Resp)
+		}
+
+		return resp.TaskID, nil
+	case ScheduleDeploy:
+		cfg, err := deploy.LoadConfig(e.Target)
+
+		if err != nil {
+			return "", err
+		}
+
+		t, err := tasks.New(deploy.ID, "deploy", owner)
+
+		if err != nil {
+			return "", err
+		}
+
+		go deploy.InitDeploy(t.ID, cfg)
+
+		return t.ID, nil
+	case ScheduleBuildServices:
+		t, err := tasks.New(systemd.ID, "buildServices", owner)
+
+		if err != nil {
+			return "", err
+		}
+
+		go tasks.Run(t.ID, systemd.BuildServices)
+
+		return t.ID, nil
+	case ScheduleBuildNginx:
+		t, err := tasks.New(nginx.ID, "buildNginx", owner)
+
+		if err != nil {
+			return "", err
+		}
+
+		go tasks.Run(t.ID, nginx.BuildNginx)
+
+		return t.ID, nil
+	}
+
+	return "", errors.New("unknown schedule type " + string(e.Type))
+}
+
+// Runs the schedule now and records the run in its history. Runs are skipped while
+// the task of the previous run is still running
+func (e *entry) trigger(trigger, userId string) Run {
+	e.runLock.Lock()
+	defer e.runLock.Unlock()
+
+	run := Run{
+		Time:    time.Now(),
+		Trigger: trigger,
+		UserID:  userId,
+	}
+
+	schedLock.Lock()
+	var last Run
+	if len(e.history) > 0 {
+		last = e.history[0]
+	}
+	schedLock.Unlock()
+
+	if t, ok := tasks.Get(last.TaskID); ok && t.Status == tasks.StatusRunning {
+		run.Status = "skipped"
+		run.Error = "previous run (task " + last.TaskID + ") is still running"
+	} else {
+		owner := userId
+
+		if owner == "" {
+			owner = "scheduler:" + e.ID
+		}
+
+		taskId, err := e.start(owner)
+
+		switch {
+		case err != nil:
+			run.Status = "failed"
+			run.Error = err.Error()
+		case taskId == "":
+			run.Status = string(tasks.StatusSucceeded)
+		default:
+			run.TaskID = taskId
+			run.Status = string(tasks.StatusRunning)
+		}
+	}
+
+	schedLock.Lock()
+	defer schedLock.Unlock()
+
+	e.history = append([]Run{run}, e.history...)
+
+	if len(e.history) > historySize {
+		e.history = e.history[:historySize]
+	}
+
+	return run
+}
+
+// Pauses or resumes a schedule until the next restart
+func (e *entry) setPaused(paused bool) {
+	schedLock.Lock()
+	defer schedLock.Unlock()
+
+	e.paused = paused
+}
+
+// Returns the status of all schedules, sorted by id
+func statusList() []ScheduleStatus {
+	schedLock.Lock()
+	defer schedLock.Unlock()
+
+	list := make([]ScheduleStatus, 0, len(schedules))
+
+	for _, e := range schedules {
+		// Update runs whose tasks have finished since
+		for i, run := range e.history {
+			if t, ok := tasks.Get(run.TaskID); ok {
+				e.history[i].Status = string(t.Status)
+				e.history[i].Error = t.Error
+			}
+		}
+
+		st := ScheduleStatus{
+			Schedule: e.Schedule,
+			Paused:   e.paused,
+			History:  append([]Run{}, e.history...),
+		}
+
+		if !e.paused && !e.nextRun.IsZero() {
+			next := e.nextRun
+			st.NextRun = &next
+		}
+
+		list = append(list, st)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Schedule.ID < list[j].Schedule.ID
+	})
+
+	return list
+}
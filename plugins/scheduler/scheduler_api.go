@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
+)
+
+func loadSchedulerApi(r chi.Router) {
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost, "/getScheduleList")
+
+	r.Post("/getScheduleList", func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := json.Marshal(statusList())
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to marshal schedules."))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+
+	r.Post("/pauseSchedule", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing id"))
+			return
+		}
+
+		var paused bool
+
+		switch r.URL.Query().Get("paused") {
+		case "true":
+			paused = true
+		case "false":
+			paused = false
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("paused must be true or false"))
+			return
+		}
+
+		e, ok := find(id)
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("schedule not found"))
+			return
+		}
+
+		e.setPaused(paused)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Post("/triggerSchedule", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing id"))
+			return
+		}
+
+		e, ok := find(id)
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("schedule not found"))
+			return
+		}
+
+		run := e.trigger("manual", plugins.UserID(r))
+
+		switch run.Status {
+		case "skipped":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(run.Error))
+			return
+		case "failed":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(run.Error))
+			return
+		}
+
+		if run.TaskID != "" {
+			w.Header().Add("X-Task-ID", run.TaskID)
+		}
+
+		w.Write([]byte(run.TaskID))
+	})
+}
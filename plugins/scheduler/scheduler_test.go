@@ -0,0 +1,148 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
+)
+
+// Loads a schedules file with the given contents, stopping its schedules once the test is done
+func writeSchedules(t *testing.T, contents string) error {
+	path := filepath.Join(t.TempDir(), "schedules.yaml")
+
+	err := os.WriteFile(path, []byte(contents), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		schedLock.Lock()
+		defer schedLock.Unlock()
+
+		for _, e := range schedules {
+			close(e.stop)
+		}
+
+		schedules = nil
+	})
+
+	return loadSchedules(path)
+}
+
+func TestLoadSchedules(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		schedules string
+		err       string
+	}{
+		{name: "empty", schedules: ""},
+		{name: "valid", schedules: "- {id: nginx, cron: '@daily', type: buildNginx, timezone: Europe/Berlin}"},
+		{name: "duplicate id", schedules: "- {id: a, cron: '@daily', type: buildNginx}\n- {id: a, cron: '@daily', type: buildNginx}", err: "schedule a: duplicate id"},
+		{name: "invalid cron", schedules: "- {id: a, cron: '0 25 * * *', type: buildNginx}", err: "schedule a: invalid cron expression: hour: 25 is out of range (0-23)"},
+		{name: "invalid timezone", schedules: "- {id: a, cron: '@daily', type: buildNginx, timezone: Nowhere/City}", err: "schedule a: invalid timezone"},
+		{name: "params on a build", schedules: "- {id: a, cron: '@daily', type: buildNginx, params: {x: 1}}", err: "schedule a: params can only be set on action schedules"},
+		{name: "missing target", schedules: "- {id: a, cron: '@daily', type: deploy}", err: "schedule a:"},
+		{name: "missing id", schedules: "- {cron: '@daily', type: buildNginx}", err: "schedule #0:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := writeSchedules(t, tt.schedules)
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsState(t *testing.T) {
+
+	err := writeSchedules(t, "- {id: a, cron: '@yearly', type: buildNginx}\n- {id: b, cron: '@yearly', type: buildNginx}")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := find("a")
+	a.setPaused(true)
+	a.trigger("manual", "alice")
+
+	// b is paused in the file now, which overrides a pause or resume made through the api
+	b, _ := find("b")
+	b.setPaused(true)
+
+	err = writeSchedules(t, "- {id: a, cron: '@yearly', type: buildNginx}\n- {id: b, cron: '@yearly', type: buildNginx, paused: true}")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := statusList()
+
+	if len(list) != 2 || !list[0].Paused || len(list[0].History) != 1 || !list[1].Paused {
+		t.Fatalf("got statuses %+v, want a to keep its pause and history and b to be paused", list)
+	}
+
+	if list[0].NextRun != nil {
+		t.Fatalf("paused schedule has a next run")
+	}
+}
+
+func TestTrigger(t *testing.T) {
+
+	err := writeSchedules(t, "- {id: nginx, cron: '@yearly', type: buildNginx}")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := find("nginx")
+
+	// The nginx plugin is not loaded in tests
+	run := e.trigger("manual", "alice")
+
+	if run.Status != "failed" || run.Error != "plugin nginx is not loaded" || run.UserID != "alice" {
+		t.Fatalf("got run %+v, want a failed run", run)
+	}
+
+	// Runs are skipped while the task of the previous run is still running
+	task, err := tasks.New(ID, "test", "alice")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tasks.Finish(task.ID, nil)
+
+	schedLock.Lock()
+	e.history[0].TaskID = task.ID
+	schedLock.Unlock()
+
+	run = e.trigger("cron", "")
+
+	if run.Status != "skipped" || !strings.Contains(run.Error, task.ID) {
+		t.Fatalf("got run %+v, want a skipped run", run)
+	}
+
+	for i := 0; i < historySize+5; i++ {
+		e.trigger("manual", "alice")
+	}
+
+	if list := statusList(); len(list[0].History) != historySize {
+		t.Fatalf("got %d runs in the history, want %d", len(list[0].History), historySize)
+	}
+}
@@ -0,0 +1,46 @@
+package scheduler
+
+import "time"
+
+// The scheduler section of config.yaml
+type Config struct {
+	SchedulesFile string `yaml:"schedules_file" validate:"required"`
+}
+
+type ScheduleType string
+
+const (
+	ScheduleAction        ScheduleType = "action"        // Executes the action named by Target
+	ScheduleDeploy        ScheduleType = "deploy"        // Deploys the deploy with the ID in Target
+	ScheduleBuildServices ScheduleType = "buildServices" // Builds the systemd services
+	ScheduleBuildNginx    ScheduleType = "buildNginx"    // Builds the nginx config
+)
+
+// A schedule in the schedules file
+type Schedule struct {
+	ID          string         `yaml:"id" validate:"required"`
+	Description string         `yaml:"description"`
+	Cron        string         `yaml:"cron" validate:"required"` // e.g. 0 3 * * * or @daily
+	Timezone    string         `yaml:"timezone"`                 // e.g. Europe/Berlin, defaults to the local timezone
+	Type        ScheduleType   `yaml:"type" validate:"required,oneof=action deploy buildServices buildNginx"`
+	Target      string         `yaml:"target" validate:"required_if=Type action,required_if=Type deploy"`
+	Params      map[string]any `yaml:"params"` // Parameters of the action, only for action schedules
+	Paused      bool           `yaml:"paused"`
+}
+
+// A run of a schedule
+type Run struct {
+	Time    time.Time
+	Trigger string // cron or manual
+	UserID  string // The user that triggered the run, empty for cron runs
+	TaskID  string // Empty if the run did not start a task
+	Status  string // Status of the task, or skipped/failed if the run did not start
+	Error   string
+}
+
+type ScheduleStatus struct {
+	Schedule Schedule
+	Paused   bool
+	NextRun  *time.Time // Unset if paused or the schedule never runs again
+	History  []Run      // Newest first
+}
@@ -22,6 +22,17 @@ var ManualSystemdExtensions = []string{
 	"target",
 }
 
+// Runs systemctl with the given arguments, logging its output to logId
+func Systemctl(logId string, args ...string) error {
+	logger.LogMap.Add(logId, "> systemctl "+strings.Join(args, " "), true)
+
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = logger.AutoLogger{ID: logId}
+	cmd.Stderr = logger.AutoLogger{ID: logId, Error: true}
+
+	return cmd.Run()
+}
+
 func GetServiceStatus(ids []string) []string {
 	ids = append([]string{"check"}, ids...)
 	cmd := exec.Command("systemctl", ids...)
@@ -126,14 +137,15 @@ func GetServiceList(getStatus bool) ([]ServiceManage, error) {
 	return services, nil
 }
 
-func BuildServices(reqId string) {
-	defer logger.LogMap.MarkDone(reqId)
-
+// Builds the systemd services from their templates, logging to reqId. Use tasks.Run to run this as a task
+func BuildServices(reqId string) error {
+	logger.LogMap.Step(reqId, "Waiting for builds")
 	logger.LogMap.Add(reqId, "Waiting for other builds to finish...", true)
 
 	state.LsOp.Lock()
 	defer state.LsOp.Unlock()
 
+	logger.LogMap.Step(reqId, "Generating units")
 	logger.LogMap.Add(reqId, "Starting build process to convert service templates to systemd services...", true)
 
 	servicesToEnable := []string{}
@@ -142,8 +154,7 @@ func BuildServices(reqId string) {
 	open, err := os.Open(serviceDefinitions + "/_meta.yaml")
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to open _meta.yaml file in "+serviceDefinitions, true)
-		return
+		return errors.New("ERROR: Failed to open _meta.yaml file in " + serviceDefinitions)
 	}
 
 	var meta MetaYAML
@@ -151,16 +162,14 @@ func BuildServices(reqId string) {
 	err = yaml.NewDecoder(open).Decode(&meta)
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to decode _meta.yaml file in "+serviceDefinitions+": "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to decode _meta.yaml file in " + serviceDefinitions + ": " + err.Error())
 	}
 
 	// Validate meta
 	err = state.Validator.Struct(meta)
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to validate _meta.yaml file in "+serviceDefinitions+": "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to validate _meta.yaml file in " + serviceDefinitions + ": " + err.Error())
 	}
 
 	var targetTemplate = template.Must(template.New("target").Parse(targetTemplate))
@@ -172,8 +181,7 @@ func BuildServices(reqId string) {
 		out, err := os.Create(outFile)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to create target file "+outFile+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to create target file " + outFile + ": " + err.Error())
 		}
 
 		defer out.Close()
@@ -181,8 +189,7 @@ func BuildServices(reqId string) {
 		err = targetTemplate.Execute(out, target)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to execute target template "+outFile+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to execute target template " + outFile + ": " + err.Error())
 		}
 
 		logger.LogMap.Add(reqId, "Created target file "+outFile, true)
@@ -192,8 +199,7 @@ func BuildServices(reqId string) {
 	fsd, err := os.ReadDir(serviceDefinitions)
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to read service definition "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to read service definition " + err.Error())
 	}
 
 	var serviceTemplate = template.Must(template.New("service").Parse(serviceTemplate))
@@ -221,8 +227,7 @@ func BuildServices(reqId string) {
 			src, err := os.Open(serviceDefinitions + "/" + file.Name())
 
 			if err != nil {
-				logger.LogMap.Add(reqId, "ERROR: Failed to open service definition "+file.Name()+": "+err.Error(), true)
-				return
+				return errors.New("ERROR: Failed to open service definition " + file.Name() + ": " + err.Error())
 			}
 
 			defer src.Close()
@@ -231,8 +236,7 @@ func BuildServices(reqId string) {
 			dst, err := os.Create(serviceOut + "/" + file.Name())
 
 			if err != nil {
-				logger.LogMap.Add(reqId, "ERROR: Failed to create service definition "+file.Name()+": "+err.Error(), true)
-				return
+				return errors.New("ERROR: Failed to create service definition " + file.Name() + ": " + err.Error())
 			}
 
 			defer dst.Close()
@@ -241,8 +245,7 @@ func BuildServices(reqId string) {
 			_, err = io.Copy(dst, src)
 
 			if err != nil {
-				logger.LogMap.Add(reqId, "ERROR: Failed to copy service definition "+file.Name()+": "+err.Error(), true)
-				return
+				return errors.New("ERROR: Failed to copy service definition " + file.Name() + ": " + err.Error())
 			}
 
 			// Enable service in systemd
@@ -253,8 +256,7 @@ func BuildServices(reqId string) {
 		open, err := os.Open(serviceDefinitions + "/" + file.Name())
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to open service definition "+file.Name()+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to open service definition " + file.Name() + ": " + err.Error())
 		}
 
 		defer open.Close()
@@ -264,16 +266,14 @@ func BuildServices(reqId string) {
 		err = yaml.NewDecoder(open).Decode(&service)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to decode service definition "+file.Name()+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to decode service definition " + file.Name() + ": " + err.Error())
 		}
 
 		// Validate service
 		err = state.Validator.Struct(service)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to validate service definition "+file.Name()+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to validate service definition " + file.Name() + ": " + err.Error())
 		}
 
 		if strings.Contains(service.Target, ".") {
@@ -311,8 +311,7 @@ func BuildServices(reqId string) {
 		out, err := os.Create(outFile)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to create service file "+outFile+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to create service file " + outFile + ": " + err.Error())
 		}
 
 		defer out.Close()
@@ -320,8 +319,7 @@ func BuildServices(reqId string) {
 		err = serviceTemplate.Execute(out, service)
 
 		if err != nil {
-			logger.LogMap.Add(reqId, "ERROR: Failed to execute service template "+outFile+": "+err.Error(), true)
-			return
+			return errors.New("ERROR: Failed to execute service template " + outFile + ": " + err.Error())
 		}
 
 		logger.LogMap.Add(reqId, "Created service file "+outFile, true)
@@ -334,6 +332,8 @@ func BuildServices(reqId string) {
 		}
 	}
 
+	logger.LogMap.Step(reqId, "Reloading systemd")
+
 	// Now we need to reload systemd
 	logger.LogMap.Add(reqId, "Reloading systemd...", true)
 
@@ -344,12 +344,13 @@ func BuildServices(reqId string) {
 	err = cmd.Run()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to reload systemd: "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to reload systemd: " + err.Error())
 	}
 
 	logger.LogMap.Add(reqId, "Finished reloading systemd.", true)
 
+	logger.LogMap.Step(reqId, "Enabling services")
+
 	// Now we need to enable the services
 	logger.LogMap.Add(reqId, "Enabling services...: "+strings.Join(servicesToEnable, ","), true)
 
@@ -363,8 +364,7 @@ func BuildServices(reqId string) {
 	err = cmd.Run()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to enable services "+strings.Join(servicesToEnable, ","), true)
-		return
+		return errors.New("ERROR: Failed to enable services " + strings.Join(servicesToEnable, ","))
 	}
 
 	logger.LogMap.Add(reqId, "Finished enabling services.", true)
@@ -382,18 +382,20 @@ func BuildServices(reqId string) {
 	err = cmd.Run()
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to disable services "+strings.Join(servicesToDisable, ","), true)
-		return
+		return errors.New("ERROR: Failed to disable services " + strings.Join(servicesToDisable, ","))
 	}
 
 	logger.LogMap.Add(reqId, "Finished disabling broken services.", true)
 
+	logger.LogMap.Step(reqId, "Persisting changes")
+
 	err = persist.PersistToGit(reqId)
 
 	if err != nil {
-		logger.LogMap.Add(reqId, "ERROR: Failed to persist to git: "+err.Error(), true)
-		return
+		return errors.New("ERROR: Failed to persist to git: " + err.Error())
 	}
 
 	logger.LogMap.Add(reqId, "Finished building services.", true)
+
+	return nil
 }
@@ -2,7 +2,6 @@ package systemd
 
 import (
 	"errors"
-	"fmt"
 	"os"
 
 	"github.com/infinitybotlist/sysmanage-web/core/plugins"
@@ -57,47 +56,18 @@ func InitPlugin(c *types.PluginConfig) error {
 
 	serviceTemplate = string(bytes)
 
-	cfgData, err := plugins.GetConfig(c.Name)
+	cfg, err := plugins.DecodeConfig[Config](c.Name)
 
 	if err != nil {
-		return errors.New("Failed to get nginx config: " + err.Error())
+		return errors.New("Failed to get systemd config: " + err.Error())
 	}
 
-	serviceDefinitions, err = cfgData.GetString("service_definitions")
-
-	if err != nil {
-		return err
-	}
-
-	serviceOut, err = cfgData.GetString("service_out")
-
-	if err != nil {
-		return err
-	}
-
-	srvModBypass, err = cfgData.GetStringArray("srv_mod_bypass")
-
-	if err != nil {
-		return err
-	}
-
-	ignoreSuffixForCopy, err = cfgData.GetBool("ignore_suffix_for_copy")
-
-	if err != nil {
-		fmt.Println("Ignoring config option ignore_suffix_for_copy: " + err.Error())
-	}
-
-	ignoreSuffixForGetServiceList, err = cfgData.GetBool("ignore_suffix_for_get_service_list")
-
-	if err != nil {
-		fmt.Println("Ignoring config option ignore_suffix_for_get_service_list: " + err.Error())
-	}
-
-	trimSuffixForManualUnits, err = cfgData.GetBool("trim_suffix_for_manual_units")
-
-	if err != nil {
-		fmt.Println("Ignoring config option trim_suffix_for_manual_units: " + err.Error())
-	}
+	serviceDefinitions = cfg.ServiceDefinitions
+	serviceOut = cfg.ServiceOut
+	srvModBypass = cfg.SrvModBypass
+	ignoreSuffixForCopy = cfg.IgnoreSuffixForCopy
+	ignoreSuffixForGetServiceList = cfg.IgnoreSuffixForGetServiceList
+	trimSuffixForManualUnits = cfg.TrimSuffixForManualUnits
 
 	loadServiceApi(c.Mux)
 
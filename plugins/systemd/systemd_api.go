@@ -1,7 +1,9 @@
 package systemd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"os/exec"
@@ -9,16 +11,24 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/infinitybotlist/eureka/crypto"
 	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 
 	"github.com/infinitybotlist/sysmanage-web/core/logger"
+	"github.com/infinitybotlist/sysmanage-web/core/plugins"
 	"github.com/infinitybotlist/sysmanage-web/core/state"
+	"github.com/infinitybotlist/sysmanage-web/core/tasks"
 	"github.com/infinitybotlist/sysmanage-web/plugins/persist"
 )
 
 func loadServiceApi(r chi.Router) {
+	plugins.AddReadOnlyRoutes(ID, http.MethodPost,
+		"/getDefinitionFolders",
+		"/getMeta",
+		"/getServiceList",
+		"/getServiceLogs",
+	)
+
 	// Returns the list of services
 	r.Post("/getServiceList", func(w http.ResponseWriter, r *http.Request) {
 		serviceList, err := GetServiceList(true)
@@ -279,10 +289,17 @@ func loadServiceApi(r chi.Router) {
 			return
 		}
 
-		logId := crypto.RandString(32)
+		t, err := tasks.New(ID, "deleteService", plugins.UserID(r))
 
-		go func() {
-			defer logger.LogMap.MarkDone(logId)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		logId := t.ID
+
+		go tasks.Run(logId, func(logId string) error {
 
 			// delete yaml file, ignore if it doesn't exist
 			logger.LogMap.Add(logId, "Deleting service file...", true)
@@ -334,9 +351,11 @@ func loadServiceApi(r chi.Router) {
 			err := persist.PersistToGit(logId)
 
 			if err != nil {
-				logger.LogMap.Add(logId, "Failed to persist to git: "+err.Error(), true)
+				return errors.New("Failed to persist to git: " + err.Error())
 			}
-		}()
+
+			return nil
+		})
 
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(logId))
@@ -376,9 +395,23 @@ func loadServiceApi(r chi.Router) {
 			return
 		}
 
-		logId := crypto.RandString(64)
+		t, err := tasks.New(ID, "getServiceLogs", plugins.UserID(r))
 
-		cmd := exec.Command("journalctl", "-u", name, "-n", "50", "-f")
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		// The tailer never ends on its own so it should not hold up shutdown
+		t.SkipDrain()
+
+		logId := t.ID
+
+		// The tailer is killed when the task is cancelled or maxOpenTime is reached
+		ctx, cancel := context.WithTimeout(t.Context(), maxOpenTime)
+
+		cmd := exec.CommandContext(ctx, "journalctl", "-u", name, "-n", "50", "-f")
 		cmd.Stdout = logger.AutoLogger{ID: logId}
 		cmd.Stderr = logger.AutoLogger{ID: logId}
 		cmd.Stdin = nil
@@ -386,28 +419,29 @@ func loadServiceApi(r chi.Router) {
 		logger.LogMap.Add(logId, "goro:start", true)
 
 		go func() {
+			defer cancel()
+
 			logger.LogMap.Add(logId, "Starting logger for "+name, true)
 
 			err := cmd.Run()
 
-			if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				logger.LogMap.Add(logId, "Max open time reached, closing log.", true)
+
+				tasks.Finish(logId, nil)
+				logger.LogMap.Set(logId, logger.LogEntry{LastUpdate: time.Now()})
+				return
+			}
+
+			if err != nil && ctx.Err() == nil {
 				logger.LogMap.Add(logId, "Failed to get logs: "+err.Error(), true)
+			} else {
+				err = nil
 			}
 
 			logger.LogMap.Add(logId, "Logger died:", true)
 
-			logger.LogMap.MarkDone(logId)
-		}()
-
-		go func() {
-			time.Sleep(maxOpenTime)
-
-			cmd.Process.Kill()
-
-			logger.LogMap.Add(logId, "Max open time reached, closing log.", true)
-
-			logger.LogMap.MarkDone(logId)
-			logger.LogMap.Set(logId, logger.LogEntry{LastUpdate: time.Now()})
+			tasks.Finish(logId, err)
 		}()
 
 		w.Write([]byte(logId))
@@ -423,9 +457,17 @@ func loadServiceApi(r chi.Router) {
 	})
 
 	r.Post("/buildServices", func(w http.ResponseWriter, r *http.Request) {
-		reqId := crypto.RandString(64)
+		t, err := tasks.New(ID, "buildServices", plugins.UserID(r))
+
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		reqId := t.ID
 
-		go BuildServices(reqId)
+		go tasks.Run(reqId, BuildServices)
 
 		w.Write([]byte(reqId))
 	})
@@ -1,5 +1,15 @@
 package systemd
 
+// The systemd section of config.yaml
+type Config struct {
+	ServiceDefinitions            string   `yaml:"service_definitions" validate:"required"`
+	ServiceOut                    string   `yaml:"service_out" validate:"required"`
+	SrvModBypass                  []string `yaml:"srv_mod_bypass"` // Services that cannot be modified or deleted through sysmanage
+	IgnoreSuffixForCopy           bool     `yaml:"ignore_suffix_for_copy"`
+	IgnoreSuffixForGetServiceList bool     `yaml:"ignore_suffix_for_get_service_list"`
+	TrimSuffixForManualUnits      bool     `yaml:"trim_suffix_for_manual_units"`
+}
+
 type ServiceManage struct {
 	Service    *TemplateYaml
 	RawService *RawService // Only set when service is not the typical yaml file format
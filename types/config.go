@@ -7,12 +7,52 @@ import (
 type Config struct {
 	Plugins map[string]map[string]any `yaml:"plugins"`
 	Port    int                       `yaml:"port"`
+	LogDir  string                    `yaml:"log_dir"` // If set, task logs are persisted to this directory
+	Audit   AuditConfig               `yaml:"audit"`
+	Auth    AuthConfig                `yaml:"auth"`
+
+	// Seconds to wait for running tasks (deploys, builds etc.) to finish on shutdown before
+	// cancelling them, defaults to 300
+	ShutdownTimeout int `yaml:"shutdown_timeout"`
+
+	// Listeners to serve on, overrides ServerMeta.Listeners and the port if set
+	Listeners []Listener `yaml:"listeners" validate:"dive"`
+}
+
+type Listener struct {
+	Type string `yaml:"type" validate:"omitempty,oneof=tcp unix"` // tcp or unix, defaults to tcp
+	Addr string `yaml:"addr" validate:"required"`                 // host:port for tcp listeners, socket path for unix listeners
+
+	// Unix listeners only
+	Mode  string `yaml:"mode"`  // File mode of the socket in octal, e.g. 0660
+	Owner string `yaml:"owner"` // User name or uid to own the socket
+	Group string `yaml:"group"` // Group name or gid to own the socket
+
+	// TCP listeners only, serves TLS if set
+	TLS *ListenerTLS `yaml:"tls"`
+}
+
+type ListenerTLS struct {
+	CertFile string `yaml:"cert_file" validate:"required"`
+	KeyFile  string `yaml:"key_file" validate:"required"`
+}
+
+type AuditConfig struct {
+	Dir      string `yaml:"dir"`       // Directory to write the audit log to. Auditing is disabled if unset
+	MaxSize  int64  `yaml:"max_size"`  // Size in bytes after which the audit log is rotated, defaults to 10 MB
+	MaxFiles int    `yaml:"max_files"` // Number of rotated audit logs to keep, defaults to 10
+}
+
+type AuthConfig struct {
+	Mode  string   `yaml:"mode" validate:"omitempty,oneof=any all"` // any (the default): the first auth plugin to accept a request wins, all: every auth plugin must accept it
+	Order []string `yaml:"order"`                                   // Auth plugins in the order they are tried, the others are tried after them in the order they are loaded
 }
 
 type PluginConfig struct {
-	Mux    chi.Router
-	RawMux *chi.Mux
-	Name   string
+	Mux       chi.Router
+	StreamMux chi.Router // Same routes as Mux but without the request timeout, for long lived requests such as event streams
+	RawMux    *chi.Mux
+	Name      string
 }
 
 type Plugin struct {
@@ -20,6 +60,7 @@ type Plugin struct {
 	Init        func(c *PluginConfig) error
 	BuildScript func(b *BuildScript) error
 	Preload     func(c *PluginConfig) error // Function to call on preload. Note that only Name and RawMux are set
+	Reload      func(c *PluginConfig) error // Optional, called after config.yaml is reloaded. Note that only Name is set
 	Frontend    Provider
 }
 
@@ -50,6 +91,7 @@ type FrontendConfig struct {
 type ServerMeta struct {
 	ConfigVersion  int
 	Port           int
+	Listeners      []Listener      // Listeners to serve on, overrides Port if set. Can be overriden in config.yaml
 	FrontendServer *FrontendServer // Leave blank to use static frontend
 	Plugins        []Plugin        // List of plugins to load
 	Frontend       FrontendConfig